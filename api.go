@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type apiRoute struct {
+	method  string
+	handler http.Handler
+}
+
+func route(method string, handler http.Handler) apiRoute {
+	return apiRoute{method: method, handler: handler}
+}
+
+// handleResource registers every method of a resource under a Go 1.22
+// pattern and answers all other methods with 405 and an Allow header.
+func handleResource(mux *http.ServeMux, path string, routes ...apiRoute) {
+	allowed := make([]string, 0, len(routes)+2)
+	for _, rt := range routes {
+		mux.Handle(rt.method+" "+path, rt.handler)
+		allowed = append(allowed, rt.method)
+		if rt.method == http.MethodGet {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+	allowed = append(allowed, http.MethodOptions)
+	allow := strings.Join(allowed, ", ")
+
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeProblem(w, r, newAPIError(http.StatusMethodNotAllowed, codeMethodNotAllowed,
+			fmt.Sprintf("Method %s is not allowed; use one of: %s", r.Method, allow), nil))
+	})
+}
+
+func deprecated(successor string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		logUserAction(r.Context(), "deprecatedRoute", "warning", map[string]interface{}{
+			"path":      r.URL.Path,
+			"successor": successor,
+		})
+		next.ServeHTTP(w, r)
+	})
+}
+
+func registerAPIRoutes(mux *http.ServeMux) {
+	handleResource(mux, "/api/v1/users",
+		route(http.MethodGet, adminMiddleware(http.HandlerFunc(getUsers))),
+		route(http.MethodPost, http.HandlerFunc(CreateUser)),
+	)
+	handleResource(mux, "/api/v1/users/{id}",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(apiGetUser))),
+		route(http.MethodPatch, authMiddleware(http.HandlerFunc(apiUpdateUser))),
+		route(http.MethodDelete, adminMiddleware(http.HandlerFunc(apiDeleteUser))),
+	)
+	handleResource(mux, "/api/v1/products",
+		route(http.MethodGet, adminMiddleware(http.HandlerFunc(getProducts))),
+		route(http.MethodPost, adminMiddleware(http.HandlerFunc(createProduct))),
+	)
+	handleResource(mux, "/api/v1/products/{id}",
+		route(http.MethodGet, adminMiddleware(http.HandlerFunc(getProduct))),
+		route(http.MethodPut, adminMiddleware(http.HandlerFunc(updateProduct))),
+		route(http.MethodDelete, adminMiddleware(http.HandlerFunc(deleteProduct))),
+	)
+	handleResource(mux, "/api/v1/products/{id}/image",
+		route(http.MethodPut, adminMiddleware(http.HandlerFunc(uploadProductImage))),
+		route(http.MethodDelete, adminMiddleware(http.HandlerFunc(deleteProductImage))),
+	)
+	handleResource(mux, "/api/v1/products/{id}/lessons",
+		route(http.MethodPost, adminMiddleware(http.HandlerFunc(createLesson))),
+	)
+	handleResource(mux, "/api/v1/categories",
+		route(http.MethodGet, http.HandlerFunc(getCategories)),
+		route(http.MethodPost, adminMiddleware(http.HandlerFunc(createCategory))),
+	)
+	handleResource(mux, "/api/v1/categories/{id}",
+		route(http.MethodPut, adminMiddleware(http.HandlerFunc(updateCategory))),
+		route(http.MethodDelete, adminMiddleware(http.HandlerFunc(deleteCategory))),
+	)
+	handleResource(mux, "/api/v1/catalog",
+		route(http.MethodGet, http.HandlerFunc(getProducts)),
+	)
+	handleResource(mux, "/api/v1/catalog/products/{id}",
+		route(http.MethodGet, http.HandlerFunc(getProduct)),
+	)
+	handleResource(mux, "/api/v1/catalog/products/{id}/lessons",
+		route(http.MethodGet, http.HandlerFunc(getProductLessons)),
+	)
+	handleResource(mux, "/api/v1/catalog/products/{id}/reviews",
+		route(http.MethodGet, http.HandlerFunc(getProductReviews)),
+		route(http.MethodPost, authMiddleware(http.HandlerFunc(createReview))),
+	)
+	handleResource(mux, "/api/v1/catalog/categories",
+		route(http.MethodGet, http.HandlerFunc(getCategoryTree)),
+	)
+	handleResource(mux, "/api/v1/catalog/categories/{slug}",
+		route(http.MethodGet, http.HandlerFunc(getCatalogCategory)),
+	)
+	handleResource(mux, "/api/v1/catalog/tags",
+		route(http.MethodGet, http.HandlerFunc(getCatalogTags)),
+	)
+
+	handleResource(mux, "/api/v1/cart",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(getCart))),
+		route(http.MethodDelete, authMiddleware(http.HandlerFunc(clearCart))),
+	)
+	handleResource(mux, "/api/v1/cart/quote",
+		route(http.MethodPost, authMiddleware(http.HandlerFunc(quoteOrder))),
+	)
+	handleResource(mux, "/api/v1/cart/items/{id}",
+		route(http.MethodPut, authMiddleware(http.HandlerFunc(putCartItem))),
+		route(http.MethodDelete, authMiddleware(http.HandlerFunc(deleteCartItem))),
+	)
+	handleResource(mux, "/api/v1/orders",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(getMyOrders))),
+		route(http.MethodPost, authMiddleware(http.HandlerFunc(createOrder))),
+	)
+	handleResource(mux, "/api/v1/orders/{id}",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(getOrder))),
+	)
+	handleResource(mux, "/api/v1/orders/{id}/cancel",
+		route(http.MethodPost, authMiddleware(http.HandlerFunc(cancelOrder))),
+	)
+	handleResource(mux, "/api/v1/orders/{id}/invoice",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(getOrderInvoice))),
+	)
+	handleResource(mux, "/api/v1/orders/{id}/checkout",
+		route(http.MethodPost, authMiddleware(http.HandlerFunc(checkoutOrder))),
+	)
+	handleResource(mux, "/api/v1/enrollments",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(getMyEnrollments))),
+	)
+	handleResource(mux, "/api/v1/plans",
+		route(http.MethodGet, http.HandlerFunc(getPlans)),
+	)
+	handleResource(mux, "/api/v1/subscription",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(getMySubscription))),
+		route(http.MethodPost, authMiddleware(http.HandlerFunc(subscribe))),
+		route(http.MethodDelete, authMiddleware(http.HandlerFunc(cancelSubscription))),
+	)
+	handleResource(mux, "/api/v1/subscription/resume",
+		route(http.MethodPost, authMiddleware(http.HandlerFunc(resumeSubscription))),
+	)
+	handleResource(mux, "/api/v1/lessons/{id}",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(getLesson))),
+		route(http.MethodPut, adminMiddleware(http.HandlerFunc(updateLesson))),
+		route(http.MethodDelete, adminMiddleware(http.HandlerFunc(deleteLesson))),
+	)
+	handleResource(mux, "/api/v1/premium/lessons",
+		route(http.MethodGet, authMiddleware(requireEntitlement(featurePremiumLessons, http.HandlerFunc(getPremiumLessons)))),
+	)
+	handleResource(mux, "/api/v1/reviews",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(getMyReviews))),
+	)
+	handleResource(mux, "/api/v1/reviews/{id}",
+		route(http.MethodPut, authMiddleware(http.HandlerFunc(updateReview))),
+		route(http.MethodDelete, authMiddleware(http.HandlerFunc(deleteReview))),
+	)
+	handleResource(mux, "/api/v1/reviews/{id}/helpful",
+		route(http.MethodPost, authMiddleware(http.HandlerFunc(voteReview))),
+		route(http.MethodDelete, authMiddleware(http.HandlerFunc(voteReview))),
+	)
+	handleResource(mux, "/api/v1/payments/webhook",
+		route(http.MethodPost, http.HandlerFunc(receivePaymentWebhook)),
+	)
+
+	handleResource(mux, "/api/v1/tickets",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(getMyTickets))),
+		route(http.MethodPost, authMiddleware(http.HandlerFunc(createTicket))),
+	)
+	handleResource(mux, "/api/v1/tickets/{id}",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(getTicket))),
+	)
+	handleResource(mux, "/api/v1/tickets/{id}/messages",
+		route(http.MethodPost, authMiddleware(http.HandlerFunc(addTicketMessage))),
+	)
+	handleResource(mux, "/api/v1/tickets/{id}/attachments/{attachmentID}",
+		route(http.MethodGet, authMiddleware(http.HandlerFunc(getTicketAttachment))),
+	)
+	handleResource(mux, "/api/v1/inbound/email",
+		route(http.MethodPost, http.HandlerFunc(receiveInboundEmail)),
+	)
+	handleResource(mux, "/api/v1/helpdesk/tickets",
+		route(http.MethodGet, agentMiddleware(http.HandlerFunc(getTicketQueue))),
+	)
+	handleResource(mux, "/api/v1/helpdesk/tickets/{id}",
+		route(http.MethodPatch, agentMiddleware(http.HandlerFunc(updateTicket))),
+	)
+	handleResource(mux, "/api/v1/admin/orders",
+		route(http.MethodGet, adminMiddleware(http.HandlerFunc(getAdminOrders))),
+	)
+	handleResource(mux, "/api/v1/admin/orders/{id}",
+		route(http.MethodPatch, adminMiddleware(http.HandlerFunc(updateOrder))),
+	)
+	handleResource(mux, "/api/v1/admin/orders/{id}/refund",
+		route(http.MethodPost, adminMiddleware(http.HandlerFunc(refundOrder))),
+	)
+	handleResource(mux, "/api/v1/admin/coupons",
+		route(http.MethodGet, adminMiddleware(http.HandlerFunc(getCoupons))),
+		route(http.MethodPost, adminMiddleware(http.HandlerFunc(createCoupon))),
+	)
+	handleResource(mux, "/api/v1/admin/coupons/{id}",
+		route(http.MethodPut, adminMiddleware(http.HandlerFunc(updateCoupon))),
+		route(http.MethodDelete, adminMiddleware(http.HandlerFunc(deleteCoupon))),
+	)
+	handleResource(mux, "/api/v1/admin/plans",
+		route(http.MethodGet, adminMiddleware(http.HandlerFunc(getPlans))),
+		route(http.MethodPost, adminMiddleware(http.HandlerFunc(createPlan))),
+	)
+	handleResource(mux, "/api/v1/admin/plans/{id}",
+		route(http.MethodPut, adminMiddleware(http.HandlerFunc(updatePlan))),
+	)
+	handleResource(mux, "/api/v1/admin/products/import",
+		route(http.MethodPost, adminMiddleware(http.HandlerFunc(importProducts))),
+	)
+	handleResource(mux, "/api/v1/admin/products/export",
+		route(http.MethodGet, adminMiddleware(http.HandlerFunc(exportProducts))),
+	)
+	handleResource(mux, "/api/v1/admin/reviews",
+		route(http.MethodGet, adminMiddleware(http.HandlerFunc(getAdminReviews))),
+	)
+	handleResource(mux, "/api/v1/admin/reviews/{id}",
+		route(http.MethodPatch, adminMiddleware(http.HandlerFunc(moderateReview))),
+	)
+	handleResource(mux, "/api/v1/admin/outbox",
+		route(http.MethodGet, adminMiddleware(http.HandlerFunc(getOutbox))),
+	)
+	handleResource(mux, "/api/v1/admin/outbox/{id}/retry",
+		route(http.MethodPost, adminMiddleware(http.HandlerFunc(retryOutboxMessage))),
+	)
+
+	handleResource(mux, "/api/v1/admin/email-templates",
+		route(http.MethodGet, adminMiddleware(http.HandlerFunc(getEmailTemplates))),
+	)
+	handleResource(mux, "/api/v1/admin/email-templates/{name}/{locale}",
+		route(http.MethodPut, adminMiddleware(http.HandlerFunc(putEmailTemplate))),
+		route(http.MethodDelete, adminMiddleware(http.HandlerFunc(deleteEmailTemplate))),
+	)
+
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, notFound("Resource not found", nil))
+	})
+}
+
+func pathID(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid id %q", r.PathValue("id"))
+	}
+	return uint(id), nil
+}
+
+func canAccessUser(r *http.Request, id uint) bool {
+	if requestUserRole(r) == "admin" {
+		return true
+	}
+	userID, ok := requestUserID(r)
+	return ok && userID == id
+}
+
+func apiGetUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "getUserByID", invalidParameter(err.Error()))
+		return
+	}
+	if !canAccessUser(r, id) {
+		handleError(w, r, "getUserByID", forbidden("You can only view your own profile"))
+		return
+	}
+
+	var user User
+	if err := Db.WithContext(r.Context()).First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, r, "getUserByID", notFound("User not found", err))
+			return
+		}
+
+		handleError(w, r, "getUserByID", internalError(fmt.Errorf("database error: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+	logUserAction(r.Context(), "getUserByID", "success", map[string]interface{}{"user_id": user.ID})
+}
+
+func apiUpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "updateUser", invalidParameter(err.Error()))
+		return
+	}
+
+	var req updateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, "updateUser", invalidJSON(err))
+		return
+	}
+	req.ID = id
+
+	w.Header().Set("Content-Type", "application/json")
+	applyUserUpdate(w, r, req)
+}
+
+func apiDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "deleteUser", invalidParameter(err.Error()))
+		return
+	}
+
+	if err := removeUser(r.Context(), id); err != nil {
+		handleError(w, r, "deleteUser", internalError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
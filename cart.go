@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CartItem struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	UserID    uint      `json:"-" gorm:"uniqueIndex:idx_cart_items_product;not null"`
+	ProductID uint      `json:"product_id" gorm:"uniqueIndex:idx_cart_items_product;not null"`
+	Product   *Product  `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Quantity  int       `json:"quantity"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type cartItemView struct {
+	ProductID uint        `json:"product_id"`
+	Quantity  int         `json:"quantity"`
+	Product   productView `json:"product"`
+	// Total is null when the product has no price in the cart currency;
+	// such items cannot be ordered in that currency.
+	Total *priceView `json:"total"`
+}
+
+type cartView struct {
+	Currency string         `json:"currency"`
+	Items    []cartItemView `json:"items"`
+	Total    priceView      `json:"total"`
+}
+
+func newCartView(items []CartItem, currency, locale string) cartView {
+	v := cartView{Currency: currency, Items: make([]cartItemView, len(items))}
+	var total int64
+	for i, item := range items {
+		v.Items[i] = cartItemView{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+			Product:   newProductView(item.Product, currency, locale),
+		}
+		if unit, ok := item.Product.priceIn(currency); ok {
+			line := newPriceView(unit*int64(item.Quantity), currency, locale)
+			v.Items[i].Total = &line
+			total += line.Amount
+		}
+	}
+	v.Total = newPriceView(total, currency, locale)
+	return v
+}
+
+func loadCart(ctx context.Context, userID uint) ([]CartItem, error) {
+	var items []CartItem
+	err := Db.WithContext(ctx).Preload("Product.Tags").Preload("Product.Prices").
+		Where("user_id = ?", userID).Order("created_at, id").Find(&items).Error
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving cart: %v", err)
+	}
+	return items, nil
+}
+
+// writeCart responds with the user's cart priced in the ?currency= parameter
+// or defaultCurrency.
+func writeCart(w http.ResponseWriter, r *http.Request, action string, userID uint) {
+	items, err := loadCart(r.Context(), userID)
+	if err != nil {
+		handleError(w, r, action, internalError(err))
+		return
+	}
+	currency, locale := displayPrefs(r)
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newCartView(items, currency, locale))
+}
+
+func getCart(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+	writeCart(w, r, "getCart", userID)
+}
+
+// putCartItem sets the quantity of a product in the cart, adding it if
+// needed.
+func putCartItem(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+	productID, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "putCartItem", invalidParameter(err.Error()))
+		return
+	}
+
+	var req struct {
+		Quantity int `json:"quantity" validate:"required,min=1,max=99"`
+	}
+	if !decodeAndValidate(w, r, "putCartItem", &req) {
+		return
+	}
+
+	if err := Db.WithContext(r.Context()).Select("id").First(&Product{}, productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, r, "putCartItem", notFound("Product not found", err))
+			return
+		}
+		handleError(w, r, "putCartItem", internalError(fmt.Errorf("error retrieving product: %v", err)))
+		return
+	}
+
+	item := CartItem{UserID: userID, ProductID: productID, Quantity: req.Quantity}
+	err = Db.WithContext(r.Context()).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "product_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"quantity", "updated_at"}),
+	}).Create(&item).Error
+	if err != nil {
+		handleError(w, r, "putCartItem", internalError(fmt.Errorf("error updating cart: %v", err)))
+		return
+	}
+
+	logUserAction(r.Context(), "putCartItem", "success", map[string]interface{}{"product_id": productID, "quantity": req.Quantity})
+	writeCart(w, r, "putCartItem", userID)
+}
+
+func deleteCartItem(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+	productID, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "deleteCartItem", invalidParameter(err.Error()))
+		return
+	}
+
+	if err := Db.WithContext(r.Context()).Where("user_id = ? AND product_id = ?", userID, productID).Delete(&CartItem{}).Error; err != nil {
+		handleError(w, r, "deleteCartItem", internalError(fmt.Errorf("error updating cart: %v", err)))
+		return
+	}
+	writeCart(w, r, "deleteCartItem", userID)
+}
+
+func clearCart(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+	if err := Db.WithContext(r.Context()).Where("user_id = ?", userID).Delete(&CartItem{}).Error; err != nil {
+		handleError(w, r, "clearCart", internalError(fmt.Errorf("error clearing cart: %v", err)))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+type categoryNode struct {
+	Category
+	// ProductCount includes products of all subcategories.
+	ProductCount int64           `json:"product_count"`
+	Children     []*categoryNode `json:"children"`
+}
+
+// buildCategoryTree nests categories under their parents, sorted by name,
+// and rolls direct product counts up to every ancestor. Categories whose
+// parent is missing are treated as roots.
+func buildCategoryTree(categories []Category, counts map[uint]int64) ([]*categoryNode, map[uint]*categoryNode) {
+	byID := make(map[uint]*categoryNode, len(categories))
+	for _, c := range categories {
+		byID[c.ID] = &categoryNode{Category: c, Children: []*categoryNode{}}
+	}
+
+	var roots []*categoryNode
+	for _, c := range categories {
+		node := byID[c.ID]
+		if c.ParentID != nil && byID[*c.ParentID] != nil {
+			parent := byID[*c.ParentID]
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	var total func(n *categoryNode) int64
+	total = func(n *categoryNode) int64 {
+		n.ProductCount = counts[n.ID]
+		sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+		for _, child := range n.Children {
+			n.ProductCount += total(child)
+		}
+		return n.ProductCount
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i].Name < roots[j].Name })
+	for _, root := range roots {
+		total(root)
+	}
+	return roots, byID
+}
+
+func loadCategoryTree(ctx context.Context) ([]*categoryNode, map[uint]*categoryNode, error) {
+	var categories []Category
+	if err := Db.WithContext(ctx).Find(&categories).Error; err != nil {
+		return nil, nil, fmt.Errorf("error retrieving categories: %v", err)
+	}
+
+	var rows []struct {
+		CategoryID uint
+		Count      int64
+	}
+	err := Db.WithContext(ctx).Model(&Product{}).
+		Select("category_id, count(*) AS count").
+		Where("category_id IS NOT NULL").
+		Group("category_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("error counting products: %v", err)
+	}
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.CategoryID] = row.Count
+	}
+
+	roots, byID := buildCategoryTree(categories, counts)
+	return roots, byID, nil
+}
+
+func getCategoryTree(w http.ResponseWriter, r *http.Request) {
+	roots, _, err := loadCategoryTree(r.Context())
+	if err != nil {
+		handleError(w, r, "getCategoryTree", internalError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roots)
+}
+
+// getCatalogCategory returns one category with its subtree and the
+// breadcrumb trail from the root. Its products come from
+// /api/v1/catalog?category=<slug>.
+func getCatalogCategory(w http.ResponseWriter, r *http.Request) {
+	_, byID, err := loadCategoryTree(r.Context())
+	if err != nil {
+		handleError(w, r, "getCatalogCategory", internalError(err))
+		return
+	}
+
+	var node *categoryNode
+	for _, n := range byID {
+		if n.Slug == r.PathValue("slug") {
+			node = n
+			break
+		}
+	}
+	if node == nil {
+		handleError(w, r, "getCatalogCategory", notFound("Category not found", nil))
+		return
+	}
+
+	var breadcrumbs []Category
+	for cur := node; cur != nil && len(breadcrumbs) <= len(byID); {
+		breadcrumbs = append([]Category{cur.Category}, breadcrumbs...)
+		if cur.ParentID == nil {
+			break
+		}
+		cur = byID[*cur.ParentID]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"category":    node,
+		"breadcrumbs": breadcrumbs,
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func uintPtr(v uint) *uint { return &v }
+
+func TestBuildCategoryTreeRollsUpCounts(t *testing.T) {
+	categories := []Category{
+		{ID: 1, Name: "Languages", Slug: "languages"},
+		{ID: 2, Name: "Spanish", Slug: "spanish", ParentID: uintPtr(1)},
+		{ID: 3, Name: "English", Slug: "english", ParentID: uintPtr(1)},
+		{ID: 4, Name: "Business English", Slug: "business-english", ParentID: uintPtr(3)},
+		{ID: 5, Name: "Exams", Slug: "exams"},
+		{ID: 6, Name: "Orphan", Slug: "orphan", ParentID: uintPtr(99)},
+	}
+	roots, byID := buildCategoryTree(categories, map[uint]int64{1: 1, 2: 2, 3: 3, 4: 4})
+
+	if len(roots) != 3 || roots[0].Slug != "exams" || roots[1].Slug != "languages" || roots[2].Slug != "orphan" {
+		t.Fatalf("Unexpected roots: %+v", roots)
+	}
+	languages := roots[1]
+	if languages.ProductCount != 10 || byID[3].ProductCount != 7 || byID[5].ProductCount != 0 {
+		t.Errorf("Unexpected counts: languages=%d english=%d exams=%d", languages.ProductCount, byID[3].ProductCount, byID[5].ProductCount)
+	}
+	if len(languages.Children) != 2 || languages.Children[0].Slug != "english" || languages.Children[1].Slug != "spanish" {
+		t.Errorf("Children not sorted by name: %+v", languages.Children)
+	}
+}
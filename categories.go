@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	attrString  = "string"
+	attrNumber  = "number"
+	attrBoolean = "boolean"
+	attrEnum    = "enum"
+)
+
+var (
+	attributeKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+	slugPattern         = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+// AttributeDef describes one characteristic that products of a category
+// carry, e.g. {"key": "level", "type": "enum", "options": ["A1", "A2"]}.
+type AttributeDef struct {
+	Key      string   `json:"key"`
+	Label    string   `json:"label"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Options  []string `json:"options,omitempty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Unit     string   `json:"unit,omitempty"`
+}
+
+type Category struct {
+	ID         uint           `json:"id" gorm:"primaryKey"`
+	Name       string         `json:"name"`
+	Slug       string         `json:"slug" gorm:"uniqueIndex"`
+	ParentID   *uint          `json:"parent_id" gorm:"index"`
+	Attributes []AttributeDef `json:"attributes" gorm:"type:jsonb;serializer:json"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+type categoryRequest struct {
+	Name       string         `json:"name" validate:"required,max=100"`
+	Slug       string         `json:"slug" validate:"max=100"`
+	ParentID   *uint          `json:"parent_id"`
+	Attributes []AttributeDef `json:"attributes" validate:"max=50"`
+}
+
+// validate defaults the slug to one derived from the name.
+func (req *categoryRequest) validate() error {
+	if req.Slug == "" {
+		req.Slug = slugify(req.Name)
+	}
+	if err := validateRequest(req); err != nil {
+		return err
+	}
+
+	var fields []FieldError
+	if !slugPattern.MatchString(req.Slug) {
+		fields = append(fields, fieldError("slug", "invalid", "slug may only contain lowercase letters, digits and single dashes"))
+	}
+	seen := make(map[string]bool)
+	for i, def := range req.Attributes {
+		field := fmt.Sprintf("attributes[%d]", i)
+		switch {
+		case !attributeKeyPattern.MatchString(def.Key):
+			fields = append(fields, fieldError(field+".key", "invalid", "key must be lowercase snake_case, up to 40 characters"))
+		case seen[def.Key]:
+			fields = append(fields, fieldError(field+".key", "duplicate", "key "+def.Key+" is defined twice"))
+		}
+		seen[def.Key] = true
+		switch def.Type {
+		case attrString, attrNumber, attrBoolean:
+		case attrEnum:
+			if len(def.Options) == 0 {
+				fields = append(fields, fieldError(field+".options", "required", "enum attributes need at least one option"))
+			}
+		default:
+			fields = append(fields, fieldError(field+".type", "oneof", "type must be one of: string, number, boolean, enum"))
+		}
+		if def.Min != nil && def.Max != nil && *def.Min > *def.Max {
+			fields = append(fields, fieldError(field+".max", "min", "max must not be less than min"))
+		}
+	}
+	if len(fields) > 0 {
+		return validationFailed(fields...)
+	}
+	return nil
+}
+
+// validateCharacteristics checks attrs against the category schema. Without
+// a category any flat set of string, number and boolean values is accepted.
+func validateCharacteristics(defs []AttributeDef, attrs map[string]interface{}, hasSchema bool) error {
+	var fields []FieldError
+	field := func(key string) string { return "characteristics." + key }
+
+	byKey := make(map[string]AttributeDef, len(defs))
+	for _, def := range defs {
+		byKey[def.Key] = def
+		if _, ok := attrs[def.Key]; def.Required && !ok {
+			fields = append(fields, fieldError(field(def.Key), "required", def.Key+" is required"))
+		}
+	}
+
+	for key, value := range attrs {
+		def, known := byKey[key]
+		if !hasSchema {
+			switch value.(type) {
+			case string, float64, bool:
+				if !attributeKeyPattern.MatchString(key) {
+					fields = append(fields, fieldError(field(key), "invalid", "key must be lowercase snake_case, up to 40 characters"))
+				}
+			default:
+				fields = append(fields, fieldError(field(key), "type", key+" must be a string, number or boolean"))
+			}
+			continue
+		}
+		if !known {
+			fields = append(fields, fieldError(field(key), "unknown", key+" is not defined for this category"))
+			continue
+		}
+		if msg := checkAttribute(def, value); msg != "" {
+			fields = append(fields, fieldError(field(key), "type", key+" "+msg))
+		}
+	}
+	if len(fields) > 0 {
+		return validationFailed(fields...)
+	}
+	return nil
+}
+
+func checkAttribute(def AttributeDef, value interface{}) string {
+	switch def.Type {
+	case attrString:
+		if s, ok := value.(string); !ok || len(s) > 500 {
+			return "must be a string of at most 500 characters"
+		}
+	case attrBoolean:
+		if _, ok := value.(bool); !ok {
+			return "must be true or false"
+		}
+	case attrEnum:
+		s, _ := value.(string)
+		for _, option := range def.Options {
+			if s == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of: %v", def.Options)
+	case attrNumber:
+		n, ok := value.(float64)
+		if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
+			return "must be a number"
+		}
+		if def.Min != nil && n < *def.Min {
+			return fmt.Sprintf("must be at least %v", *def.Min)
+		}
+		if def.Max != nil && n > *def.Max {
+			return fmt.Sprintf("must be at most %v", *def.Max)
+		}
+	}
+	return ""
+}
+
+// checkProductCharacteristics resolves the product's category and validates
+// its characteristics against the category schema.
+func checkProductCharacteristics(ctx context.Context, categoryID *uint, attrs map[string]interface{}) error {
+	if categoryID == nil {
+		return validateCharacteristics(nil, attrs, false)
+	}
+	var category Category
+	if err := Db.WithContext(ctx).First(&category, *categoryID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return validationFailed(fieldError("category_id", "exists", "category does not exist"))
+		}
+		return internalError(fmt.Errorf("error retrieving category: %v", err))
+	}
+	return validateCharacteristics(category.Attributes, attrs, true)
+}
+
+// checkCategoryParent rejects a missing parent and any parent that would
+// put category id inside its own subtree. id is 0 for new categories.
+func checkCategoryParent(ctx context.Context, id uint, parentID *uint) error {
+	if parentID == nil {
+		return nil
+	}
+	var categories []Category
+	if err := Db.WithContext(ctx).Select("id", "parent_id").Find(&categories).Error; err != nil {
+		return internalError(fmt.Errorf("error retrieving categories: %v", err))
+	}
+	parents := make(map[uint]*uint, len(categories))
+	for _, c := range categories {
+		parents[c.ID] = c.ParentID
+	}
+	return validateCategoryParent(parents, id, *parentID)
+}
+
+func validateCategoryParent(parents map[uint]*uint, id, parentID uint) error {
+	if _, ok := parents[parentID]; !ok {
+		return validationFailed(fieldError("parent_id", "exists", "parent category does not exist"))
+	}
+	for cur, hops := &parentID, 0; cur != nil && hops <= len(parents); cur, hops = parents[*cur], hops+1 {
+		if *cur == id {
+			return validationFailed(fieldError("parent_id", "cycle", "a category cannot be moved under itself or its subcategories"))
+		}
+	}
+	return nil
+}
+
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya", 'ә': "a", 'ғ': "g", 'қ': "q", 'ң': "n", 'ө': "o", 'ұ': "u", 'ү': "u",
+	'һ': "h", 'і': "i",
+}
+
+// slugify derives a URL slug from a display name, transliterating Cyrillic.
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z' || r >= '0' && r <= '9':
+			b.WriteRune(r)
+			dash = false
+		case translit[r] != "":
+			b.WriteString(translit[r])
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if len(slug) > 80 {
+		slug = strings.TrimRight(slug[:80], "-")
+	}
+	return slug
+}
+
+// migrateProductCharacteristics converts the legacy free-form text column to
+// JSONB before AutoMigrate runs, keeping old text under the "notes" key.
+func migrateProductCharacteristics(db *gorm.DB) error {
+	var dataType string
+	err := db.Raw(`SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'products' AND column_name = 'characteristics'`).Scan(&dataType).Error
+	if err != nil || dataType != "text" {
+		return err
+	}
+	return db.Exec(`ALTER TABLE products ALTER COLUMN characteristics TYPE jsonb USING
+		CASE WHEN coalesce(characteristics, '') = '' THEN '{}'::jsonb
+		ELSE jsonb_build_object('notes', characteristics) END`).Error
+}
+
+func getCategories(w http.ResponseWriter, r *http.Request) {
+	var categories []Category
+	if err := Db.WithContext(r.Context()).Order("name").Find(&categories).Error; err != nil {
+		handleError(w, r, "getCategories", internalError(fmt.Errorf("error retrieving categories: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(categories)
+}
+
+func createCategory(w http.ResponseWriter, r *http.Request) {
+	var req categoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, "createCategory", invalidJSON(err))
+		return
+	}
+	if err := req.validate(); err != nil {
+		handleError(w, r, "createCategory", err)
+		return
+	}
+
+	if err := checkCategoryParent(r.Context(), 0, req.ParentID); err != nil {
+		handleError(w, r, "createCategory", err)
+		return
+	}
+
+	category := Category{Name: req.Name, Slug: req.Slug, ParentID: req.ParentID, Attributes: req.Attributes}
+	if err := Db.WithContext(r.Context()).Create(&category).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			handleError(w, r, "createCategory", newAPIError(http.StatusConflict, codeConflict, "A category with this slug already exists", err))
+			return
+		}
+		handleError(w, r, "createCategory", internalError(fmt.Errorf("failed to save category: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(category)
+	logUserAction(r.Context(), "createCategory", "success", map[string]interface{}{"category_id": category.ID})
+}
+
+// updateCategory replaces the category and its attribute schema. Products
+// keep their stored characteristics; they are checked against the new
+// schema the next time they are saved.
+func updateCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "updateCategory", invalidParameter(err.Error()))
+		return
+	}
+
+	var req categoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, "updateCategory", invalidJSON(err))
+		return
+	}
+	if err := req.validate(); err != nil {
+		handleError(w, r, "updateCategory", err)
+		return
+	}
+
+	var category Category
+	if err := Db.WithContext(r.Context()).First(&category, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, r, "updateCategory", notFound("Category not found", err))
+			return
+		}
+		handleError(w, r, "updateCategory", internalError(fmt.Errorf("error retrieving category: %v", err)))
+		return
+	}
+
+	if err := checkCategoryParent(r.Context(), category.ID, req.ParentID); err != nil {
+		handleError(w, r, "updateCategory", err)
+		return
+	}
+
+	category.Name, category.Slug, category.ParentID, category.Attributes = req.Name, req.Slug, req.ParentID, req.Attributes
+	if err := Db.WithContext(r.Context()).Save(&category).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			handleError(w, r, "updateCategory", newAPIError(http.StatusConflict, codeConflict, "A category with this slug already exists", err))
+			return
+		}
+		handleError(w, r, "updateCategory", internalError(fmt.Errorf("failed to update category: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(category)
+	logUserAction(r.Context(), "updateCategory", "success", map[string]interface{}{"category_id": category.ID})
+}
+
+// deleteCategory refuses to remove a category that still has products or
+// subcategories.
+func deleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "deleteCategory", invalidParameter(err.Error()))
+		return
+	}
+
+	var inUse int64
+	if err := Db.WithContext(r.Context()).Model(&Product{}).Where("category_id = ?", id).Count(&inUse).Error; err != nil {
+		handleError(w, r, "deleteCategory", internalError(fmt.Errorf("error counting products: %v", err)))
+		return
+	}
+	if inUse > 0 {
+		handleError(w, r, "deleteCategory", newAPIError(http.StatusConflict, codeConflict, fmt.Sprintf("Category still has %d products", inUse), nil))
+		return
+	}
+	var children int64
+	if err := Db.WithContext(r.Context()).Model(&Category{}).Where("parent_id = ?", id).Count(&children).Error; err != nil {
+		handleError(w, r, "deleteCategory", internalError(fmt.Errorf("error counting subcategories: %v", err)))
+		return
+	}
+	if children > 0 {
+		handleError(w, r, "deleteCategory", newAPIError(http.StatusConflict, codeConflict, fmt.Sprintf("Category still has %d subcategories", children), nil))
+		return
+	}
+
+	result := Db.WithContext(r.Context()).Delete(&Category{}, id)
+	if result.Error != nil {
+		handleError(w, r, "deleteCategory", internalError(fmt.Errorf("failed to delete category: %v", result.Error)))
+		return
+	}
+	if result.RowsAffected == 0 {
+		handleError(w, r, "deleteCategory", notFound("Category not found", nil))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logUserAction(r.Context(), "deleteCategory", "success", map[string]interface{}{"category_id": id})
+}
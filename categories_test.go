@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func fieldCodes(err error) map[string]string {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return nil
+	}
+	codes := make(map[string]string)
+	for _, fe := range apiErr.Fields {
+		codes[fe.Field] = fe.Code
+	}
+	return codes
+}
+
+func TestCategoryRequestValidatesSchema(t *testing.T) {
+	req := categoryRequest{
+		Name: "Language courses",
+		Slug: "language-courses",
+		Attributes: []AttributeDef{
+			{Key: "language", Type: attrString, Required: true},
+			{Key: "level", Type: attrEnum},
+			{Key: "language", Type: attrNumber},
+			{Key: "Hours", Type: "duration"},
+		},
+	}
+	codes := fieldCodes(req.validate())
+	expected := map[string]string{
+		"attributes[1].options": "required",
+		"attributes[2].key":     "duplicate",
+		"attributes[3].key":     "invalid",
+		"attributes[3].type":    "oneof",
+	}
+	for field, code := range expected {
+		if codes[field] != code {
+			t.Errorf("Expected %s error on %s, got %v", code, field, codes)
+		}
+	}
+
+	req.Attributes = req.Attributes[:1]
+	req.Slug = "Language Courses"
+	if codes := fieldCodes(req.validate()); codes["slug"] != "invalid" || len(codes) != 1 {
+		t.Errorf("Expected only a slug error, got %v", codes)
+	}
+}
+
+func TestValidateCharacteristics(t *testing.T) {
+	minHours, maxHours := 1.0, 200.0
+	defs := []AttributeDef{
+		{Key: "language", Type: attrString, Required: true},
+		{Key: "level", Type: attrEnum, Options: []string{"A1", "A2", "B1"}},
+		{Key: "hours", Type: attrNumber, Min: &minHours, Max: &maxHours},
+		{Key: "certificate", Type: attrBoolean},
+	}
+
+	valid := map[string]interface{}{"language": "Spanish", "level": "A2", "hours": 40.0, "certificate": true}
+	if err := validateCharacteristics(defs, valid, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	codes := fieldCodes(validateCharacteristics(defs, map[string]interface{}{
+		"level":       "C2",
+		"hours":       500.0,
+		"certificate": "yes",
+		"teacher":     "Anna",
+	}, true))
+	expected := map[string]string{
+		"characteristics.language":    "required",
+		"characteristics.level":       "type",
+		"characteristics.hours":       "type",
+		"characteristics.certificate": "type",
+		"characteristics.teacher":     "unknown",
+	}
+	for field, code := range expected {
+		if codes[field] != code {
+			t.Errorf("Expected %s error on %s, got %v", code, field, codes)
+		}
+	}
+
+	if err := validateCharacteristics(nil, map[string]interface{}{"notes": "Feature A", "hours": 3.0}, false); err != nil {
+		t.Errorf("Uncategorized scalars should be accepted: %v", err)
+	}
+	if err := validateCharacteristics(nil, map[string]interface{}{"nested": map[string]interface{}{}}, false); err == nil {
+		t.Error("Expected nested values to be rejected")
+	}
+}
+
+func TestValidateCategoryParent(t *testing.T) {
+	one, two := uint(1), uint(2)
+	parents := map[uint]*uint{1: nil, 2: &one, 3: &two}
+
+	if err := validateCategoryParent(parents, 0, 3); err != nil {
+		t.Errorf("New category under a leaf should be allowed: %v", err)
+	}
+	if err := validateCategoryParent(parents, 3, 1); err != nil {
+		t.Errorf("Moving a leaf to the root category should be allowed: %v", err)
+	}
+	if codes := fieldCodes(validateCategoryParent(parents, 1, 3)); codes["parent_id"] != "cycle" {
+		t.Errorf("Expected a cycle error, got %v", codes)
+	}
+	if codes := fieldCodes(validateCategoryParent(parents, 2, 2)); codes["parent_id"] != "cycle" {
+		t.Errorf("Expected a cycle error for self-parenting, got %v", codes)
+	}
+	if codes := fieldCodes(validateCategoryParent(parents, 1, 42)); codes["parent_id"] != "exists" {
+		t.Errorf("Expected a missing parent error, got %v", codes)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	cases := map[string]string{
+		"Business English (B2)": "business-english-b2",
+		"  Испанский язык  ":    "ispanskiy-yazyk",
+		"C++ & Go!":             "c-go",
+		"!!!":                   "",
+	}
+	for in, expected := range cases {
+		if got := slugify(in); got != expected {
+			t.Errorf("slugify(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+}
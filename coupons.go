@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"LanguageLearningPlatform/money"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	couponPercent = "percent"
+	couponFixed   = "fixed"
+)
+
+// Coupon is a promo code. Restricting it to products or categories (a
+// category includes its subcategories) discounts only matching items; an
+// unrestricted coupon applies to the whole order.
+type Coupon struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Code        string `json:"code" gorm:"uniqueIndex;not null"`
+	Description string `json:"description"`
+	Kind        string `json:"kind" gorm:"not null"`
+	PercentOff  int    `json:"percent_off,omitempty"`
+	AmountOff   int64  `json:"-"`
+	// Currency is the currency of AmountOff; fixed coupons only apply to
+	// orders in it.
+	Currency    string     `json:"currency,omitempty" gorm:"size:3"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+	MaxUses     *int       `json:"max_uses"`
+	MaxUsesUser *int       `json:"max_uses_per_user"`
+	// Uses counts orders placed with the coupon that were not cancelled.
+	Uses        int       `json:"uses"`
+	ProductIDs  []uint    `json:"product_ids" gorm:"type:jsonb;serializer:json"`
+	CategoryIDs []uint    `json:"category_ids" gorm:"type:jsonb;serializer:json"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CouponRedemption ties a coupon use to the order it discounted.
+type CouponRedemption struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CouponID  uint      `json:"coupon_id" gorm:"index;not null"`
+	UserID    uint      `json:"user_id" gorm:"index;not null"`
+	OrderID   uint      `json:"order_id" gorm:"uniqueIndex;not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type couponView struct {
+	*Coupon
+	AmountOff *priceView `json:"amount_off,omitempty"`
+}
+
+func newCouponView(c *Coupon, locale string) couponView {
+	v := couponView{Coupon: c}
+	if c.Kind == couponFixed {
+		amount := newPriceView(c.AmountOff, c.Currency, locale)
+		v.AmountOff = &amount
+	}
+	return v
+}
+
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+type couponRequest struct {
+	Code        string        `json:"code" validate:"required,max=40"`
+	Description string        `json:"description" validate:"max=500"`
+	Kind        string        `json:"kind" validate:"required,oneof=percent fixed"`
+	PercentOff  int           `json:"percent_off" validate:"min=0,max=100"`
+	AmountOff   money.Decimal `json:"amount_off"`
+	Currency    string        `json:"currency" validate:"max=3"`
+	StartsAt    *time.Time    `json:"starts_at"`
+	EndsAt      *time.Time    `json:"ends_at"`
+	MaxUses     *int          `json:"max_uses"`
+	MaxUsesUser *int          `json:"max_uses_per_user"`
+	ProductIDs  []uint        `json:"product_ids" validate:"max=100"`
+	CategoryIDs []uint        `json:"category_ids" validate:"max=100"`
+	Active      bool          `json:"active"`
+}
+
+func (req couponRequest) apply(c *Coupon) error {
+	var fields []FieldError
+	code := normalizeCouponCode(req.Code)
+	for _, r := range code {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			fields = append(fields, fieldError("code", "invalid", "code may only contain letters, digits, dashes and underscores"))
+			break
+		}
+	}
+
+	var amount int64
+	currency := strings.ToUpper(req.Currency)
+	switch req.Kind {
+	case couponPercent:
+		if req.PercentOff < 1 {
+			fields = append(fields, fieldError("percent_off", "min", "percent_off must be between 1 and 100"))
+		}
+		currency = ""
+	case couponFixed:
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		if _, err := money.Lookup(currency); err != nil {
+			fields = append(fields, fieldError("currency", "oneof", "currency must be a supported ISO 4217 code"))
+		} else if req.AmountOff == "" {
+			fields = append(fields, fieldError("amount_off", "required", "amount_off is required"))
+		} else if amountErrs := checkAmount("amount_off", string(req.AmountOff), currency, &amount); len(amountErrs) > 0 {
+			fields = append(fields, amountErrs...)
+		} else if amount == 0 {
+			fields = append(fields, fieldError("amount_off", "min", "amount_off must be positive"))
+		}
+	}
+	if req.MaxUses != nil && *req.MaxUses < 1 {
+		fields = append(fields, fieldError("max_uses", "min", "max_uses must be at least 1"))
+	}
+	if req.MaxUsesUser != nil && *req.MaxUsesUser < 1 {
+		fields = append(fields, fieldError("max_uses_per_user", "min", "max_uses_per_user must be at least 1"))
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		fields = append(fields, fieldError("ends_at", "invalid", "ends_at must be after starts_at"))
+	}
+	if len(fields) > 0 {
+		return validationFailed(fields...)
+	}
+
+	c.Code = code
+	c.Description = req.Description
+	c.Kind = req.Kind
+	c.PercentOff = 0
+	if req.Kind == couponPercent {
+		c.PercentOff = req.PercentOff
+	}
+	c.AmountOff = amount
+	c.Currency = currency
+	c.StartsAt = req.StartsAt
+	c.EndsAt = req.EndsAt
+	c.MaxUses = req.MaxUses
+	c.MaxUsesUser = req.MaxUsesUser
+	c.ProductIDs = req.ProductIDs
+	c.CategoryIDs = req.CategoryIDs
+	c.Active = req.Active
+	return nil
+}
+
+// checkCouponUsable validates everything about c except what it applies to.
+// userUses is the number of the user's orders already using it.
+func checkCouponUsable(c *Coupon, currency string, userUses int64, now time.Time) *FieldError {
+	var fe FieldError
+	switch {
+	case !c.Active:
+		fe = fieldError("coupon", "invalid", "coupon code is not valid")
+	case c.StartsAt != nil && now.Before(*c.StartsAt):
+		fe = fieldError("coupon", "not_started", "coupon is not valid yet")
+	case c.EndsAt != nil && !now.Before(*c.EndsAt):
+		fe = fieldError("coupon", "expired", "coupon has expired")
+	case c.MaxUses != nil && c.Uses >= *c.MaxUses:
+		fe = fieldError("coupon", "exhausted", "coupon has been used up")
+	case c.MaxUsesUser != nil && userUses >= int64(*c.MaxUsesUser):
+		fe = fieldError("coupon", "exhausted", "you have already used this coupon")
+	case c.Kind == couponFixed && c.Currency != currency:
+		fe = fieldError("coupon", "not_applicable", fmt.Sprintf("coupon only applies to orders in %s", c.Currency))
+	default:
+		return nil
+	}
+	return &fe
+}
+
+// couponCovers reports whether c discounts product. parents maps category
+// ids to their parent and is only consulted for category restrictions.
+func couponCovers(c *Coupon, product *Product, parents map[uint]*uint) bool {
+	if len(c.ProductIDs) == 0 && len(c.CategoryIDs) == 0 {
+		return true
+	}
+	if product == nil {
+		return false
+	}
+	for _, id := range c.ProductIDs {
+		if id == product.ID {
+			return true
+		}
+	}
+	// Walk up the category tree; the depth bound guards against cycles.
+	for id, depth := product.CategoryID, 0; id != nil && depth < 100; id, depth = parents[*id], depth+1 {
+		for _, cid := range c.CategoryIDs {
+			if cid == *id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyCoupon discounts the eligible items of order and records the
+// discount per item and on the order. Percentages are rounded per item; a
+// fixed amount is capped at the eligible subtotal and split in proportion
+// to it, the rounding remainder going to the last eligible item.
+func applyCoupon(order *Order, c *Coupon, eligible func(OrderItem) bool) *FieldError {
+	var base int64
+	var last = -1
+	for i, item := range order.Items {
+		if eligible(item) {
+			base += item.UnitAmount * int64(item.Quantity)
+			last = i
+		}
+	}
+	if base == 0 {
+		fe := fieldError("coupon", "not_applicable", "coupon does not apply to any item in the cart")
+		return &fe
+	}
+
+	var discount int64
+	switch c.Kind {
+	case couponPercent:
+		for i, item := range order.Items {
+			if eligible(item) {
+				line := item.UnitAmount * int64(item.Quantity)
+				order.Items[i].DiscountAmount = (line*int64(c.PercentOff) + 50) / 100
+				discount += order.Items[i].DiscountAmount
+			}
+		}
+	case couponFixed:
+		total := min(c.AmountOff, base)
+		for i, item := range order.Items {
+			if !eligible(item) {
+				continue
+			}
+			share := total * (item.UnitAmount * int64(item.Quantity)) / base
+			if i == last {
+				share = total - discount
+			}
+			order.Items[i].DiscountAmount = share
+			discount += share
+		}
+	}
+
+	order.CouponID = &c.ID
+	order.CouponCode = c.Code
+	order.DiscountAmount = discount
+	order.TotalAmount = order.SubtotalAmount - discount
+	return nil
+}
+
+// redeemCoupon looks up code, locking the coupon, checks it against the
+// user and applies it to order. The caller records the redemption once the
+// order has an id.
+func redeemCoupon(tx *gorm.DB, order *Order, items []CartItem, code string) (*Coupon, error) {
+	var coupon Coupon
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", normalizeCouponCode(code)).First(&coupon).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, validationFailed(fieldError("coupon", "invalid", "coupon code is not valid"))
+	}
+	if err != nil {
+		return nil, internalError(fmt.Errorf("error retrieving coupon: %v", err))
+	}
+
+	var userUses int64
+	if coupon.MaxUsesUser != nil {
+		if err := tx.Model(&CouponRedemption{}).Where("coupon_id = ? AND user_id = ?", coupon.ID, order.UserID).Count(&userUses).Error; err != nil {
+			return nil, internalError(fmt.Errorf("error counting coupon uses: %v", err))
+		}
+	}
+	if fe := checkCouponUsable(&coupon, order.Currency, userUses, time.Now()); fe != nil {
+		return nil, validationFailed(*fe)
+	}
+
+	var parents map[uint]*uint
+	if len(coupon.CategoryIDs) > 0 {
+		var categories []Category
+		if err := tx.Select("id", "parent_id").Find(&categories).Error; err != nil {
+			return nil, internalError(fmt.Errorf("error retrieving categories: %v", err))
+		}
+		parents = make(map[uint]*uint, len(categories))
+		for _, c := range categories {
+			parents[c.ID] = c.ParentID
+		}
+	}
+	products := make(map[uint]*Product, len(items))
+	for _, item := range items {
+		products[item.ProductID] = item.Product
+	}
+	eligible := func(item OrderItem) bool {
+		return item.ProductID != nil && couponCovers(&coupon, products[*item.ProductID], parents)
+	}
+	if fe := applyCoupon(order, &coupon, eligible); fe != nil {
+		return nil, validationFailed(*fe)
+	}
+	return &coupon, nil
+}
+
+func recordCouponRedemption(tx *gorm.DB, coupon *Coupon, order *Order) error {
+	if err := tx.Create(&CouponRedemption{CouponID: coupon.ID, UserID: order.UserID, OrderID: order.ID}).Error; err != nil {
+		return err
+	}
+	return tx.Model(coupon).UpdateColumn("uses", gorm.Expr("uses + 1")).Error
+}
+
+// releaseCoupon gives the use back when an order is cancelled.
+func releaseCoupon(tx *gorm.DB, order *Order) error {
+	result := tx.Where("order_id = ?", order.ID).Delete(&CouponRedemption{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return tx.Model(&Coupon{}).Where("id = ? AND uses > 0", *order.CouponID).UpdateColumn("uses", gorm.Expr("uses - 1")).Error
+}
+
+func getCoupons(w http.ResponseWriter, r *http.Request) {
+	var coupons []Coupon
+	if err := Db.WithContext(r.Context()).Order("created_at DESC, id DESC").Find(&coupons).Error; err != nil {
+		handleError(w, r, "getCoupons", internalError(fmt.Errorf("error retrieving coupons: %v", err)))
+		return
+	}
+
+	_, locale := displayPrefs(r)
+	views := make([]couponView, len(coupons))
+	for i := range coupons {
+		views[i] = newCouponView(&coupons[i], locale)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+func saveCoupon(w http.ResponseWriter, r *http.Request, action string, coupon *Coupon, status int) {
+	var req couponRequest
+	if !decodeAndValidate(w, r, action, &req) {
+		return
+	}
+	if err := req.apply(coupon); err != nil {
+		handleError(w, r, action, err)
+		return
+	}
+
+	// Uses only changes through the atomic increments of redemptions, so an
+	// edit never writes back a count that a concurrent order has moved on.
+	query := Db.WithContext(r.Context())
+	if coupon.ID != 0 {
+		query = query.Omit("Uses")
+	}
+	err := query.Save(coupon).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		handleError(w, r, action, newAPIError(http.StatusConflict, codeConflict, "A coupon with this code already exists", err))
+		return
+	}
+	if err != nil {
+		handleError(w, r, action, internalError(fmt.Errorf("error saving coupon: %v", err)))
+		return
+	}
+
+	_, locale := displayPrefs(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newCouponView(coupon, locale))
+	logUserAction(r.Context(), action, "success", map[string]interface{}{"coupon_id": coupon.ID, "code": coupon.Code})
+}
+
+func createCoupon(w http.ResponseWriter, r *http.Request) {
+	saveCoupon(w, r, "createCoupon", &Coupon{}, http.StatusCreated)
+}
+
+func loadCoupon(w http.ResponseWriter, r *http.Request, action string) (*Coupon, bool) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, action, invalidParameter(err.Error()))
+		return nil, false
+	}
+	var coupon Coupon
+	if err := Db.WithContext(r.Context()).First(&coupon, id).Error; err != nil {
+		handleError(w, r, action, notFound("Coupon not found", err))
+		return nil, false
+	}
+	return &coupon, true
+}
+
+func updateCoupon(w http.ResponseWriter, r *http.Request) {
+	if coupon, ok := loadCoupon(w, r, "updateCoupon"); ok {
+		saveCoupon(w, r, "updateCoupon", coupon, http.StatusOK)
+	}
+}
+
+// deleteCoupon removes an unused coupon. Redeemed coupons stay for the
+// order history and can only be deactivated.
+func deleteCoupon(w http.ResponseWriter, r *http.Request) {
+	coupon, ok := loadCoupon(w, r, "deleteCoupon")
+	if !ok {
+		return
+	}
+	var redemptions int64
+	if err := Db.WithContext(r.Context()).Model(&CouponRedemption{}).Where("coupon_id = ?", coupon.ID).Count(&redemptions).Error; err != nil {
+		handleError(w, r, "deleteCoupon", internalError(fmt.Errorf("error counting coupon uses: %v", err)))
+		return
+	}
+	if redemptions > 0 {
+		handleError(w, r, "deleteCoupon", newAPIError(http.StatusConflict, codeConflict,
+			fmt.Sprintf("Coupon was used on %d orders; deactivate it instead", redemptions), nil))
+		return
+	}
+	if err := Db.WithContext(r.Context()).Delete(coupon).Error; err != nil {
+		handleError(w, r, "deleteCoupon", internalError(fmt.Errorf("error deleting coupon: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logUserAction(r.Context(), "deleteCoupon", "success", map[string]interface{}{"coupon_id": coupon.ID})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func couponTestOrder() Order {
+	one, two := uint(1), uint(2)
+	return Order{
+		Currency:       "USD",
+		SubtotalAmount: 4000,
+		TotalAmount:    4000,
+		Items: []OrderItem{
+			{ProductID: &one, UnitAmount: 1000, Quantity: 1},
+			{ProductID: &two, UnitAmount: 1500, Quantity: 2},
+		},
+	}
+}
+
+func TestApplyCouponPercent(t *testing.T) {
+	order := couponTestOrder()
+	coupon := &Coupon{ID: 5, Code: "SCHOOL15", Kind: couponPercent, PercentOff: 15}
+	if fe := applyCoupon(&order, coupon, func(OrderItem) bool { return true }); fe != nil {
+		t.Fatalf("applyCoupon failed: %+v", fe)
+	}
+	if order.Items[0].DiscountAmount != 150 || order.Items[1].DiscountAmount != 450 {
+		t.Errorf("Unexpected item discounts %d, %d", order.Items[0].DiscountAmount, order.Items[1].DiscountAmount)
+	}
+	if order.DiscountAmount != 600 || order.TotalAmount != 3400 || order.CouponCode != "SCHOOL15" || *order.CouponID != 5 {
+		t.Errorf("Unexpected order %+v", order)
+	}
+}
+
+func TestApplyCouponFixedSplitsAndCaps(t *testing.T) {
+	order := couponTestOrder()
+	coupon := &Coupon{Code: "TENOFF", Kind: couponFixed, AmountOff: 1000, Currency: "USD"}
+	applyCoupon(&order, coupon, func(OrderItem) bool { return true })
+	if order.Items[0].DiscountAmount != 250 || order.Items[1].DiscountAmount != 750 || order.TotalAmount != 3000 {
+		t.Errorf("Expected the discount split 250/750, got %+v", order)
+	}
+
+	order = couponTestOrder()
+	coupon.AmountOff = 5000
+	onlyFirst := func(item OrderItem) bool { return *item.ProductID == 1 }
+	applyCoupon(&order, coupon, onlyFirst)
+	if order.DiscountAmount != 1000 || order.Items[1].DiscountAmount != 0 || order.TotalAmount != 3000 {
+		t.Errorf("Expected the discount capped at the eligible item, got %+v", order)
+	}
+
+	order = couponTestOrder()
+	if fe := applyCoupon(&order, coupon, func(OrderItem) bool { return false }); fe == nil || fe.Code != "not_applicable" {
+		t.Errorf("Expected not_applicable, got %+v", fe)
+	}
+}
+
+func TestCouponCoversCategorySubtree(t *testing.T) {
+	languages, spanish, music := uint(1), uint(2), uint(3)
+	parents := map[uint]*uint{languages: nil, spanish: &languages, music: nil}
+	coupon := &Coupon{CategoryIDs: []uint{languages}, ProductIDs: []uint{42}}
+
+	if !couponCovers(coupon, &Product{ID: 7, CategoryID: &spanish}, parents) {
+		t.Errorf("Expected a product in a subcategory to be covered")
+	}
+	if couponCovers(coupon, &Product{ID: 8, CategoryID: &music}, parents) {
+		t.Errorf("Expected a product in another category not to be covered")
+	}
+	if !couponCovers(coupon, &Product{ID: 42}, parents) {
+		t.Errorf("Expected a listed product to be covered")
+	}
+	if !couponCovers(&Coupon{}, &Product{ID: 9}, nil) {
+		t.Errorf("Expected an unrestricted coupon to cover everything")
+	}
+}
+
+func TestCheckCouponUsable(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	one := 1
+	cases := []struct {
+		name     string
+		coupon   Coupon
+		currency string
+		userUses int64
+		want     string
+	}{
+		{"valid", Coupon{Active: true, Kind: couponPercent, StartsAt: &past, EndsAt: &future}, "USD", 0, ""},
+		{"inactive", Coupon{Kind: couponPercent}, "USD", 0, "invalid"},
+		{"not started", Coupon{Active: true, StartsAt: &future}, "USD", 0, "not_started"},
+		{"expired", Coupon{Active: true, EndsAt: &past}, "USD", 0, "expired"},
+		{"global limit", Coupon{Active: true, MaxUses: &one, Uses: 1}, "USD", 0, "exhausted"},
+		{"user limit", Coupon{Active: true, MaxUsesUser: &one}, "USD", 1, "exhausted"},
+		{"currency", Coupon{Active: true, Kind: couponFixed, Currency: "EUR"}, "USD", 0, "not_applicable"},
+	}
+	for _, c := range cases {
+		fe := checkCouponUsable(&c.coupon, c.currency, c.userUses, now)
+		got := ""
+		if fe != nil {
+			got = fe.Code
+		}
+		if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCouponRequestValidation(t *testing.T) {
+	var coupon Coupon
+	if err := (couponRequest{Code: " school-10 ", Kind: couponFixed, AmountOff: "10", Currency: "eur"}).apply(&coupon); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if coupon.Code != "SCHOOL-10" || coupon.AmountOff != 1000 || coupon.Currency != "EUR" {
+		t.Errorf("Unexpected coupon %+v", coupon)
+	}
+
+	start, zero, five := time.Now(), 0, 5
+	limited := couponRequest{Code: "LIMITED", Kind: couponPercent, PercentOff: 10, MaxUses: &five, MaxUsesUser: &five}
+	if err := validateRequest(limited); err != nil {
+		t.Errorf("Expected usage limits to validate, got %v", err)
+	}
+
+	codes := fieldCodes(couponRequest{Code: "50% OFF", Kind: couponPercent, StartsAt: &start, EndsAt: &start, MaxUses: &zero}.apply(&coupon))
+	for _, field := range []string{"code", "percent_off", "ends_at", "max_uses"} {
+		if codes[field] == "" {
+			t.Errorf("Expected an error for %s, got %v", field, codes)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"LanguageLearningPlatform/mailer"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultSupportEmail = "alan4ik.selivanov@yandex.kz"
+
+var (
+	mailSender   mailer.Mailer = &mailer.Memory{}
+	mailFrom     string
+	supportEmail = defaultSupportEmail
+	appBaseURL   = "http://localhost:8080"
+)
+
+func getenvDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// initMailer selects the delivery backend from MAIL_BACKEND: "smtp"
+// (default), "file" (maildir under MAIL_DIR) or "memory".
+func initMailer() error {
+	mailFrom = getenvDefault("MAIL_FROM", os.Getenv("SMTP_USER"))
+	supportEmail = getenvDefault("SUPPORT_EMAIL", defaultSupportEmail)
+	appBaseURL = strings.TrimRight(getenvDefault("APP_BASE_URL", appBaseURL), "/")
+
+	switch backend := getenvDefault("MAIL_BACKEND", "smtp"); backend {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		tlsMode := mailer.TLSStartTLS
+		if port == "465" {
+			tlsMode = mailer.TLSImplicit
+		}
+		if mode := os.Getenv("SMTP_TLS"); mode != "" {
+			tlsMode = mailer.TLSMode(mode)
+		}
+		switch tlsMode {
+		case mailer.TLSStartTLS, mailer.TLSImplicit, mailer.TLSNone:
+		default:
+			return fmt.Errorf("unknown SMTP_TLS %q", tlsMode)
+		}
+		mailSender = &mailer.SMTP{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASS"),
+			TLS:      tlsMode,
+		}
+	case "file":
+		mailSender = &mailer.File{Dir: getenvDefault("MAIL_DIR", "maildir")}
+	case "memory":
+		mailSender = &mailer.Memory{}
+	default:
+		return fmt.Errorf("unknown MAIL_BACKEND %q", backend)
+	}
+	return nil
+}
+
+func deliver(ctx context.Context, spanName string, msg *mailer.Message) (err error) {
+	ctx, span := tracer.Start(ctx, spanName, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("email.recipients", len(msg.To)+len(msg.Cc))))
+	defer func() { endSpan(span, err) }()
+
+	if msg.From == "" {
+		msg.From = mailFrom
+	}
+	err = mailSender.Send(ctx, msg)
+	recordEmailOutcome(err)
+	if err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return nil
+}
+
+func confirmationEmail(ctx context.Context, user User) (*mailer.Message, error) {
+	msg, err := renderEmail(ctx, "confirmation", user.Locale, map[string]interface{}{
+		"Name":       user.Name,
+		"ConfirmURL": appBaseURL + "/confirm?code=" + url.QueryEscape(user.ConfirmationCode),
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.To = []string{user.Email}
+	return msg, nil
+}
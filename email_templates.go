@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"LanguageLearningPlatform/mailer"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//go:embed templates/email
+var emailTemplateFS embed.FS
+
+const (
+	defaultLocale = "en"
+	logoContentID = "logo@languagelearningplatform"
+)
+
+var supportedLocales = []string{"en", "ru"}
+
+// EmailTemplate is an admin override of the embedded template with the same
+// name and locale.
+type EmailTemplate struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"uniqueIndex:idx_email_template_name_locale;not null"`
+	Locale    string    `json:"locale" gorm:"uniqueIndex:idx_email_template_name_locale;not null"`
+	Subject   string    `json:"subject"`
+	Text      string    `json:"text" gorm:"type:text"`
+	HTML      string    `json:"html" gorm:"type:text"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type parsedEmailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+func (t EmailTemplate) parse() (*parsedEmailTemplate, error) {
+	var (
+		p   parsedEmailTemplate
+		err error
+	)
+	if p.subject, err = texttemplate.New("subject").Parse(t.Subject); err != nil {
+		return nil, fieldErrorFor("subject", err)
+	}
+	if p.text, err = texttemplate.New("text").Parse(t.Text); err != nil {
+		return nil, fieldErrorFor("text", err)
+	}
+	if t.HTML != "" {
+		if p.html, err = htmltemplate.New("html").Parse(t.HTML); err != nil {
+			return nil, fieldErrorFor("html", err)
+		}
+	}
+	return &p, nil
+}
+
+func fieldErrorFor(field string, err error) error {
+	return validationFailed(fieldError(field, "template", err.Error()))
+}
+
+func isSupportedLocale(locale string) bool {
+	for _, l := range supportedLocales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveLocale returns the first supported language among the candidates,
+// each of which may be a bare tag ("ru") or an Accept-Language header.
+func resolveLocale(candidates ...string) string {
+	for _, candidate := range candidates {
+		for _, tag := range strings.Split(candidate, ",") {
+			tag, _, _ = strings.Cut(tag, ";")
+			tag, _, _ = strings.Cut(strings.TrimSpace(tag), "-")
+			if tag = strings.ToLower(tag); isSupportedLocale(tag) {
+				return tag
+			}
+		}
+	}
+	return defaultLocale
+}
+
+func emailTemplateNames() []string {
+	entries, _ := fs.ReadDir(emailTemplateFS, path.Join("templates/email", defaultLocale))
+	seen := map[string]bool{}
+	var names []string
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), path.Ext(e.Name()))
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func defaultEmailTemplate(name, locale string) (EmailTemplate, bool) {
+	dir := path.Join("templates/email", locale)
+	read := func(ext string) string {
+		data, _ := fs.ReadFile(emailTemplateFS, path.Join(dir, name+ext))
+		return string(data)
+	}
+	t := EmailTemplate{Name: name, Locale: locale, Subject: read(".subject"), Text: read(".txt"), HTML: read(".html")}
+	return t, t.Subject != "" && t.Text != ""
+}
+
+// loadEmailTemplate prefers a database override for the locale, then the
+// embedded template for it, then both again for defaultLocale.
+func loadEmailTemplate(ctx context.Context, name, locale string) (EmailTemplate, error) {
+	for _, loc := range []string{locale, defaultLocale} {
+		if Db != nil {
+			var override EmailTemplate
+			err := Db.WithContext(ctx).Where("name = ? AND locale = ?", name, loc).First(&override).Error
+			if err == nil {
+				return override, nil
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return EmailTemplate{}, err
+			}
+		}
+		if t, ok := defaultEmailTemplate(name, loc); ok {
+			return t, nil
+		}
+	}
+	return EmailTemplate{}, fmt.Errorf("email template %q not found", name)
+}
+
+func renderEmail(ctx context.Context, name, locale string, data map[string]interface{}) (*mailer.Message, error) {
+	tpl, err := loadEmailTemplate(ctx, name, locale)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := tpl.parse()
+	if err != nil {
+		return nil, fmt.Errorf("email template %s/%s: %v", tpl.Name, tpl.Locale, err)
+	}
+
+	data["LogoCID"] = logoContentID
+	var subject, text, html bytes.Buffer
+	if err := parsed.subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := parsed.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if parsed.html != nil {
+		if err := parsed.html.Execute(&html, data); err != nil {
+			return nil, err
+		}
+	}
+
+	msg := &mailer.Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}
+	if strings.Contains(msg.HTML, "cid:"+logoContentID) {
+		logo, err := emailTemplateFS.ReadFile("templates/email/logo.png")
+		if err != nil {
+			return nil, err
+		}
+		msg.Inline = []mailer.Attachment{{Filename: "logo.png", ContentType: "image/png", Data: logo, ContentID: logoContentID}}
+	}
+	return msg, nil
+}
+
+type emailTemplateView struct {
+	EmailTemplate
+	Overridden bool `json:"overridden"`
+}
+
+func getEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	var overrides []EmailTemplate
+	if err := Db.WithContext(r.Context()).Find(&overrides).Error; err != nil {
+		handleError(w, r, "getEmailTemplates", internalError(fmt.Errorf("error retrieving email templates: %v", err)))
+		return
+	}
+	byKey := make(map[string]EmailTemplate, len(overrides))
+	for _, o := range overrides {
+		byKey[o.Name+"/"+o.Locale] = o
+	}
+
+	var views []emailTemplateView
+	for _, name := range emailTemplateNames() {
+		for _, locale := range supportedLocales {
+			if o, ok := byKey[name+"/"+locale]; ok {
+				views = append(views, emailTemplateView{EmailTemplate: o, Overridden: true})
+			} else if t, ok := defaultEmailTemplate(name, locale); ok {
+				views = append(views, emailTemplateView{EmailTemplate: t})
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+func emailTemplateKey(w http.ResponseWriter, r *http.Request, action string) (string, string, bool) {
+	name, locale := r.PathValue("name"), r.PathValue("locale")
+	if _, ok := defaultEmailTemplate(name, defaultLocale); !ok {
+		handleError(w, r, action, notFound("Email template not found", nil))
+		return "", "", false
+	}
+	if !isSupportedLocale(locale) {
+		handleError(w, r, action, invalidParameter(fmt.Sprintf("Locale must be one of %s", strings.Join(supportedLocales, ", "))))
+		return "", "", false
+	}
+	return name, locale, true
+}
+
+func putEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	name, locale, ok := emailTemplateKey(w, r, "putEmailTemplate")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Subject string `json:"subject" validate:"required,max=255"`
+		Text    string `json:"text" validate:"required,max=20000"`
+		HTML    string `json:"html" validate:"max=100000"`
+	}
+	if !decodeAndValidate(w, r, "putEmailTemplate", &req) {
+		return
+	}
+
+	tpl := EmailTemplate{Name: name, Locale: locale, Subject: req.Subject, Text: req.Text, HTML: req.HTML, UpdatedAt: time.Now()}
+	if _, err := tpl.parse(); err != nil {
+		handleError(w, r, "putEmailTemplate", err)
+		return
+	}
+
+	err := Db.WithContext(r.Context()).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}, {Name: "locale"}},
+		DoUpdates: clause.AssignmentColumns([]string{"subject", "text", "html", "updated_at"}),
+	}).Create(&tpl).Error
+	if err != nil {
+		handleError(w, r, "putEmailTemplate", internalError(fmt.Errorf("error saving email template: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(emailTemplateView{EmailTemplate: tpl, Overridden: true})
+	logUserAction(r.Context(), "putEmailTemplate", "success", map[string]interface{}{"name": name, "locale": locale})
+}
+
+func deleteEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	name, locale, ok := emailTemplateKey(w, r, "deleteEmailTemplate")
+	if !ok {
+		return
+	}
+
+	if err := Db.WithContext(r.Context()).Where("name = ? AND locale = ?", name, locale).Delete(&EmailTemplate{}).Error; err != nil {
+		handleError(w, r, "deleteEmailTemplate", internalError(fmt.Errorf("error deleting email template: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logUserAction(r.Context(), "deleteEmailTemplate", "success", map[string]interface{}{"name": name, "locale": locale})
+}
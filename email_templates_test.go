@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestResolveLocale(t *testing.T) {
+	cases := []struct {
+		candidates []string
+		expected   string
+	}{
+		{[]string{"ru"}, "ru"},
+		{[]string{"", "ru-RU,ru;q=0.9,en;q=0.8"}, "ru"},
+		{[]string{"de", "fr-FR, en-US;q=0.5"}, "en"},
+		{[]string{"", ""}, defaultLocale},
+	}
+	for _, c := range cases {
+		if got := resolveLocale(c.candidates...); got != c.expected {
+			t.Errorf("resolveLocale(%q) = %q, expected %q", c.candidates, got, c.expected)
+		}
+	}
+}
+
+func TestEmbeddedEmailTemplatesParse(t *testing.T) {
+	for _, name := range emailTemplateNames() {
+		for _, locale := range supportedLocales {
+			tpl, ok := defaultEmailTemplate(name, locale)
+			if !ok {
+				t.Errorf("Missing embedded template %s/%s", name, locale)
+				continue
+			}
+			if _, err := tpl.parse(); err != nil {
+				t.Errorf("Template %s/%s does not parse: %v", name, locale, err)
+			}
+		}
+	}
+}
+
+func TestRenderConfirmationEmailPerLocale(t *testing.T) {
+	db := Db
+	Db = nil
+	defer func() { Db = db }()
+
+	user := User{Name: "Анна <script>", Email: "anna@example.com", ConfirmationCode: "a b", Locale: "ru"}
+	msg, err := confirmationEmail(context.Background(), user)
+	if err != nil {
+		t.Fatalf("confirmationEmail failed: %v", err)
+	}
+	if msg.Subject != "Подтверждение регистрации" {
+		t.Errorf("Unexpected subject %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "/confirm?code=a+b") {
+		t.Errorf("Confirmation link missing from text body: %s", msg.Text)
+	}
+	if strings.Contains(msg.HTML, "<script>") || !strings.Contains(msg.HTML, "cid:"+logoContentID) {
+		t.Errorf("HTML body was not escaped or lacks the logo: %s", msg.HTML)
+	}
+	if len(msg.Inline) != 1 || msg.Inline[0].ContentID != logoContentID {
+		t.Errorf("Expected the logo as an inline part, got %+v", msg.Inline)
+	}
+
+	user.Locale = "de"
+	msg, _ = confirmationEmail(context.Background(), user)
+	if msg.Subject != "Confirm your registration" {
+		t.Errorf("Expected fallback to the default locale, got %q", msg.Subject)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Enrollment gives a user access to a product they bought. Refunding the
+// order that granted it sets RevokedAt; buying the product again restores it.
+type Enrollment struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"uniqueIndex:idx_enrollments_product;not null"`
+	ProductID uint       `json:"product_id" gorm:"uniqueIndex:idx_enrollments_product;not null"`
+	Product   *Product   `json:"product,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	OrderID   uint       `json:"order_id" gorm:"index"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+func grantEnrollments(tx *gorm.DB, order *Order) error {
+	var enrollments []Enrollment
+	for _, item := range order.Items {
+		if item.ProductID != nil {
+			enrollments = append(enrollments, Enrollment{UserID: order.UserID, ProductID: *item.ProductID, OrderID: order.ID})
+		}
+	}
+	if len(enrollments) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "product_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"order_id":   order.ID,
+			"revoked_at": nil,
+			"updated_at": time.Now(),
+		}),
+	}).Create(&enrollments).Error
+}
+
+// revokeEnrollments revokes only enrollments still attributed to order, so
+// refunding an old order leaves access bought again later intact.
+func revokeEnrollments(tx *gorm.DB, order *Order) error {
+	return tx.Model(&Enrollment{}).
+		Where("order_id = ? AND revoked_at IS NULL", order.ID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func getMyEnrollments(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+
+	var enrollments []Enrollment
+	err := Db.WithContext(r.Context()).Preload("Product").
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").Find(&enrollments).Error
+	if err != nil {
+		handleError(w, r, "getMyEnrollments", internalError(fmt.Errorf("error retrieving enrollments: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollments)
+}
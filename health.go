@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// checkResult is public, so failures are reported as "fail" only; the
+// error itself, which may name hosts and ports, goes to the log.
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+type readinessReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+var readinessChecks = []readinessCheck{
+	{"database", checkDatabase},
+	{"migrations", checkMigrations},
+	{"smtp", checkSMTPConfig},
+	{"log_file", checkLogFile},
+	{"storage", checkStorage},
+}
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	report := readinessReport{Status: "ok", Checks: make(map[string]checkResult, len(readinessChecks))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, c := range readinessChecks {
+		wg.Add(1)
+		go func(c readinessCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := c.check(ctx)
+			result := checkResult{Status: "ok", LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = "fail"
+				requestLogger(r.Context()).WithField("check", c.name).WithError(err).Warn("Readiness check failed")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if err != nil {
+				report.Status = "fail"
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+		logUserAction(r.Context(), "readyz", "error", map[string]interface{}{"checks": report.Checks})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+func checkDatabase(ctx context.Context) error {
+	if Db == nil {
+		return errors.New("database is not initialized")
+	}
+	sqlDB, err := Db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func checkMigrations(ctx context.Context) error {
+	if Db == nil {
+		return errors.New("database is not initialized")
+	}
+	migrator := Db.WithContext(ctx).Migrator()
+	var missing []string
+	for _, model := range models {
+		if !migrator.HasTable(model) {
+			missing = append(missing, fmt.Sprintf("%T", model))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables for %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func checkSMTPConfig(ctx context.Context) error {
+	if getenvDefault("MAIL_BACKEND", "smtp") != "smtp" {
+		return nil
+	}
+	var missing []string
+	for _, key := range []string{"SMTP_USER", "SMTP_PASS", "SMTP_HOST", "SMTP_PORT"} {
+		if os.Getenv(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkLogFile opens the log for appending, which fails if the file or its
+// directory is not writable, without adding anything to it.
+func checkLogFile(ctx context.Context) error {
+	f, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+const storageProbeTTL = 30 * time.Second
+
+// storageProbe caches the last storage round trip, so frequent readiness
+// probes do not put and delete a blob every time.
+var storageProbe struct {
+	sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+func checkStorage(ctx context.Context) error {
+	storageProbe.Lock()
+	defer storageProbe.Unlock()
+	if !storageProbe.checkedAt.IsZero() && time.Since(storageProbe.checkedAt) < storageProbeTTL {
+		return storageProbe.err
+	}
+
+	const probeKey = "readyz/probe"
+	err := blobs.Store.Put(ctx, probeKey, strings.NewReader("ok"), 2, "text/plain")
+	if err == nil {
+		err = blobs.Store.Delete(ctx, probeKey)
+	}
+	storageProbe.checkedAt = time.Now()
+	storageProbe.err = err
+	return err
+}
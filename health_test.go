@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"LanguageLearningPlatform/storage"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+func TestReadyzReportsEachCheck(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+	Db = nil
+	t.Setenv("SMTP_USER", "")
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	response := httptest.NewRecorder()
+	readyz(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if response.Code != http.StatusServiceUnavailable {
+		t.Errorf("Incorrect status code. Expected: %d, Got: %d", http.StatusServiceUnavailable, response.Code)
+	}
+
+	if body := response.Body.String(); strings.Contains(body, "SMTP_USER") || strings.Contains(body, "not initialized") {
+		t.Errorf("Expected check errors to stay out of the response, got %s", body)
+	}
+
+	var report readinessReport
+	if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expected := map[string]string{"database": "fail", "migrations": "fail", "smtp": "fail", "log_file": "ok", "storage": "ok"}
+	for name, status := range expected {
+		if report.Checks[name].Status != status {
+			t.Errorf("Check %s: expected %s, got %+v", name, status, report.Checks[name])
+		}
+	}
+}
+
+func TestProbesBypassRateLimiter(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+	previous := limiter
+	limiter = rate.NewLimiter(0, 0)
+	defer func() { limiter = previous }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", healthz)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	handler := rateLimiterMiddleware(mux)
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to bypass the rate limiter, got %d", response.Code)
+	}
+
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	if response.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected other routes to be rate limited, got %d", response.Code)
+	}
+}
+
+type countingStore struct {
+	storage.Memory
+	puts int
+}
+
+func (s *countingStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	s.puts++
+	return s.Memory.Put(ctx, key, r, size, contentType)
+}
+
+func TestCheckStorageCachesResult(t *testing.T) {
+	previous := blobs.Store
+	store := &countingStore{}
+	blobs.Store = store
+	storageProbe.checkedAt = time.Time{}
+	defer func() {
+		blobs.Store = previous
+		storageProbe.checkedAt = time.Time{}
+	}()
+
+	for i := 0; i < 3; i++ {
+		if err := checkStorage(context.Background()); err != nil {
+			t.Fatalf("checkStorage failed: %v", err)
+		}
+	}
+	if store.puts != 1 {
+		t.Errorf("Expected one storage round trip within the TTL, got %d", store.puts)
+	}
+
+	storageProbe.checkedAt = time.Now().Add(-storageProbeTTL)
+	checkStorage(context.Background())
+	if store.puts != 2 {
+		t.Errorf("Expected the probe to run again after the TTL, got %d", store.puts)
+	}
+}
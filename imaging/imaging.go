@@ -0,0 +1,102 @@
+// Package imaging turns an uploaded JPEG, PNG or WebP picture into
+// re-encoded renditions of bounded size. Re-encoding drops EXIF and any other
+// metadata carried by the original file.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxPixels guards against decompression bombs: small files that declare
+// enormous dimensions.
+const MaxPixels = 40_000_000
+
+const jpegQuality = 85
+
+var ErrUnsupported = errors.New("imaging: unsupported or corrupt image")
+
+type Rendition struct {
+	Data        []byte
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+}
+
+// Renditions decodes data, applies its EXIF orientation and returns one
+// rendition per entry of sizes, each fitting in a size x size box. Images
+// are never upscaled. Pictures with transparency are encoded as PNG, all
+// others as JPEG.
+func Renditions(data []byte, sizes map[string]int) (map[string]Rendition, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if format != "jpeg" && format != "png" && format != "webp" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, format)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d exceeds the pixel limit", ErrUnsupported, cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	opaque := isOpaque(src)
+
+	out := make(map[string]Rendition, len(sizes))
+	for name, size := range sizes {
+		img := orient(fit(src, size), orientation)
+		r := Rendition{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+		var buf bytes.Buffer
+		if opaque {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+			r.ContentType, r.Ext = "image/jpeg", ".jpg"
+		} else {
+			err = png.Encode(&buf, img)
+			r.ContentType, r.Ext = "image/png", ".png"
+		}
+		if err != nil {
+			return nil, fmt.Errorf("imaging: encode %s: %v", name, err)
+		}
+		r.Data = buf.Bytes()
+		out[name] = r
+	}
+	return out, nil
+}
+
+// fit scales src down to fit in a size x size box.
+func fit(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// withOrientation inserts an APP1 EXIF segment carrying the orientation tag
+// right after the JPEG SOI marker.
+func withOrientation(jpg []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	ifd := make([]byte, 2+12+4)
+	binary.BigEndian.PutUint16(ifd, 1)
+	binary.BigEndian.PutUint16(ifd[2:], 0x0112)
+	binary.BigEndian.PutUint16(ifd[4:], 3)
+	binary.BigEndian.PutUint32(ifd[6:], 1)
+	binary.BigEndian.PutUint16(ifd[10:], orientation)
+	payload := append(append([]byte("Exif\x00\x00"), tiff...), ifd...)
+
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, seg...)
+	out = append(out, payload...)
+	return append(out, jpg[2:]...)
+}
+
+func TestRenditionsOrientAndStripEXIF(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, src, nil)
+	data := withOrientation(buf.Bytes(), 6)
+	if jpegOrientation(data) != 6 {
+		t.Fatalf("Orientation not detected")
+	}
+
+	out, err := Renditions(data, map[string]int{"thumb": 100, "large": 1000})
+	if err != nil {
+		t.Fatalf("Renditions failed: %v", err)
+	}
+	thumb := out["thumb"]
+	if thumb.Width != 50 || thumb.Height != 100 || thumb.ContentType != "image/jpeg" {
+		t.Errorf("Unexpected thumb %dx%d %s", thumb.Width, thumb.Height, thumb.ContentType)
+	}
+	if large := out["large"]; large.Width != 200 || large.Height != 400 {
+		t.Errorf("Image was upscaled or not rotated: %dx%d", large.Width, large.Height)
+	}
+	if bytes.Contains(thumb.Data, []byte("Exif")) {
+		t.Error("EXIF survived re-encoding")
+	}
+}
+
+func TestRenditionsKeepTransparency(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	src.Set(1, 1, color.NRGBA{R: 255, A: 128})
+	var buf bytes.Buffer
+	png.Encode(&buf, src)
+
+	out, err := Renditions(buf.Bytes(), map[string]int{"thumb": 5})
+	if err != nil {
+		t.Fatalf("Renditions failed: %v", err)
+	}
+	if out["thumb"].ContentType != "image/png" || out["thumb"].Ext != ".png" {
+		t.Errorf("Expected PNG for a transparent image, got %s", out["thumb"].ContentType)
+	}
+}
+
+func TestRenditionsRejectsNonImages(t *testing.T) {
+	for _, data := range [][]byte{[]byte("GIF89a..."), []byte("\x89PNG\r\n\x1a\ngarbage")} {
+		if _, err := Renditions(data, map[string]int{"thumb": 10}); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("Expected ErrUnsupported, got %v", err)
+		}
+	}
+}
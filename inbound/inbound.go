@@ -0,0 +1,205 @@
+// Package inbound parses raw RFC 822 email, as delivered by an MTA or found
+// in a maildir, into a reply body and attachments.
+package inbound
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const maxPartDepth = 10
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+type Message struct {
+	From        string
+	To          []string
+	Subject     string
+	MessageID   string
+	InReplyTo   string
+	References  []string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// Parse reads a complete message. Text holds the first text/plain part, or
+// the tag-stripped HTML part when there is no plain alternative.
+func Parse(r io.Reader) (*Message, error) {
+	raw, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("inbound: invalid message: %v", err)
+	}
+
+	msg := &Message{
+		MessageID:  strings.TrimSpace(raw.Header.Get("Message-ID")),
+		InReplyTo:  strings.TrimSpace(raw.Header.Get("In-Reply-To")),
+		References: strings.Fields(raw.Header.Get("References")),
+	}
+	if msg.Subject, err = wordDecoder.DecodeHeader(raw.Header.Get("Subject")); err != nil {
+		msg.Subject = raw.Header.Get("Subject")
+	}
+
+	parser := mail.AddressParser{WordDecoder: wordDecoder}
+	from, err := parser.Parse(raw.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("inbound: invalid From header: %v", err)
+	}
+	msg.From = from.Address
+	for _, key := range []string{"To", "Cc", "Delivered-To", "X-Original-To"} {
+		if list, err := parser.ParseList(raw.Header.Get(key)); err == nil {
+			for _, addr := range list {
+				msg.To = append(msg.To, addr.Address)
+			}
+		}
+	}
+
+	if err := msg.walk(raw.Header, raw.Body, 0); err != nil {
+		return nil, err
+	}
+	if msg.Text == "" && msg.HTML != "" {
+		msg.Text = htmlToText(msg.HTML)
+	}
+	return msg, nil
+}
+
+// header is satisfied by both mail.Header and textproto.MIMEHeader.
+type header interface {
+	Get(key string) string
+}
+
+func (m *Message) walk(h header, body io.Reader, depth int) error {
+	if depth > maxPartDepth {
+		return errors.New("inbound: message nesting is too deep")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{"charset": "utf-8"}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("inbound: invalid multipart body: %v", err)
+			}
+			if err := m.walk(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(h.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("inbound: failed to decode part: %v", err)
+	}
+
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if decoded, err := wordDecoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	switch {
+	case disposition != "attachment" && filename == "" && mediaType == "text/plain" && m.Text == "":
+		m.Text = decodeCharset(params["charset"], data)
+	case disposition != "attachment" && filename == "" && mediaType == "text/html" && m.HTML == "":
+		m.HTML = decodeCharset(params["charset"], data)
+	case filename != "" || disposition == "attachment":
+		if filename == "" {
+			filename = "attachment"
+		}
+		m.Attachments = append(m.Attachments, Attachment{Filename: filename, ContentType: mediaType, Data: data})
+	}
+	return nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, newlineStripper{r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// newlineStripper drops CR and LF so base64 bodies wrapped at 76 columns
+// decode cleanly.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (s newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	out := p[:0]
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			out = append(out, b)
+		}
+	}
+	return len(out), err
+}
+
+func decodeCharset(charset string, data []byte) string {
+	charset = strings.ToLower(charset)
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return string(data)
+	}
+	r, err := charsetReader(charset, bytes.NewReader(data))
+	if err != nil {
+		return string(data)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+var (
+	htmlBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>`)
+	htmlQuotePattern = regexp.MustCompile(`(?is)<blockquote.*?</blockquote>`)
+	htmlTagPattern   = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+func htmlToText(html string) string {
+	text := htmlQuotePattern.ReplaceAllString(html, "")
+	text = htmlBreakPattern.ReplaceAllString(text, "\n")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	return strings.NewReplacer("&nbsp;", " ", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'", "&amp;", "&").Replace(text)
+}
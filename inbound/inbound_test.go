@@ -0,0 +1,89 @@
+package inbound
+
+import (
+	"strings"
+	"testing"
+)
+
+const gmailReply = "From: =?UTF-8?B?0JDQvdC90LA=?= <anna@example.com>\r\n" +
+	"To: support+0123456789abcdef0123456789abcdef@example.com\r\n" +
+	"Subject: Re: [Ticket #7] Lesson 3\r\n" +
+	"Message-ID: <reply-1@mail.example.com>\r\n" +
+	"In-Reply-To: <ticket.0123456789abcdef0123456789abcdef.1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=windows-1251\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"=D1=EF=E0=F1=E8=E1=EE, =F2=E5=EF=E5=F0=FC =F0=E0=E1=EE=F2=E0=E5=F2!\r\n" +
+	"\r\n" +
+	"On Mon, 1 Jan 2024 at 10:00, Support <support@example.com>\r\n" +
+	"wrote:\r\n" +
+	"> Please try reloading the page\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>ignored</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/png; name=\"screen.png\"\r\n" +
+	"Content-Disposition: attachment; filename=\"screen.png\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0K\r\n" +
+	"Gg==\r\n" +
+	"--outer--\r\n"
+
+func TestParseMultipartReply(t *testing.T) {
+	msg, err := Parse(strings.NewReader(gmailReply))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if msg.From != "anna@example.com" || msg.MessageID != "<reply-1@mail.example.com>" {
+		t.Errorf("Unexpected headers: from=%q id=%q", msg.From, msg.MessageID)
+	}
+	if len(msg.To) != 1 || !strings.HasPrefix(msg.To[0], "support+") {
+		t.Errorf("Unexpected recipients %v", msg.To)
+	}
+	if !strings.HasPrefix(msg.Text, "Спасибо, теперь работает!") {
+		t.Errorf("windows-1251 text was not decoded: %q", msg.Text)
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Filename != "screen.png" || string(msg.Attachments[0].Data) != "\x89PNG\r\n\x1a" {
+		t.Errorf("Unexpected attachments %+v", msg.Attachments)
+	}
+	if got := StripQuoted(msg.Text); got != "Спасибо, теперь работает!" {
+		t.Errorf("StripQuoted = %q", got)
+	}
+}
+
+func TestStripQuoted(t *testing.T) {
+	cases := map[string]string{
+		"Thanks!\n\n> old text\n> more": "Thanks!",
+		"Спасибо\n\n1 января 2024 г., Поддержка написал(а):\n> текст":    "Спасибо",
+		"Fixed\n-----Original Message-----\nFrom: support":               "Fixed",
+		"Answer\n-- \nAnna\nSent from my phone":                          "Answer",
+		"Line one\nLine two":                                             "Line one\nLine two",
+		"See below\nOn Tue, Jan 2, 2024 at 9:00 AM Support wrote:\n> hi": "See below",
+	}
+	for in, expected := range cases {
+		if got := StripQuoted(in); got != expected {
+			t.Errorf("StripQuoted(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+}
+
+func TestParseHTMLOnly(t *testing.T) {
+	raw := "From: a@example.com\r\nContent-Type: text/html; charset=utf-8\r\n\r\n<div>Hi &amp; thanks<br>Anna</div><blockquote>old</blockquote>"
+	msg, err := Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if strings.TrimSpace(msg.Text) != "Hi & thanks\nAnna" {
+		t.Errorf("Unexpected text %q", msg.Text)
+	}
+}
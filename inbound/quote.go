@@ -0,0 +1,50 @@
+package inbound
+
+import (
+	"regexp"
+	"strings"
+)
+
+// quoteHeaderPatterns match the line a mail client inserts above the quoted
+// original. Everything from that line on is dropped.
+var quoteHeaderPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^On .+ wrote:\s*$`),
+	regexp.MustCompile(`(?i)^.+ (написал|написала|написал\(а\)):\s*$`),
+	regexp.MustCompile(`(?i)^-+\s*(Original Message|Исходное сообщение|Пересылаемое сообщение)\s*-+\s*$`),
+	regexp.MustCompile(`(?i)^(From|От):\s.+$`),
+	regexp.MustCompile(`^_{10,}\s*$`),
+}
+
+// StripQuoted returns only the new part of a reply: quoted lines, the
+// attribution line above them and the signature are removed.
+func StripQuoted(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var kept []string
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if line == "-- " || line == "--" {
+			break
+		}
+		if isQuoteHeader(trimmed) {
+			break
+		}
+		// Clients such as Gmail wrap a long attribution over two lines.
+		if i+1 < len(lines) && strings.HasPrefix(trimmed, "On ") && isQuoteHeader(trimmed+" "+strings.TrimSpace(lines[i+1])) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+func isQuoteHeader(line string) bool {
+	for _, p := range quoteHeaderPatterns {
+		if p.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"LanguageLearningPlatform/inbound"
+	"LanguageLearningPlatform/storage"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxInboundEmailBytes = 25 << 20
+
+var (
+	replyTokenAddressPattern   = regexp.MustCompile(`\+([0-9a-f]{32})@`)
+	replyTokenMessageIDPattern = regexp.MustCompile(`<ticket\.([0-9a-f]{32})\.`)
+)
+
+func newReplyToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// replyAddress plus-addresses the inbound mailbox (INBOUND_EMAIL_ADDRESS,
+// default SUPPORT_EMAIL) with the ticket's reply token.
+func replyAddress(token string) string {
+	local, domain, _ := strings.Cut(getenvDefault("INBOUND_EMAIL_ADDRESS", supportEmail), "@")
+	return local + "+" + token + "@" + domain
+}
+
+// replyMessageID embeds the token so replies can be matched through
+// In-Reply-To even when the client drops the plus address.
+func replyMessageID(token string) string {
+	_, domain, _ := strings.Cut(supportEmail, "@")
+	return fmt.Sprintf("<ticket.%s.%d@%s>", token, time.Now().UnixNano(), domain)
+}
+
+func replyTokenFromEmail(msg *inbound.Message) string {
+	for _, addr := range msg.To {
+		if m := replyTokenAddressPattern.FindStringSubmatch(strings.ToLower(addr)); m != nil {
+			return m[1]
+		}
+	}
+	for _, id := range append([]string{msg.InReplyTo}, msg.References...) {
+		if m := replyTokenMessageIDPattern.FindStringSubmatch(id); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// inboundAuthor attributes an emailed reply by its From address: the
+// ticket's customer, a staff member, or nobody, in which case the message
+// is flagged for review.
+func inboundAuthor(ctx context.Context, ticket Ticket, from string) (*uint, string, bool, error) {
+	if strings.EqualFold(from, ticket.Email) {
+		return &ticket.UserID, authorCustomer, false, nil
+	}
+	var staff User
+	err := Db.WithContext(ctx).Select("id").
+		Where("LOWER(email) = LOWER(?) AND role IN ?", from, []string{"agent", "admin"}).First(&staff).Error
+	if err == nil {
+		return &staff.ID, authorAgent, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", false, err
+	}
+	return nil, authorCustomer, true, nil
+}
+
+// processInboundEmail appends an emailed reply to its ticket. Redelivery of
+// the same Message-ID is a no-op. Replies from the customer or an unknown
+// sender reopen the ticket, so flagged messages land in the agents' queue.
+func processInboundEmail(ctx context.Context, r io.Reader) (*TicketMessage, error) {
+	email, err := inbound.Parse(r)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, codeInvalidParameter, "Message could not be parsed", err)
+	}
+
+	token := replyTokenFromEmail(email)
+	if token == "" {
+		return nil, notFound("Message does not reference a ticket", nil)
+	}
+	var ticket Ticket
+	if err := Db.WithContext(ctx).Where("reply_token = ?", token).First(&ticket).Error; err != nil {
+		return nil, notFound("Ticket not found", err)
+	}
+
+	if email.MessageID != "" {
+		var existing TicketMessage
+		err := Db.WithContext(ctx).Where("ticket_id = ? AND email_message_id = ?", ticket.ID, email.MessageID).First(&existing).Error
+		if err == nil {
+			return &existing, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, internalError(err)
+		}
+	}
+
+	authorID, role, flagged, err := inboundAuthor(ctx, ticket, email.From)
+	if err != nil {
+		return nil, internalError(fmt.Errorf("error checking inbound sender: %v", err))
+	}
+	message := TicketMessage{
+		TicketID:       ticket.ID,
+		AuthorID:       authorID,
+		AuthorRole:     role,
+		Body:           inbound.StripQuoted(email.Text),
+		EmailMessageID: email.MessageID,
+	}
+	if flagged {
+		message.SenderEmail = email.From
+		message.NeedsReview = true
+		requestLogger(ctx).WithFields(logrus.Fields{"ticket_id": ticket.ID, "from": email.From}).Warn("Inbound reply sender does not match the ticket")
+	}
+	for _, a := range email.Attachments {
+		obj, err := blobs.Save(ctx, "tickets", a.Filename, bytes.NewReader(a.Data), ticketAttachmentPolicy)
+		if errors.Is(err, storage.ErrTooLarge) || errors.Is(err, storage.ErrTypeNotAllowed) || errors.Is(err, storage.ErrInfected) {
+			requestLogger(ctx).WithFields(logrus.Fields{"ticket_id": ticket.ID, "filename": a.Filename}).WithError(err).Warn("Skipped inbound attachment")
+			continue
+		}
+		if err != nil {
+			discardTicketAttachments(ctx, message.Attachments)
+			return nil, uploadError(err)
+		}
+		message.Attachments = append(message.Attachments, newTicketAttachment(obj))
+	}
+	if message.Body == "" && len(message.Attachments) == 0 {
+		return nil, validationFailed(fieldError("body", "required", "reply is empty after removing quoted text"))
+	}
+
+	// The lookup above skips most redeliveries; the unique index settles a
+	// race between two deliveries of the same message.
+	duplicate := false
+	err = Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit("Attachments").Create(&message)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			duplicate = true
+			return nil
+		}
+		for i := range message.Attachments {
+			message.Attachments[i].TicketMessageID = message.ID
+		}
+		if len(message.Attachments) > 0 {
+			if err := tx.Create(&message.Attachments).Error; err != nil {
+				return err
+			}
+		}
+		if role != authorCustomer {
+			return tx.Model(&ticket).Update("updated_at", time.Now()).Error
+		}
+		return tx.Model(&ticket).Updates(map[string]interface{}{
+			"status":      ticketOpen,
+			"resolved_at": nil,
+			"updated_at":  time.Now(),
+		}).Error
+	})
+	if err != nil {
+		discardTicketAttachments(ctx, message.Attachments)
+		return nil, internalError(fmt.Errorf("error storing inbound reply: %v", err))
+	}
+	if duplicate {
+		discardTicketAttachments(ctx, message.Attachments)
+		var existing TicketMessage
+		err := Db.WithContext(ctx).Where("ticket_id = ? AND email_message_id = ?", ticket.ID, email.MessageID).First(&existing).Error
+		if err != nil {
+			return nil, internalError(fmt.Errorf("error loading inbound reply: %v", err))
+		}
+		return &existing, nil
+	}
+	return &message, nil
+}
+
+// receiveInboundEmail accepts a raw RFC 822 message POSTed by the MTA. The
+// caller authenticates with the INBOUND_EMAIL_SECRET shared secret.
+func receiveInboundEmail(w http.ResponseWriter, r *http.Request) {
+	secret := os.Getenv("INBOUND_EMAIL_SECRET")
+	provided := r.Header.Get("X-Inbound-Secret")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(provided)) != 1 {
+		handleError(w, r, "receiveInboundEmail", forbidden("Invalid inbound email secret"))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxInboundEmailBytes)
+	message, err := processInboundEmail(r.Context(), r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		err = uploadError(storage.ErrTooLarge)
+	}
+	if err != nil {
+		handleError(w, r, "receiveInboundEmail", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]uint{"ticket_id": message.TicketID, "message_id": message.ID})
+	logUserAction(r.Context(), "receiveInboundEmail", "success", map[string]interface{}{"ticket_id": message.TicketID})
+}
+
+// runMaildirPoller imports messages from dir/new. Processed messages move to
+// dir/cur; ones that cannot be matched or parsed move to dir/failed.
+func runMaildirPoller(ctx context.Context, dir string, interval time.Duration) {
+	for _, sub := range []string{"new", "cur", "failed"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			logger.WithError(err).Error("Failed to prepare inbound maildir")
+			return
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pollMaildir(ctx, dir)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func pollMaildir(ctx context.Context, dir string) {
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		logger.WithError(err).Error("Failed to read inbound maildir")
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, "new", e.Name())
+		f, err := os.Open(path)
+		if err != nil {
+			logger.WithError(err).WithField("file", path).Error("Failed to open inbound message")
+			continue
+		}
+		_, err = processInboundEmail(ctx, f)
+		f.Close()
+
+		target := filepath.Join(dir, "cur", e.Name()+":2,S")
+		if err != nil {
+			logger.WithError(err).WithField("file", path).Warn("Failed to import inbound message")
+			if apiErr := asAPIError(err); apiErr.Status >= 500 {
+				continue
+			}
+			target = filepath.Join(dir, "failed", e.Name())
+		}
+		if err := os.Rename(path, target); err != nil {
+			logger.WithError(err).WithField("file", path).Error("Failed to move inbound message")
+		}
+	}
+}
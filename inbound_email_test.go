@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"LanguageLearningPlatform/inbound"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestReplyTokenFromEmail(t *testing.T) {
+	supportEmail = "help@example.com"
+	token := newReplyToken()
+
+	if addr := replyAddress(token); addr != "help+"+token+"@example.com" {
+		t.Fatalf("Unexpected reply address %q", addr)
+	}
+
+	byAddress := &inbound.Message{To: []string{"other@example.com", strings.ToUpper(replyAddress(token))}}
+	if got := replyTokenFromEmail(byAddress); got != token {
+		t.Errorf("Token from address = %q, expected %q", got, token)
+	}
+
+	byHeader := &inbound.Message{To: []string{"help@example.com"}, References: []string{"<x@y>", replyMessageID(token)}}
+	if got := replyTokenFromEmail(byHeader); got != token {
+		t.Errorf("Token from References = %q, expected %q", got, token)
+	}
+
+	if got := replyTokenFromEmail(&inbound.Message{To: []string{"help@example.com"}}); got != "" {
+		t.Errorf("Expected no token, got %q", got)
+	}
+}
+
+func TestInboundEmailRequiresSecret(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+	t.Setenv("INBOUND_EMAIL_SECRET", "s3cret")
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/inbound/email", strings.NewReader("From: a@example.com\r\n\r\nhi"))
+	request.Header.Set("X-Inbound-Secret", "wrong")
+	response := httptest.NewRecorder()
+	receiveInboundEmail(response, request)
+
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d", response.Code)
+	}
+}
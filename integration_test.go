@@ -2,12 +2,21 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"LanguageLearningPlatform/mailer"
+
+	"github.com/golang-jwt/jwt/v4"
 )
 
 func TestGetUserByID(t *testing.T) {
@@ -60,11 +69,15 @@ func TestCreateUser(t *testing.T) {
 	initLogger()
 	InitDB()
 	defer Db.Exec("DELETE FROM users")
+	defer Db.Exec("DELETE FROM outbox_messages")
+	outbox := &mailer.Memory{}
+	mailSender = outbox
 
 	user := map[string]string{
 		"name":     "Test User",
 		"email":    "testuser@example.com",
 		"password": "password123",
+		"role":     "admin",
 	}
 
 	body, _ := json.Marshal(user)
@@ -92,6 +105,16 @@ func TestCreateUser(t *testing.T) {
 		t.Errorf("User data does not match. Expected Name: %s, Got: %s. Expected Email: %s, Got: %s",
 			user["name"], createdUser.Name, user["email"], createdUser.Email)
 	}
+	if createdUser.Role != "user" {
+		t.Errorf("Expected signup to ignore the requested role, got %q", createdUser.Role)
+	}
+
+	if _, err := processOutbox(context.Background()); err != nil {
+		t.Fatalf("Failed to process outbox: %v", err)
+	}
+	if sent := outbox.Messages(); len(sent) != 1 || sent[0].To[0] != user["email"] {
+		t.Errorf("Expected one confirmation email to %s, got %+v", user["email"], sent)
+	}
 }
 
 func TestUpdateUser(t *testing.T) {
@@ -128,7 +151,7 @@ func TestUpdateUser(t *testing.T) {
 	response := httptest.NewRecorder()
 	mux := http.NewServeMux()
 	mux.HandleFunc("/update", updateUser)
-	mux.ServeHTTP(response, request)
+	mux.ServeHTTP(response, withClaims(request, jwt.MapClaims{"id": float64(testUser.ID), "role": "user"}))
 
 	if response.Code != http.StatusOK {
 		t.Errorf("Incorrect status code. Expected: %d, Got: %d", http.StatusOK, response.Code)
@@ -185,3 +208,301 @@ func TestDeleteUser(t *testing.T) {
 		t.Errorf("User was not deleted from database. User ID: %d", testUser.ID)
 	}
 }
+
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, msg *mailer.Message) error {
+	return errors.New("smtp unavailable")
+}
+
+func TestOutboxDeadLettersAfterMaxAttempts(t *testing.T) {
+	initLogger()
+	InitDB()
+	defer Db.Exec("DELETE FROM outbox_messages")
+	mailSender = failingMailer{}
+	defer func() { mailSender = &mailer.Memory{} }()
+
+	if err := enqueueEmail(Db, &mailer.Message{From: "noreply@example.com", To: []string{"learner@example.com"}, Subject: "Hi"}); err != nil {
+		t.Fatalf("Failed to enqueue email: %v", err)
+	}
+
+	var entry OutboxMessage
+	for i := 0; i < outboxMaxAttempts; i++ {
+		if _, err := processOutbox(context.Background()); err != nil {
+			t.Fatalf("Failed to process outbox: %v", err)
+		}
+		Db.Model(&OutboxMessage{}).Where("status = ?", outboxPending).Update("next_attempt_at", time.Now().Add(-time.Second))
+	}
+
+	if err := Db.First(&entry).Error; err != nil {
+		t.Fatalf("Failed to load outbox message: %v", err)
+	}
+	if entry.Status != outboxDead || entry.Attempts != outboxMaxAttempts || entry.LastError == "" {
+		t.Errorf("Expected dead message after %d attempts, got status=%s attempts=%d", outboxMaxAttempts, entry.Status, entry.Attempts)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /outbox/{id}/retry", retryOutboxMessage)
+	for _, expected := range []int{http.StatusOK, http.StatusConflict} {
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, httptest.NewRequest("POST", fmt.Sprintf("/outbox/%d/retry", entry.ID), nil))
+		if response.Code != expected {
+			t.Errorf("Expected retry to answer %d, got %d", expected, response.Code)
+		}
+	}
+	Db.First(&entry, entry.ID)
+	if entry.Status != outboxPending || entry.Attempts != 0 {
+		t.Errorf("Expected the retried message pending again, got status=%s attempts=%d", entry.Status, entry.Attempts)
+	}
+}
+
+func TestOutboxClaimLeasesMessages(t *testing.T) {
+	initLogger()
+	InitDB()
+	defer Db.Exec("DELETE FROM outbox_messages")
+
+	if err := enqueueEmail(Db, &mailer.Message{From: "noreply@example.com", To: []string{"learner@example.com"}, Subject: "Hi"}); err != nil {
+		t.Fatalf("Failed to enqueue email: %v", err)
+	}
+
+	claimed, err := claimOutbox(context.Background())
+	if err != nil || len(claimed) != 1 || claimed[0].Attempts != 1 {
+		t.Fatalf("Expected one claimed message on its first attempt, got %+v: %v", claimed, err)
+	}
+	if again, err := claimOutbox(context.Background()); err != nil || len(again) != 0 {
+		t.Errorf("Expected a leased message not to be claimed twice, got %+v: %v", again, err)
+	}
+
+	if err := recordOutboxResult(context.Background(), &claimed[0], nil); err != nil {
+		t.Fatalf("Failed to record result: %v", err)
+	}
+	var entry OutboxMessage
+	Db.First(&entry, claimed[0].ID)
+	if entry.Status != outboxSent || entry.SentAt == nil || entry.Attempts != 1 {
+		t.Errorf("Expected the message marked sent, got %+v", entry)
+	}
+}
+
+func TestTicketLifecycle(t *testing.T) {
+	initLogger()
+	InitDB()
+	defer Db.Exec("DELETE FROM ticket_attachments")
+	defer Db.Exec("DELETE FROM ticket_messages")
+	defer Db.Exec("DELETE FROM tickets")
+	defer Db.Exec("DELETE FROM outbox_messages")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tickets", createTicket)
+	mux.HandleFunc("POST /tickets/{id}/messages", addTicketMessage)
+	as := func(r *http.Request, id uint, role string) *http.Request {
+		return withClaims(r, jwt.MapClaims{"id": float64(id), "role": role})
+	}
+
+	form := url.Values{"name": {"Learner"}, "email": {"learner@example.com"}, "message": {"I cannot open lesson 3"}}
+	request := httptest.NewRequest("POST", "/tickets", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response := httptest.NewRecorder()
+	mux.ServeHTTP(response, as(request, 42, "user"))
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", response.Code, response.Body.String())
+	}
+	var created ticketResponse
+	json.Unmarshal(response.Body.Bytes(), &created)
+	if created.Status != ticketOpen || created.Priority != "normal" || created.Subject != "I cannot open lesson 3" {
+		t.Errorf("Unexpected ticket: %+v", created.Ticket)
+	}
+
+	reply := url.Values{"body": {"Please try reloading the page"}}
+	request = httptest.NewRequest("POST", fmt.Sprintf("/tickets/%d/messages", created.ID), strings.NewReader(reply.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response = httptest.NewRecorder()
+	mux.ServeHTTP(response, as(request, 7, "agent"))
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var ticket Ticket
+	Db.First(&ticket, created.ID)
+	if ticket.Status != ticketPending || ticket.FirstRespondedAt == nil {
+		t.Errorf("Agent reply did not update ticket: %+v", ticket)
+	}
+	var queued int64
+	Db.Model(&OutboxMessage{}).Count(&queued)
+	if queued != 2 {
+		t.Errorf("Expected support notification and reply emails in the outbox, got %d", queued)
+	}
+}
+
+func TestInboundEmailAppendsReply(t *testing.T) {
+	initLogger()
+	InitDB()
+	defer Db.Exec("DELETE FROM ticket_messages")
+	defer Db.Exec("DELETE FROM tickets")
+
+	ticket := Ticket{UserID: 42, Name: "Learner", Email: "learner@example.com", Subject: "Lesson 3", Status: ticketPending, Priority: "normal", ReplyToken: newReplyToken(), CreatedAt: time.Now()}
+	ticket.applySLA()
+	if err := Db.Create(&ticket).Error; err != nil {
+		t.Fatalf("Failed to create ticket: %v", err)
+	}
+
+	raw := "From: learner@example.com\r\n" +
+		"To: " + replyAddress(ticket.ReplyToken) + "\r\n" +
+		"Message-ID: <reply-1@example.com>\r\n" +
+		"Subject: Re: Lesson 3\r\n\r\n" +
+		"It works now, thanks!\r\n\r\n> Please try reloading the page\r\n"
+
+	for i := 0; i < 2; i++ {
+		if _, err := processInboundEmail(context.Background(), strings.NewReader(raw)); err != nil {
+			t.Fatalf("processInboundEmail failed: %v", err)
+		}
+	}
+
+	var messages []TicketMessage
+	Db.Where("ticket_id = ?", ticket.ID).Find(&messages)
+	if len(messages) != 1 || messages[0].Body != "It works now, thanks!" {
+		t.Errorf("Expected one stripped reply, got %+v", messages)
+	}
+	Db.First(&ticket, ticket.ID)
+	if ticket.Status != ticketOpen {
+		t.Errorf("Expected ticket to reopen, got %s", ticket.Status)
+	}
+	if messages[0].NeedsReview || messages[0].AuthorID == nil || *messages[0].AuthorID != ticket.UserID {
+		t.Errorf("Expected the reply attributed to the customer, got %+v", messages[0])
+	}
+
+	spoofed := strings.Replace(strings.Replace(raw, "learner@example.com", "stranger@example.com", 1), "reply-1@", "reply-2@", 1)
+	message, err := processInboundEmail(context.Background(), strings.NewReader(spoofed))
+	if err != nil {
+		t.Fatalf("processInboundEmail failed: %v", err)
+	}
+	if !message.NeedsReview || message.SenderEmail != "stranger@example.com" || message.AuthorID != nil {
+		t.Errorf("Expected the stranger's reply flagged for review, got %+v", message)
+	}
+}
+
+func TestReviewLifecycle(t *testing.T) {
+	initLogger()
+	InitDB()
+	defer Db.Exec("DELETE FROM users")
+	defer Db.Exec("DELETE FROM products")
+	defer Db.Exec("DELETE FROM orders")
+	defer Db.Exec("DELETE FROM order_items")
+	defer Db.Exec("DELETE FROM enrollments")
+	defer Db.Exec("DELETE FROM reviews")
+	defer Db.Exec("DELETE FROM review_votes")
+
+	users := []User{
+		{Name: "Enrolled", Email: "enrolled@example.com", Role: "user"},
+		{Name: "Buyer", Email: "buyer@example.com", Role: "user"},
+		{Name: "Stranger", Email: "stranger@example.com", Role: "user"},
+		{Name: "Admin", Email: "admin@example.com", Role: "admin"},
+	}
+	if err := Db.Create(&users).Error; err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+	enrolled, buyer, stranger, admin := users[0], users[1], users[2], users[3]
+
+	product := Product{Name: "Spanish A1", PriceAmount: 1000, Currency: "USD", Date: time.Now()}
+	if err := Db.Create(&product).Error; err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	enrolledOrder := Order{UserID: enrolled.ID, Status: orderPaid, Currency: "USD"}
+	productID := product.ID
+	paidOrder := Order{UserID: buyer.ID, Status: orderPaid, Currency: "USD",
+		Items: []OrderItem{{ProductID: &productID, ProductName: product.Name, UnitAmount: 1000, Quantity: 1}}}
+	if err := Db.Create(&[]*Order{&enrolledOrder, &paidOrder}).Error; err != nil {
+		t.Fatalf("Failed to create orders: %v", err)
+	}
+	if err := Db.Create(&Enrollment{UserID: enrolled.ID, ProductID: product.ID, OrderID: enrolledOrder.ID}).Error; err != nil {
+		t.Fatalf("Failed to create enrollment: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /products/{id}/reviews", createReview)
+	mux.HandleFunc("PUT /reviews/{id}", updateReview)
+	mux.HandleFunc("DELETE /reviews/{id}", deleteReview)
+	mux.HandleFunc("POST /reviews/{id}/helpful", voteReview)
+	mux.HandleFunc("PATCH /admin/reviews/{id}", moderateReview)
+	send := func(method, path string, body interface{}, user User) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		request := httptest.NewRequest(method, path, bytes.NewReader(data))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, withClaims(request, jwt.MapClaims{"id": float64(user.ID), "role": user.Role}))
+		return response
+	}
+	rating := func() Product {
+		var p Product
+		Db.First(&p, product.ID)
+		return p
+	}
+	reviewsPath := fmt.Sprintf("/products/%d/reviews", product.ID)
+
+	if response := send("POST", reviewsPath, reviewRequest{Rating: 5}, stranger); response.Code != http.StatusForbidden {
+		t.Errorf("Expected a learner without a purchase to get 403, got %d", response.Code)
+	}
+
+	var first, second Review
+	response := send("POST", reviewsPath, reviewRequest{Rating: 5, Title: "Great"}, enrolled)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected an enrolled learner to review, got %d: %s", response.Code, response.Body.String())
+	}
+	json.Unmarshal(response.Body.Bytes(), &first)
+	if response := send("POST", reviewsPath, reviewRequest{Rating: 1}, enrolled); response.Code != http.StatusConflict {
+		t.Errorf("Expected a second review to get 409, got %d", response.Code)
+	}
+	response = send("POST", reviewsPath, reviewRequest{Rating: 2}, buyer)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected a buyer with a paid order to review, got %d: %s", response.Code, response.Body.String())
+	}
+	json.Unmarshal(response.Body.Bytes(), &second)
+	if p := rating(); p.RatingCount != 0 {
+		t.Errorf("Expected pending reviews not to count, got %+v", p)
+	}
+
+	approve := map[string]string{"status": reviewApproved}
+	send("PATCH", fmt.Sprintf("/admin/reviews/%d", first.ID), approve, admin)
+	send("PATCH", fmt.Sprintf("/admin/reviews/%d", second.ID), approve, admin)
+	if p := rating(); p.RatingAverage != 3.5 || p.RatingCount != 2 || fmt.Sprint(p.RatingDistribution) != "[0 1 0 0 1]" {
+		t.Errorf("Expected the approved reviews counted, got %v %d %v", p.RatingAverage, p.RatingCount, p.RatingDistribution)
+	}
+
+	helpful := fmt.Sprintf("/reviews/%d/helpful", first.ID)
+	for _, voter := range []User{buyer, buyer, stranger} {
+		if response := send("POST", helpful, nil, voter); response.Code != http.StatusOK {
+			t.Fatalf("Vote failed with %d: %s", response.Code, response.Body.String())
+		}
+	}
+	if response := send("POST", helpful, nil, enrolled); response.Code != http.StatusForbidden {
+		t.Errorf("Expected authors not to vote for their own review, got %d", response.Code)
+	}
+	var votes int64
+	Db.Model(&ReviewVote{}).Where("review_id = ?", first.ID).Count(&votes)
+	Db.First(&first, first.ID)
+	if votes != 2 || first.HelpfulCount != 2 {
+		t.Errorf("Expected one vote per user, got %d votes and helpful_count %d", votes, first.HelpfulCount)
+	}
+
+	send("PATCH", fmt.Sprintf("/admin/reviews/%d", second.ID), map[string]string{"status": reviewHidden}, admin)
+	if p := rating(); p.RatingAverage != 5 || p.RatingCount != 1 {
+		t.Errorf("Expected hiding to drop the review from the rating, got %v %d", p.RatingAverage, p.RatingCount)
+	}
+
+	if response := send("PUT", fmt.Sprintf("/reviews/%d", first.ID), reviewRequest{Rating: 4}, enrolled); response.Code != http.StatusOK {
+		t.Fatalf("Edit failed with %d: %s", response.Code, response.Body.String())
+	}
+	if p := rating(); p.RatingCount != 0 {
+		t.Errorf("Expected an edited review to leave the rating until approved, got %+v", p)
+	}
+	send("PATCH", fmt.Sprintf("/admin/reviews/%d", first.ID), approve, admin)
+	if p := rating(); p.RatingAverage != 4 || p.RatingCount != 1 {
+		t.Errorf("Expected the approved edit counted, got %v %d", p.RatingAverage, p.RatingCount)
+	}
+
+	if response := send("DELETE", fmt.Sprintf("/reviews/%d", first.ID), nil, enrolled); response.Code != http.StatusNoContent {
+		t.Fatalf("Delete failed with %d", response.Code)
+	}
+	if p := rating(); p.RatingAverage != 0 || p.RatingCount != 0 {
+		t.Errorf("Expected deleting the last approved review to clear the rating, got %v %d", p.RatingAverage, p.RatingCount)
+	}
+}
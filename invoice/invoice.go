@@ -0,0 +1,240 @@
+// Package invoice renders invoices and receipts as PDF documents. Text is
+// set in the embedded DejaVu Sans Condensed fonts, so Cyrillic product and
+// customer names render correctly.
+package invoice
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"LanguageLearningPlatform/money"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+var (
+	//go:embed fonts/DejaVuSansCondensed.ttf
+	regularFont []byte
+	//go:embed fonts/DejaVuSansCondensed-Bold.ttf
+	boldFont []byte
+)
+
+type Party struct {
+	Name    string
+	Address string
+	Email   string
+	TaxID   string
+}
+
+type Line struct {
+	Description string
+	Quantity    int
+	UnitAmount  int64
+	// DiscountAmount applies to the whole line.
+	DiscountAmount int64
+}
+
+func (l Line) Total() int64 {
+	return l.UnitAmount*int64(l.Quantity) - l.DiscountAmount
+}
+
+// Document holds everything printed on an invoice. Amounts are in minor
+// units of Currency. A document with PaidAt set is rendered as a receipt.
+type Document struct {
+	Number    string
+	IssuedAt  time.Time
+	OrderRef  string
+	Seller    Party
+	Buyer     Party
+	Currency  string
+	Lines     []Line
+	Coupon    string
+	PaidAt    *time.Time
+	PaymentID string
+	Locale    string
+}
+
+func (d *Document) Subtotal() int64 {
+	var total int64
+	for _, l := range d.Lines {
+		total += l.UnitAmount * int64(l.Quantity)
+	}
+	return total
+}
+
+func (d *Document) Discount() int64 {
+	var total int64
+	for _, l := range d.Lines {
+		total += l.DiscountAmount
+	}
+	return total
+}
+
+func (d *Document) Total() int64 {
+	return d.Subtotal() - d.Discount()
+}
+
+type labels struct {
+	invoice, receipt, number, date, order, seller, billTo, taxID                       string
+	description, quantity, unitPrice, discount, amount, subtotal, total, paid, payment string
+	dateLayout                                                                         string
+}
+
+var translations = map[string]labels{
+	"en": {
+		invoice: "INVOICE", receipt: "RECEIPT", number: "Number", date: "Date", order: "Order",
+		seller: "From", billTo: "Bill to", taxID: "Tax ID",
+		description: "Description", quantity: "Qty", unitPrice: "Unit price", discount: "Discount", amount: "Amount",
+		subtotal: "Subtotal", total: "Total", paid: "Paid on %s", payment: "Payment reference",
+		dateLayout: "January 2, 2006",
+	},
+	"ru": {
+		invoice: "СЧЁТ", receipt: "КВИТАНЦИЯ", number: "Номер", date: "Дата", order: "Заказ",
+		seller: "Продавец", billTo: "Покупатель", taxID: "ИНН",
+		description: "Наименование", quantity: "Кол-во", unitPrice: "Цена", discount: "Скидка", amount: "Сумма",
+		subtotal: "Итого без скидки", total: "Итого", paid: "Оплачено %s", payment: "Номер платежа",
+		dateLayout: "02.01.2006",
+	},
+}
+
+// Render writes d as a PDF to w.
+func Render(d *Document, w io.Writer) error {
+	t, ok := translations[d.Locale]
+	if !ok {
+		t = translations["en"]
+	}
+	amount := func(v int64) string {
+		return money.String(v, d.Currency) + " " + d.Currency
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes("DejaVu", "", regularFont)
+	pdf.AddUTF8FontFromBytes("DejaVu", "B", boldFont)
+	pdf.SetTitle(d.Number, true)
+	pdf.SetCreator("Language Learning Platform", true)
+	pdf.SetCreationDate(d.IssuedAt)
+	pdf.SetMargins(20, 20, 20)
+	pdf.AddPage()
+
+	title := t.invoice
+	if d.PaidAt != nil {
+		title = t.receipt
+	}
+	pdf.SetFont("DejaVu", "B", 20)
+	pdf.CellFormat(0, 10, title, "", 1, "L", false, 0, "")
+	pdf.SetFont("DejaVu", "", 10)
+	pdf.CellFormat(0, 6, fmt.Sprintf("%s: %s", t.number, d.Number), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, fmt.Sprintf("%s: %s", t.date, d.IssuedAt.Format(t.dateLayout)), "", 1, "L", false, 0, "")
+	if d.OrderRef != "" {
+		pdf.CellFormat(0, 6, fmt.Sprintf("%s: %s", t.order, d.OrderRef), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	top := pdf.GetY()
+	party := func(x float64, heading string, p Party) {
+		pdf.SetXY(x, top)
+		pdf.SetFont("DejaVu", "B", 10)
+		pdf.CellFormat(80, 6, heading, "", 2, "L", false, 0, "")
+		pdf.SetFont("DejaVu", "", 10)
+		for _, line := range []string{p.Name, p.Address, p.Email} {
+			if line != "" {
+				pdf.MultiCell(80, 5, line, "", "L", false)
+				pdf.SetX(x)
+			}
+		}
+		if p.TaxID != "" {
+			pdf.CellFormat(80, 5, fmt.Sprintf("%s: %s", t.taxID, p.TaxID), "", 2, "L", false, 0, "")
+		}
+	}
+	party(20, t.seller, d.Seller)
+	sellerBottom := pdf.GetY()
+	party(110, t.billTo, d.Buyer)
+	pdf.SetXY(20, max(sellerBottom, pdf.GetY())+8)
+
+	widths := []float64{80, 15, 25, 25, 25}
+	pdf.SetFont("DejaVu", "B", 10)
+	pdf.SetFillColor(235, 240, 248)
+	for i, heading := range []string{t.description, t.quantity, t.unitPrice, t.discount, t.amount} {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		pdf.CellFormat(widths[i], 8, heading, "B", 0, align, true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("DejaVu", "", 10)
+	for _, l := range d.Lines {
+		discount := ""
+		if l.DiscountAmount > 0 {
+			discount = "-" + amount(l.DiscountAmount)
+		}
+		// Long descriptions wrap; the numeric cells share the first line.
+		lines := pdf.SplitText(l.Description, widths[0]-2)
+		if len(lines) == 0 {
+			lines = []string{""}
+		}
+		for i, text := range lines {
+			cells := []string{text, "", "", "", ""}
+			if i == 0 {
+				cells = []string{text, strconv.Itoa(l.Quantity), amount(l.UnitAmount), discount, amount(l.Total())}
+			}
+			for j, cell := range cells {
+				align := "R"
+				if j == 0 {
+					align = "L"
+				}
+				pdf.CellFormat(widths[j], 6, cell, "", 0, align, false, 0, "")
+			}
+			pdf.Ln(-1)
+		}
+	}
+	pdf.Line(20, pdf.GetY()+1, 190, pdf.GetY()+1)
+	pdf.Ln(4)
+
+	summary := func(label, value string, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		pdf.SetFont("DejaVu", style, 10)
+		pdf.CellFormat(145, 6, label, "", 0, "R", false, 0, "")
+		pdf.CellFormat(25, 6, value, "", 1, "R", false, 0, "")
+	}
+	if d.Discount() > 0 {
+		summary(t.subtotal, amount(d.Subtotal()), false)
+		label := t.discount
+		if d.Coupon != "" {
+			label += " (" + d.Coupon + ")"
+		}
+		summary(label, "-"+amount(d.Discount()), false)
+	}
+	summary(t.total, amount(d.Total()), true)
+
+	if d.PaidAt != nil {
+		pdf.Ln(8)
+		pdf.SetFont("DejaVu", "B", 12)
+		pdf.SetTextColor(30, 120, 60)
+		pdf.CellFormat(0, 8, fmt.Sprintf(t.paid, d.PaidAt.Format(t.dateLayout)), "", 1, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+		if d.PaymentID != "" {
+			pdf.SetFont("DejaVu", "", 9)
+			pdf.CellFormat(0, 5, fmt.Sprintf("%s: %s", t.payment, d.PaymentID), "", 1, "L", false, 0, "")
+		}
+	}
+
+	return pdf.Output(w)
+}
+
+// Bytes renders d into memory.
+func Bytes(d *Document) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Render(d, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
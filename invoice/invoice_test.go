@@ -0,0 +1,43 @@
+package invoice
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDocumentTotals(t *testing.T) {
+	d := &Document{Lines: []Line{
+		{Quantity: 2, UnitAmount: 1500, DiscountAmount: 300},
+		{Quantity: 1, UnitAmount: 1000},
+	}}
+	if d.Subtotal() != 4000 || d.Discount() != 300 || d.Total() != 3700 {
+		t.Errorf("Unexpected totals %d, %d, %d", d.Subtotal(), d.Discount(), d.Total())
+	}
+}
+
+func TestRenderProducesPDF(t *testing.T) {
+	paid := time.Date(2026, 3, 5, 12, 0, 0, 0, time.UTC)
+	for _, locale := range []string{"en", "ru", "xx"} {
+		d := &Document{
+			Number:   "INV-2026-000001",
+			IssuedAt: paid,
+			OrderRef: "#42",
+			Seller:   Party{Name: "Language Learning Platform", Address: "1 Main St", TaxID: "123456789"},
+			Buyer:    Party{Name: "Школа №5", Email: "school@example.com"},
+			Currency: "RUB",
+			Lines:    []Line{{Description: strings.Repeat("Испанский для начинающих ", 8), Quantity: 3, UnitAmount: 150000, DiscountAmount: 45000}},
+			Coupon:   "SCHOOL10",
+			PaidAt:   &paid,
+			Locale:   locale,
+		}
+		data, err := Bytes(d)
+		if err != nil {
+			t.Fatalf("%s: Render failed: %v", locale, err)
+		}
+		if !bytes.HasPrefix(data, []byte("%PDF-")) || !bytes.Contains(data, []byte("%%EOF")) {
+			t.Errorf("%s: output is not a PDF document", locale)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"LanguageLearningPlatform/invoice"
+	"LanguageLearningPlatform/mailer"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const invoiceContentType = "application/pdf"
+
+// Invoice is the receipt issued when an order is paid. Numbers are
+// allocated in the payment transaction, so they are gapless per year; the
+// PDF is rendered, stored and emailed afterwards by runInvoiceWorker, or on
+// the first download if that comes sooner.
+type Invoice struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Number     string     `json:"number" gorm:"uniqueIndex;not null"`
+	OrderID    uint       `json:"order_id" gorm:"uniqueIndex;not null"`
+	UserID     uint       `json:"user_id" gorm:"index;not null"`
+	IssuedAt   time.Time  `json:"issued_at"`
+	StorageKey string     `json:"-"`
+	StoredAt   *time.Time `json:"stored_at" gorm:"index"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// InvoiceCounter holds the last invoice number issued in a year.
+type InvoiceCounter struct {
+	Year int `gorm:"primaryKey;autoIncrement:false"`
+	Last int `gorm:"not null"`
+}
+
+func invoicePrefix() string {
+	return getenvDefault("INVOICE_PREFIX", "INV")
+}
+
+func invoiceSeller() invoice.Party {
+	return invoice.Party{
+		Name:    getenvDefault("INVOICE_SELLER_NAME", "Language Learning Platform"),
+		Address: os.Getenv("INVOICE_SELLER_ADDRESS"),
+		Email:   getenvDefault("INVOICE_SELLER_EMAIL", mailFrom),
+		TaxID:   os.Getenv("INVOICE_SELLER_TAX_ID"),
+	}
+}
+
+func formatInvoiceNumber(prefix string, year, n int) string {
+	return fmt.Sprintf("%s-%d-%06d", prefix, year, n)
+}
+
+// issueInvoice numbers the invoice for a paid order. The counter row stays
+// locked until tx commits, so concurrent payments get consecutive numbers
+// and a rolled back payment leaves no gap.
+func issueInvoice(tx *gorm.DB, order *Order) error {
+	now := time.Now()
+	counter := InvoiceCounter{Year: now.Year(), Last: 1}
+	err := tx.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "year"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"last": gorm.Expr("invoice_counters.last + 1")}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "last"}}},
+	).Create(&counter).Error
+	if err != nil {
+		return err
+	}
+
+	inv := Invoice{
+		Number:   formatInvoiceNumber(invoicePrefix(), counter.Year, counter.Last),
+		OrderID:  order.ID,
+		UserID:   order.UserID,
+		IssuedAt: now,
+	}
+	return tx.Create(&inv).Error
+}
+
+// invoiceDocument describes inv for rendering.
+func invoiceDocument(inv *Invoice, order *Order, buyer *User, paymentID string) *invoice.Document {
+	doc := &invoice.Document{
+		Number:    inv.Number,
+		IssuedAt:  inv.IssuedAt,
+		OrderRef:  fmt.Sprintf("#%d", order.ID),
+		Seller:    invoiceSeller(),
+		Buyer:     invoice.Party{Name: buyer.Name, Email: buyer.Email},
+		Currency:  order.Currency,
+		Coupon:    order.CouponCode,
+		PaidAt:    order.PaidAt,
+		PaymentID: paymentID,
+		Locale:    buyer.Locale,
+	}
+	for _, item := range order.Items {
+		doc.Lines = append(doc.Lines, invoice.Line{
+			Description:    item.ProductName,
+			Quantity:       item.Quantity,
+			UnitAmount:     item.UnitAmount,
+			DiscountAmount: item.DiscountAmount,
+		})
+	}
+	return doc
+}
+
+// storeInvoice renders, stores and emails invoice id unless that has been
+// done already.
+func storeInvoice(ctx context.Context, id uint) (*Invoice, error) {
+	var inv Invoice
+	err := Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&inv, id).Error; err != nil {
+			return err
+		}
+		if inv.StoredAt != nil {
+			return nil
+		}
+
+		var order Order
+		if err := tx.Preload("Items").First(&order, inv.OrderID).Error; err != nil {
+			return fmt.Errorf("error retrieving order: %v", err)
+		}
+		var buyer User
+		if err := tx.First(&buyer, inv.UserID).Error; err != nil {
+			return fmt.Errorf("error retrieving user: %v", err)
+		}
+		var payment Payment
+		err := tx.Where("order_id = ? AND status IN ?", order.ID, []string{paymentSucceeded, paymentRefunded}).
+			Order("id DESC").Limit(1).Find(&payment).Error
+		if err != nil {
+			return fmt.Errorf("error retrieving payment: %v", err)
+		}
+
+		pdf, err := invoice.Bytes(invoiceDocument(&inv, &order, &buyer, payment.PaymentID))
+		if err != nil {
+			return fmt.Errorf("error rendering invoice: %v", err)
+		}
+		key := fmt.Sprintf("invoices/%d/%s.pdf", inv.IssuedAt.Year(), inv.Number)
+		if err := blobs.Store.Put(ctx, key, bytes.NewReader(pdf), int64(len(pdf)), invoiceContentType); err != nil {
+			return fmt.Errorf("error storing invoice: %v", err)
+		}
+
+		msg, err := renderEmail(ctx, "invoice", buyer.Locale, map[string]interface{}{
+			"Name":      buyer.Name,
+			"Number":    inv.Number,
+			"OrderID":   order.ID,
+			"Total":     newPriceView(order.TotalAmount, order.Currency, buyer.Locale).Formatted,
+			"OrdersURL": appBaseURL + "/profilePage",
+		})
+		if err != nil {
+			return fmt.Errorf("error rendering invoice email: %v", err)
+		}
+		msg.To = []string{buyer.Email}
+		msg.Attachments = []mailer.Attachment{{Filename: inv.Number + ".pdf", ContentType: invoiceContentType, Data: pdf}}
+		if err := enqueueEmail(tx, msg); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		inv.StorageKey = key
+		inv.StoredAt = &now
+		return tx.Save(&inv).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func runInvoiceWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := processInvoices(ctx); err != nil {
+			logger.WithError(err).Error("Invoice processing failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processInvoices stores one batch of invoices still lacking a PDF.
+func processInvoices(ctx context.Context) (int, error) {
+	var ids []uint
+	if err := Db.WithContext(ctx).Model(&Invoice{}).Where("stored_at IS NULL").Order("id").Limit(20).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	processed := 0
+	for _, id := range ids {
+		if _, err := storeInvoice(ctx, id); err != nil {
+			logger.WithError(err).WithField("invoice_id", id).Error("Failed to store invoice")
+			continue
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+// getOrderInvoice downloads the invoice of an order; admins may download
+// any.
+func getOrderInvoice(w http.ResponseWriter, r *http.Request) {
+	order, ok := loadOrder(w, r, "getOrderInvoice")
+	if !ok {
+		return
+	}
+
+	var inv Invoice
+	err := Db.WithContext(r.Context()).Where("order_id = ?", order.ID).First(&inv).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		handleError(w, r, "getOrderInvoice", notFound("Invoices are issued once an order is paid", err))
+		return
+	}
+	if err != nil {
+		handleError(w, r, "getOrderInvoice", internalError(fmt.Errorf("error retrieving invoice: %v", err)))
+		return
+	}
+	if inv.StoredAt == nil {
+		stored, err := storeInvoice(r.Context(), inv.ID)
+		if err != nil {
+			handleError(w, r, "getOrderInvoice", internalError(err))
+			return
+		}
+		inv = *stored
+	}
+
+	serveBlob(w, r, "getOrderInvoice", inv.StorageKey, inv.Number+".pdf", invoiceContentType)
+}
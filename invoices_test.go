@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatInvoiceNumber(t *testing.T) {
+	if got := formatInvoiceNumber("INV", 2026, 42); got != "INV-2026-000042" {
+		t.Errorf("Unexpected invoice number %q", got)
+	}
+	if got := formatInvoiceNumber("LLP", 2026, 1234567); got != "LLP-2026-1234567" {
+		t.Errorf("Expected numbers past the padding to keep all digits, got %q", got)
+	}
+}
+
+func TestInvoiceDocumentFromOrder(t *testing.T) {
+	t.Setenv("INVOICE_SELLER_NAME", "Acme Languages")
+	t.Setenv("INVOICE_SELLER_TAX_ID", "7701234567")
+
+	paid := time.Date(2026, 2, 1, 9, 0, 0, 0, time.UTC)
+	productID := uint(3)
+	order := &Order{
+		ID: 17, Currency: "EUR", CouponCode: "SCHOOL10", PaidAt: &paid,
+		SubtotalAmount: 9000, DiscountAmount: 900, TotalAmount: 8100,
+		Items: []OrderItem{{ProductID: &productID, ProductName: "Spanish A1", UnitAmount: 4500, Quantity: 2, DiscountAmount: 900}},
+	}
+	inv := &Invoice{Number: "INV-2026-000007", IssuedAt: paid}
+	buyer := &User{Name: "School No. 5", Email: "school@example.com", Locale: "ru"}
+
+	doc := invoiceDocument(inv, order, buyer, "pi_123")
+	if doc.Number != inv.Number || doc.OrderRef != "#17" || doc.Locale != "ru" || doc.PaymentID != "pi_123" || doc.Coupon != "SCHOOL10" {
+		t.Errorf("Unexpected document %+v", doc)
+	}
+	if doc.Seller.Name != "Acme Languages" || doc.Seller.TaxID != "7701234567" || doc.Buyer.Email != buyer.Email {
+		t.Errorf("Unexpected parties %+v / %+v", doc.Seller, doc.Buyer)
+	}
+	if doc.Subtotal() != order.SubtotalAmount || doc.Discount() != order.DiscountAmount || doc.Total() != order.TotalAmount {
+		t.Errorf("Document totals %d/%d/%d do not match the order", doc.Subtotal(), doc.Discount(), doc.Total())
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Lesson is a unit of a course product. Premium lessons are readable by
+// users enrolled in the product and by subscribers whose plan includes
+// premium lessons.
+type Lesson struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ProductID uint      `json:"product_id" gorm:"index;not null"`
+	Product   *Product  `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Title     string    `json:"title" gorm:"not null"`
+	Body      string    `json:"body,omitempty" gorm:"type:text"`
+	Position  int       `json:"position"`
+	Premium   bool      `json:"premium"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type lessonRequest struct {
+	Title    string `json:"title" validate:"required,max=200"`
+	Body     string `json:"body" validate:"max=100000"`
+	Position int    `json:"position" validate:"min=0"`
+	Premium  bool   `json:"premium"`
+}
+
+func (req lessonRequest) apply(l *Lesson) {
+	l.Title = req.Title
+	l.Body = req.Body
+	l.Position = req.Position
+	l.Premium = req.Premium
+}
+
+// canReadLesson reports whether the user behind r may read l's body.
+func canReadLesson(r *http.Request, l *Lesson) (bool, error) {
+	if !l.Premium {
+		return true, nil
+	}
+	if ok, err := hasEntitlement(r, featurePremiumLessons); ok || err != nil {
+		return ok, err
+	}
+	userID, _ := requestUserID(r)
+	var enrolled int64
+	err := Db.WithContext(r.Context()).Model(&Enrollment{}).
+		Where("user_id = ? AND product_id = ? AND revoked_at IS NULL", userID, l.ProductID).
+		Count(&enrolled).Error
+	if err != nil {
+		return false, fmt.Errorf("error checking enrollment: %v", err)
+	}
+	return enrolled > 0, nil
+}
+
+// getProductLessons lists a product's lessons without their bodies.
+func getProductLessons(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "getProductLessons", invalidParameter(err.Error()))
+		return
+	}
+
+	var lessons []Lesson
+	err = Db.WithContext(r.Context()).Omit("body").Where("product_id = ?", id).
+		Order("position, id").Find(&lessons).Error
+	if err != nil {
+		handleError(w, r, "getProductLessons", internalError(fmt.Errorf("error retrieving lessons: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lessons)
+}
+
+// getPremiumLessons lists every premium lesson for subscribers.
+func getPremiumLessons(w http.ResponseWriter, r *http.Request) {
+	var lessons []Lesson
+	err := Db.WithContext(r.Context()).Omit("body").Where("premium = ?", true).
+		Order("product_id, position, id").Find(&lessons).Error
+	if err != nil {
+		handleError(w, r, "getPremiumLessons", internalError(fmt.Errorf("error retrieving lessons: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lessons)
+}
+
+func loadLesson(w http.ResponseWriter, r *http.Request, action string) (*Lesson, bool) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, action, invalidParameter(err.Error()))
+		return nil, false
+	}
+	var lesson Lesson
+	if err := Db.WithContext(r.Context()).First(&lesson, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, r, action, notFound("Lesson not found", err))
+		} else {
+			handleError(w, r, action, internalError(fmt.Errorf("error retrieving lesson: %v", err)))
+		}
+		return nil, false
+	}
+	return &lesson, true
+}
+
+func getLesson(w http.ResponseWriter, r *http.Request) {
+	lesson, ok := loadLesson(w, r, "getLesson")
+	if !ok {
+		return
+	}
+	allowed, err := canReadLesson(r, lesson)
+	if err != nil {
+		handleError(w, r, "getLesson", internalError(err))
+		return
+	}
+	if !allowed {
+		handleError(w, r, "getLesson", subscriptionRequired(featurePremiumLessons))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lesson)
+}
+
+func createLesson(w http.ResponseWriter, r *http.Request) {
+	productID, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "createLesson", invalidParameter(err.Error()))
+		return
+	}
+	var req lessonRequest
+	if !decodeAndValidate(w, r, "createLesson", &req) {
+		return
+	}
+	if err := Db.WithContext(r.Context()).Select("id").First(&Product{}, productID).Error; err != nil {
+		handleError(w, r, "createLesson", notFound("Product not found", err))
+		return
+	}
+
+	lesson := Lesson{ProductID: productID}
+	req.apply(&lesson)
+	if err := Db.WithContext(r.Context()).Create(&lesson).Error; err != nil {
+		handleError(w, r, "createLesson", internalError(fmt.Errorf("error creating lesson: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(lesson)
+	logUserAction(r.Context(), "createLesson", "success", map[string]interface{}{"lesson_id": lesson.ID, "product_id": productID})
+}
+
+func updateLesson(w http.ResponseWriter, r *http.Request) {
+	lesson, ok := loadLesson(w, r, "updateLesson")
+	if !ok {
+		return
+	}
+	var req lessonRequest
+	if !decodeAndValidate(w, r, "updateLesson", &req) {
+		return
+	}
+	req.apply(lesson)
+	if err := Db.WithContext(r.Context()).Save(lesson).Error; err != nil {
+		handleError(w, r, "updateLesson", internalError(fmt.Errorf("error updating lesson: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lesson)
+	logUserAction(r.Context(), "updateLesson", "success", map[string]interface{}{"lesson_id": lesson.ID})
+}
+
+func deleteLesson(w http.ResponseWriter, r *http.Request) {
+	lesson, ok := loadLesson(w, r, "deleteLesson")
+	if !ok {
+		return
+	}
+	if err := Db.WithContext(r.Context()).Delete(lesson).Error; err != nil {
+		handleError(w, r, "deleteLesson", internalError(fmt.Errorf("error deleting lesson: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logUserAction(r.Context(), "deleteLesson", "success", map[string]interface{}{"lesson_id": lesson.ID})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestInfoContextKey contextKey = "requestInfo"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestInfo is shared by pointer so that handlers deeper in the chain
+// (authMiddleware) can enrich what the access log reports.
+type requestInfo struct {
+	ID     string
+	UserID uint
+	entry  *logrus.Entry
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *statusRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
+
+func requestLogger(ctx context.Context) *logrus.Entry {
+	if info := requestInfoFrom(ctx); info != nil {
+		return info.entry
+	}
+	return logrus.NewEntry(logger)
+}
+
+func setRequestUser(ctx context.Context, userID uint) {
+	if info := requestInfoFrom(ctx); info != nil && userID != 0 {
+		info.UserID = userID
+		info.entry = info.entry.WithField("user_id", userID)
+	}
+}
+
+func requestLoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		fields := logrus.Fields{
+			"request_id": id,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			fields["trace_id"] = sc.TraceID().String()
+			fields["span_id"] = sc.SpanID().String()
+		}
+		info := &requestInfo{ID: id, entry: logger.WithFields(fields)}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoContextKey, info))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		entry := info.entry.WithFields(logrus.Fields{
+			"route":       r.Pattern,
+			"status":      rec.Status(),
+			"bytes":       rec.bytes,
+			"latency_ms":  float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr": r.RemoteAddr,
+			"user_agent":  r.UserAgent(),
+		})
+		switch {
+		case rec.Status() >= 500:
+			entry.Error("HTTP request")
+		case rec.Status() >= 400:
+			entry.Warn("HTTP request")
+		default:
+			entry.Info("HTTP request")
+		}
+	})
+}
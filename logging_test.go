@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRequestLoggingMiddlewareCorrelatesEntries(t *testing.T) {
+	var buf bytes.Buffer
+	logger = logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetOutput(&buf)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		setRequestUser(r.Context(), 42)
+		logUserAction(r.Context(), "getThing", "success", nil)
+		w.Write([]byte("hello"))
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/things/7", nil)
+	request.Header.Set("X-Request-ID", "abc-123")
+	response := httptest.NewRecorder()
+	requestLoggingMiddleware(mux).ServeHTTP(response, request)
+
+	if got := response.Header().Get("X-Request-ID"); got != "abc-123" {
+		t.Errorf("X-Request-ID was not propagated, got %q", got)
+	}
+
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to decode log line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 log entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry["request_id"] != "abc-123" || entry["user_id"] != float64(42) {
+			t.Errorf("Log entry is missing request context: %v", entry)
+		}
+	}
+
+	access := entries[1]
+	if access["status"] != float64(http.StatusOK) || access["bytes"] != float64(5) || access["route"] != "GET /things/{id}" {
+		t.Errorf("Unexpected access log entry: %v", access)
+	}
+}
+
+func TestRequestLoggingMiddlewareRejectsUnsafeRequestID(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(&bytes.Buffer{})
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("X-Request-ID", "bad id\nwith newline")
+	response := httptest.NewRecorder()
+	requestLoggingMiddleware(http.NotFoundHandler()).ServeHTTP(response, request)
+
+	if got := response.Header().Get("X-Request-ID"); got == "" || got == request.Header.Get("X-Request-ID") {
+		t.Errorf("Expected a freshly generated request ID, got %q", got)
+	}
+}
@@ -0,0 +1,36 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File writes every message as an .eml file into a maildir (tmp, new, cur),
+// so outgoing mail can be opened with any mail client during development.
+type File struct {
+	Dir string
+}
+
+func (f *File) Send(ctx context.Context, msg *Message) error {
+	data, err := Build(msg)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(f.Dir, sub), 0755); err != nil {
+			return fmt.Errorf("failed to create maildir: %v", err)
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s.%s.eml", time.Now().UnixNano(), randomToken(4), hostname)
+	tmp := filepath.Join(f.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+	return os.Rename(tmp, filepath.Join(f.Dir, "new", name))
+}
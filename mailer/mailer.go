@@ -0,0 +1,282 @@
+// Package mailer builds RFC 5322 messages and delivers them through
+// interchangeable backends: SMTP, a maildir of .eml files, or memory.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+type Message struct {
+	From        string            `json:"from"`
+	To          []string          `json:"to"`
+	Cc          []string          `json:"cc,omitempty"`
+	ReplyTo     string            `json:"reply_to,omitempty"`
+	Subject     string            `json:"subject"`
+	Text        string            `json:"text"`
+	HTML        string            `json:"html,omitempty"`
+	Inline      []Attachment      `json:"inline,omitempty"`
+	Attachments []Attachment      `json:"attachments,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	MessageID   string            `json:"message_id,omitempty"`
+	Date        time.Time         `json:"date,omitempty"`
+}
+
+func (m *Message) Recipients() []string {
+	recipients := make([]string, 0, len(m.To)+len(m.Cc))
+	for _, addr := range append(append([]string{}, m.To...), m.Cc...) {
+		if parsed, err := mail.ParseAddress(addr); err == nil {
+			recipients = append(recipients, parsed.Address)
+		}
+	}
+	return recipients
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func sanitizeHeader(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
+
+func formatAddressList(addrs []string) (string, error) {
+	formatted := make([]string, len(addrs))
+	for i, addr := range addrs {
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil {
+			return "", fmt.Errorf("invalid address %q: %v", addr, err)
+		}
+		formatted[i] = parsed.String()
+	}
+	return strings.Join(formatted, ", "), nil
+}
+
+func messageIDDomain(from string) string {
+	if parsed, err := mail.ParseAddress(from); err == nil {
+		if _, domain, ok := strings.Cut(parsed.Address, "@"); ok {
+			return domain
+		}
+	}
+	return "localhost"
+}
+
+// Build renders msg as RFC 5322 bytes. It fills in Date and MessageID on
+// msg when they are unset so callers can record them.
+func Build(msg *Message) ([]byte, error) {
+	if msg.From == "" {
+		return nil, errors.New("mailer: message has no sender")
+	}
+	if len(msg.Recipients()) == 0 {
+		return nil, errors.New("mailer: message has no recipients")
+	}
+	if msg.Date.IsZero() {
+		msg.Date = time.Now()
+	}
+	if msg.MessageID == "" {
+		msg.MessageID = fmt.Sprintf("<%s.%s@%s>", msg.Date.Format("20060102150405"), randomToken(8), messageIDDomain(msg.From))
+	}
+
+	from, err := formatAddressList([]string{msg.From})
+	if err != nil {
+		return nil, err
+	}
+	to, err := formatAddressList(msg.To)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeHeader := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, sanitizeHeader(value))
+	}
+	writeHeader("From", from)
+	writeHeader("To", to)
+	if len(msg.Cc) > 0 {
+		cc, err := formatAddressList(msg.Cc)
+		if err != nil {
+			return nil, err
+		}
+		writeHeader("Cc", cc)
+	}
+	if msg.ReplyTo != "" {
+		replyTo, err := formatAddressList([]string{msg.ReplyTo})
+		if err != nil {
+			return nil, err
+		}
+		writeHeader("Reply-To", replyTo)
+	}
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	writeHeader("Date", msg.Date.Format(time.RFC1123Z))
+	writeHeader("Message-ID", msg.MessageID)
+	writeHeader("MIME-Version", "1.0")
+
+	keys := make([]string, 0, len(msg.Headers))
+	for k := range msg.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeHeader(textproto.CanonicalMIMEHeaderKey(sanitizeHeader(k)), mime.QEncoding.Encode("utf-8", msg.Headers[k]))
+	}
+
+	body, err := buildBody(msg)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		if v := body.header.Get(key); v != "" {
+			writeHeader(key, v)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body.data)
+	return buf.Bytes(), nil
+}
+
+// entity is a MIME part: its own headers plus the already encoded body.
+type entity struct {
+	header textproto.MIMEHeader
+	data   []byte
+}
+
+// buildBody nests the parts as multipart/mixed(alternative(text,
+// related(html, inline...)), attachments...), dropping any level that
+// would only hold a single part.
+func buildBody(msg *Message) (entity, error) {
+	body, err := textEntity("text/plain", msg.Text)
+	if err != nil {
+		return entity{}, err
+	}
+
+	if msg.HTML != "" {
+		html, err := textEntity("text/html", msg.HTML)
+		if err != nil {
+			return entity{}, err
+		}
+		if len(msg.Inline) > 0 {
+			parts := []entity{html}
+			for _, a := range msg.Inline {
+				parts = append(parts, attachmentEntity(a, "inline"))
+			}
+			if html, err = multipartEntity("related", parts); err != nil {
+				return entity{}, err
+			}
+		}
+		if body, err = multipartEntity("alternative", []entity{body, html}); err != nil {
+			return entity{}, err
+		}
+	}
+
+	if len(msg.Attachments) > 0 {
+		parts := []entity{body}
+		for _, a := range msg.Attachments {
+			parts = append(parts, attachmentEntity(a, "attachment"))
+		}
+		return multipartEntity("mixed", parts)
+	}
+	return body, nil
+}
+
+func textEntity(mediaType, text string) (entity, error) {
+	var buf bytes.Buffer
+	if err := writeQuotedPrintable(&buf, text); err != nil {
+		return entity{}, err
+	}
+	return entity{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {mediaType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		data: buf.Bytes(),
+	}, nil
+}
+
+func attachmentEntity(a Attachment, disposition string) entity {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Disposition":       {mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename})},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	if a.ContentID != "" {
+		header.Set("Content-ID", "<"+sanitizeHeader(a.ContentID)+">")
+	}
+	var buf bytes.Buffer
+	writeBase64(&buf, a.Data)
+	return entity{header: header, data: buf.Bytes()}
+}
+
+func multipartEntity(subtype string, parts []entity) (entity, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		w, err := mw.CreatePart(p.header)
+		if err != nil {
+			return entity{}, err
+		}
+		if _, err := w.Write(p.data); err != nil {
+			return entity{}, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return entity{}, err
+	}
+	return entity{
+		header: textproto.MIMEHeader{
+			"Content-Type": {mime.FormatMediaType("multipart/"+subtype, map[string]string{"boundary": mw.Boundary()})},
+		},
+		data: buf.Bytes(),
+	}, nil
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := w.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := w.Write([]byte(encoded + "\r\n"))
+	return err
+}
@@ -0,0 +1,222 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testMessage() *Message {
+	return &Message{
+		From:    "Platform <noreply@example.com>",
+		To:      []string{"learner@example.com"},
+		Subject: "Подтверждение регистрации",
+		Text:    "Здравствуйте!\nПерейдите по ссылке.",
+	}
+}
+
+func TestBuildProducesRFC5322Headers(t *testing.T) {
+	msg := testMessage()
+	data, err := Build(msg)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to parse built message: %v", err)
+	}
+	if _, err := parsed.Header.Date(); err != nil {
+		t.Errorf("Invalid Date header: %v", err)
+	}
+	if parsed.Header.Get("Message-ID") != msg.MessageID || !strings.HasSuffix(msg.MessageID, "@example.com>") {
+		t.Errorf("Unexpected Message-ID %q", parsed.Header.Get("Message-ID"))
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("Subject was not encoded correctly: %q (%v)", subject, err)
+	}
+}
+
+func TestBuildWithAttachmentUsesRandomBoundary(t *testing.T) {
+	msg := testMessage()
+	msg.Attachments = []Attachment{{Filename: "notes.txt", ContentType: "text/plain", Data: []byte("hello")}}
+
+	first, _ := Build(msg)
+	msg.MessageID = ""
+	second, _ := Build(msg)
+
+	boundary := func(data []byte) string {
+		parsed, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to parse built message: %v", err)
+		}
+		_, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+		return params["boundary"]
+	}
+	if b := boundary(first); b == "" || b == boundary(second) {
+		t.Errorf("Expected distinct random boundaries, got %q", b)
+	}
+
+	parsed, _ := mail.ReadMessage(bytes.NewReader(first))
+	reader := multipart.NewReader(parsed.Body, boundary(first))
+	reader.NextPart()
+	part, err := reader.NextPart()
+	if err != nil {
+		t.Fatalf("Missing attachment part: %v", err)
+	}
+	if part.FileName() != "notes.txt" {
+		t.Errorf("Unexpected attachment filename %q", part.FileName())
+	}
+}
+
+func TestBuildNestsHTMLAlternativeWithInlineImages(t *testing.T) {
+	msg := testMessage()
+	msg.HTML = `<p>Здравствуйте!</p><img src="cid:logo@example.com">`
+	msg.Inline = []Attachment{{Filename: "logo.png", ContentType: "image/png", Data: []byte("png"), ContentID: "logo@example.com"}}
+
+	data, err := Build(msg)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	parsed, _ := mail.ReadMessage(bytes.NewReader(data))
+	mediaType, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %s", mediaType)
+	}
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	text, _ := reader.NextPart()
+	if ct := text.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected plaintext first, got %s", ct)
+	}
+	related, err := reader.NextPart()
+	if err != nil {
+		t.Fatalf("Missing related part: %v", err)
+	}
+	mediaType, params, _ = mime.ParseMediaType(related.Header.Get("Content-Type"))
+	if mediaType != "multipart/related" {
+		t.Fatalf("Expected multipart/related, got %s", mediaType)
+	}
+
+	inner := multipart.NewReader(related, params["boundary"])
+	inner.NextPart()
+	logo, err := inner.NextPart()
+	if err != nil {
+		t.Fatalf("Missing inline image: %v", err)
+	}
+	if logo.Header.Get("Content-ID") != "<logo@example.com>" {
+		t.Errorf("Unexpected Content-ID %q", logo.Header.Get("Content-ID"))
+	}
+}
+
+func TestBuildRejectsHeaderInjection(t *testing.T) {
+	msg := testMessage()
+	msg.Subject = "Hi\r\nBcc: victim@example.com"
+	data, err := Build(msg)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	parsed, _ := mail.ReadMessage(bytes.NewReader(data))
+	if parsed.Header.Get("Bcc") != "" {
+		t.Error("Header injection produced a Bcc header")
+	}
+}
+
+func TestFileWritesMaildirMessage(t *testing.T) {
+	dir := t.TempDir()
+	if err := (&File{Dir: dir}).Send(context.Background(), testMessage()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "new", "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one .eml file in new/, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if _, err := mail.ReadMessage(bytes.NewReader(data)); err != nil {
+		t.Errorf("Written file is not a valid message: %v", err)
+	}
+}
+
+func TestMemoryCapturesMessages(t *testing.T) {
+	m := &Memory{}
+	m.Send(context.Background(), testMessage())
+	if got := m.Messages(); len(got) != 1 || got[0].To[0] != "learner@example.com" {
+		t.Errorf("Unexpected captured messages: %+v", got)
+	}
+	m.Reset()
+	if len(m.Messages()) != 0 {
+		t.Error("Reset did not clear messages")
+	}
+}
+
+func TestSMTPDeliversToServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go serveFakeSMTP(t, ln, received)
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	sender := &SMTP{Host: host, Port: port, TLS: TLSNone}
+	if err := sender.Send(context.Background(), testMessage()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	data := <-received
+	if !strings.Contains(data, "To: <learner@example.com>") {
+		t.Errorf("Server received unexpected data:\n%s", data)
+	}
+}
+
+func serveFakeSMTP(t *testing.T, ln net.Listener, received chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			received <- data.String()
+			reply("250 queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
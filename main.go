@@ -1,37 +1,40 @@
 package main
 
 import (
-	"bytes"
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"mime/multipart"
 	"net/http"
-	"net/smtp"
 	"os"
-	"regexp"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"LanguageLearningPlatform/validation"
+
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/time/rate"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	gormtracing "gorm.io/plugin/opentelemetry/tracing"
 )
 
 type User struct {
 	ID               uint      `json:"id" gorm:"primaryKey"`
 	Name             string    `json:"name"`
-	Email            string    `json:"email"`
+	Email            string    `json:"email" gorm:"uniqueIndex"`
 	Password         string    `json:"password"`
 	Role             string    `json:"role"`
+	Locale           string    `json:"locale" gorm:"default:en"`
 	ConfirmationCode string    `json:"confirmation_code"`
 	Confirmed        bool      `json:"confirmed"`
 	CreatedAt        time.Time `json:"created_at"`
@@ -39,25 +42,42 @@ type User struct {
 }
 
 type Product struct {
-	ID              uint      `json:"id" gorm:"primaryKey"`
-	Name            string    `json:"name"`
-	Description     string    `json:"description"`
-	Price           float64   `json:"price"`
-	Characteristics string    `json:"characteristics"`
-	Date            time.Time `json:"date"`
-	Image           string    `json:"image"`
-}
+	ID              uint                   `json:"id" gorm:"primaryKey"`
+	Name            string                 `json:"name"`
+	Description     string                 `json:"description"`
+	PriceAmount     int64                  `json:"-"`
+	Currency        string                 `json:"-" gorm:"size:3"`
+	Prices          []ProductPrice         `json:"-"`
+	CategoryID      *uint                  `json:"category_id" gorm:"index"`
+	Characteristics map[string]interface{} `json:"characteristics" gorm:"type:jsonb;serializer:json"`
+	Date            time.Time              `json:"date"`
+	Image           string                 `json:"image"`
+	Thumbnails      map[string]string      `json:"thumbnails,omitempty" gorm:"type:jsonb;serializer:json"`
+	Slug            string                 `json:"slug" gorm:"index:idx_products_slug,unique,where:slug <> ''"`
+	SKU             string                 `json:"sku" gorm:"index:idx_products_sku,unique,where:sku <> ''"`
+	Tags            []Tag                  `json:"tags" gorm:"many2many:product_tags"`
+	// The rating fields summarise approved reviews and are maintained by
+	// refreshProductRating; RatingDistribution counts one to five stars.
+	RatingAverage      float64 `json:"rating_average" gorm:"not null;default:0"`
+	RatingCount        int     `json:"rating_count" gorm:"not null;default:0"`
+	RatingDistribution []int   `json:"rating_distribution" gorm:"type:jsonb;serializer:json;default:'[0,0,0,0,0]'"`
+}
+
+const logFilePath = "app.log"
 
 var (
 	jwtKey  []byte
 	Db      *gorm.DB
 	logger  *logrus.Logger
 	limiter = rate.NewLimiter(30, 60)
+	models  = []interface{}{&User{}, &Category{}, &Tag{}, &Product{}, &ProductPrice{}, &CartItem{}, &Order{}, &OrderItem{}, &Coupon{}, &CouponRedemption{}, &Invoice{}, &InvoiceCounter{}, &Payment{}, &PaymentEvent{}, &Enrollment{}, &Plan{}, &Subscription{}, &Lesson{}, &Review{}, &ReviewVote{}, &OutboxMessage{}, &EmailTemplate{}, &Ticket{}, &TicketMessage{}, &TicketAttachment{}}
+
+	rateLimitExempt = map[string]bool{"/healthz": true, "/readyz": true}
 )
 
 func initLogger() {
 
-	logFile, err := os.OpenFile("app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		fmt.Printf("can't open the file for logs: %v\n", err)
 		os.Exit(1)
@@ -71,8 +91,8 @@ func initLogger() {
 	logger.SetOutput(io.MultiWriter(os.Stdout, logFile))
 }
 
-func logUserAction(action, status string, details map[string]interface{}) {
-	logEntry := logger.WithFields(logrus.Fields{
+func logUserAction(ctx context.Context, action, status string, details map[string]interface{}) {
+	logEntry := requestLogger(ctx).WithFields(logrus.Fields{
 		"action":  action,
 		"status":  status,
 		"details": details,
@@ -88,18 +108,26 @@ func logUserAction(action, status string, details map[string]interface{}) {
 	}
 }
 
+type clientErrorReport struct {
+	Action  string                 `json:"action" validate:"max=100"`
+	Status  string                 `json:"status" validate:"max=50"`
+	Details map[string]interface{} `json:"details" validate:"required"`
+	Time    string                 `json:"time" validate:"max=64"`
+}
+
 func logClientError(w http.ResponseWriter, r *http.Request) {
-	var errorDetails map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&errorDetails); err != nil {
-		handleError(w, "logClientError", fmt.Errorf("invalid error details: %v", err), http.StatusBadRequest)
+	var report clientErrorReport
+	if !decodeAndValidate(w, r, "logClientError", &report) {
 		return
 	}
 
-	logEntry := logger.WithFields(logrus.Fields{
-		"action":  "logClientError",
-		"status":  "error",
-		"details": errorDetails,
-		"time":    time.Now(),
+	logEntry := requestLogger(r.Context()).WithFields(logrus.Fields{
+		"action":        "logClientError",
+		"status":        "error",
+		"client_action": report.Action,
+		"client_time":   report.Time,
+		"details":       report.Details,
+		"time":          time.Now(),
 	})
 
 	logEntry.Error("Client-side error logged")
@@ -109,12 +137,17 @@ func logClientError(w http.ResponseWriter, r *http.Request) {
 
 func rateLimiterMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimitExempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
 		if !limiter.Allow() {
-			logUserAction("rateLimiter", "error", map[string]interface{}{
+			rateLimitRejectionsTotal.Inc()
+			logUserAction(r.Context(), "rateLimiter", "error", map[string]interface{}{
 				"ip":     r.RemoteAddr,
 				"reason": "Rate limit exceeded",
 			})
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			writeProblem(w, r, newAPIError(http.StatusTooManyRequests, codeRateLimited, "Rate limit exceeded. Please slow down.", nil))
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -127,6 +160,9 @@ func InitDB() {
 		logger.Fatal("Error loading .env file")
 	}
 	jwtKey = []byte(os.Getenv("JWT_SECRET"))
+	if err := loadDefaultCurrency(); err != nil {
+		logger.Fatal("Invalid DEFAULT_CURRENCY:", err)
+	}
 	host := os.Getenv("DB_HOST")
 	port := os.Getenv("DB_PORT")
 	user := os.Getenv("DB_USER")
@@ -134,82 +170,133 @@ func InitDB() {
 	dbname := os.Getenv("DB_NAME")
 
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-	Db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	Db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		logger.Fatal("Failed to connect to the database:", err)
 	}
 
-	err = Db.AutoMigrate(&User{}, &Product{})
+	if err := Db.Use(gormtracing.NewPlugin(gormtracing.WithoutQueryVariables(), gormtracing.WithoutMetrics())); err != nil {
+		logger.Fatal("Failed to enable database tracing:", err)
+	}
+
+	if err := migrateProductCharacteristics(Db); err != nil {
+		logger.Fatal("Failed to convert product characteristics:", err)
+	}
+
+	err = Db.AutoMigrate(models...)
 	if err != nil {
 		logger.Fatal("Failed to migrate database:", err)
 	}
 
+	if err := migrateProductPrices(Db); err != nil {
+		logger.Fatal("Failed to convert product prices:", err)
+	}
+	if err := migrateOrderSubtotals(Db); err != nil {
+		logger.Fatal("Failed to fill order subtotals:", err)
+	}
+
 	logger.Info("Database connected and migrated successfully!")
 }
 
 func isValidEmail(email string) bool {
-	regex := `^[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}$`
-	re := regexp.MustCompile(regex)
-	return re.MatchString(email)
+	return validation.IsEmail(email)
+}
+
+func validateRequest(v interface{}) error {
+	err := validation.Struct(v)
+	if err == nil {
+		return nil
+	}
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		return internalError(err)
+	}
+
+	fields := make([]FieldError, len(errs))
+	for i, fe := range errs {
+		fields[i] = fieldError(fe.Field, fe.Rule, fe.Message)
+	}
+	return validationFailed(fields...)
+}
+
+func decodeAndValidate(w http.ResponseWriter, r *http.Request, action string, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		handleError(w, r, action, invalidJSON(err))
+		return false
+	}
+	if err := validateRequest(v); err != nil {
+		handleError(w, r, action, err)
+		return false
+	}
+	return true
+}
+
+func handleError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	e := asAPIError(err)
+	writeProblem(w, r, e)
+	logUserAction(r.Context(), action, "error", map[string]interface{}{
+		"error":  err.Error(),
+		"code":   e.Code,
+		"status": e.Status,
+	})
 }
 
-func handleError(w http.ResponseWriter, action string, err error, statusCode int) {
-	http.Error(w, err.Error(), statusCode)
-	logUserAction(action, "error", map[string]interface{}{"error": err.Error()})
+type createUserRequest struct {
+	Name     string `json:"name" validate:"required,min=3,max=50"`
+	Email    string `json:"email" validate:"required,email,max=100"`
+	Password string `json:"password" validate:"required,min=6,max=72"`
+	Locale   string `json:"locale" validate:"oneof=en ru"`
 }
 
+// CreateUser is the public signup endpoint, so every account starts with
+// the "user" role; only an admin can change it through updateUser.
 func CreateUser(w http.ResponseWriter, r *http.Request) {
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		handleError(w, "createUser", fmt.Errorf("invalid input data: %v", err), http.StatusBadRequest)
+	var req createUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, "createUser", invalidJSON(err))
 		return
 	}
 
-	logger.WithFields(logrus.Fields{
-		"name":     user.Name,
-		"email":    user.Email,
-		"password": user.Password,
-		"role":     user.Role,
+	requestLogger(r.Context()).WithFields(logrus.Fields{
+		"name":  req.Name,
+		"email": req.Email,
 	}).Info("Received createUser request")
 
-	if user.Name == "" {
-		handleError(w, "createUser", fmt.Errorf("name is required"), http.StatusBadRequest)
-		return
-	}
-	if user.Email == "" {
-		handleError(w, "createUser", fmt.Errorf("email is required"), http.StatusBadRequest)
+	if err := validateRequest(req); err != nil {
+		handleError(w, r, "createUser", err)
 		return
 	}
-	if !isValidEmail(user.Email) {
-		handleError(w, "createUser", fmt.Errorf("invalid email format"), http.StatusBadRequest)
-		return
-	}
-	if user.Password == "" || len(user.Password) < 6 {
-		handleError(w, "createUser", fmt.Errorf("password must be at least 6 characters"), http.StatusBadRequest)
-		return
-	}
-	if user.Role == "" {
-		user.Role = "user"
-	}
+
+	user := User{Name: req.Name, Email: req.Email, Password: req.Password, Role: "user"}
+	user.Locale = resolveLocale(req.Locale, r.Header.Get("Accept-Language"))
 
 	user.ConfirmationCode = generateConfirmationCode()
 	user.Confirmed = false
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 
-	if err := Db.Create(&user).Error; err != nil {
-		handleError(w, "createUser", fmt.Errorf("error creating user: %v", err), http.StatusInternalServerError)
+	err := Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		msg, err := confirmationEmail(r.Context(), user)
+		if err != nil {
+			return err
+		}
+		return enqueueEmail(tx, msg)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		handleError(w, r, "createUser", newAPIError(http.StatusConflict, codeConflict, "A user with this email already exists", err))
 		return
 	}
-
-	if err := sendConfirmationEmail(user); err != nil {
-		handleError(w, "createUser", fmt.Errorf("failed to send confirmation email: %v", err), http.StatusInternalServerError)
+	if err != nil {
+		handleError(w, r, "createUser", internalError(fmt.Errorf("error creating user: %v", err)))
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(user)
-	logUserAction("createUser", "success", map[string]interface{}{"user_id": user.ID})
+	logUserAction(r.Context(), "createUser", "success", map[string]interface{}{"user_id": user.ID})
 }
 
 func getUsers(w http.ResponseWriter, r *http.Request) {
@@ -226,122 +313,139 @@ func getUsers(w http.ResponseWriter, r *http.Request) {
 
 	offset := (page - 1) * limit
 
-	if err := Db.Limit(limit).Offset(offset).Find(&users).Error; err != nil {
-		handleError(w, "getUsers", fmt.Errorf("error retrieving users: %v", err), http.StatusInternalServerError)
+	if err := Db.WithContext(r.Context()).Limit(limit).Offset(offset).Find(&users).Error; err != nil {
+		handleError(w, r, "getUsers", internalError(fmt.Errorf("error retrieving users: %v", err)))
 		return
 	}
 
 	json.NewEncoder(w).Encode(users)
-	logUserAction("getUsers", "success", map[string]interface{}{"page": page, "count": len(users)})
+	logUserAction(r.Context(), "getUsers", "success", map[string]interface{}{"page": page, "count": len(users)})
 }
 
 func getUserByID(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 	if id == "" {
-		handleError(w, "getUserByID", fmt.Errorf("id is required"), http.StatusBadRequest)
+		handleError(w, r, "getUserByID", invalidParameter("Query parameter id is required"))
 		return
 	}
 
 	var user User
-	if err := Db.First(&user, id).Error; err != nil {
+	if err := Db.WithContext(r.Context()).First(&user, id).Error; err != nil {
 
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			handleError(w, "getUserByID", fmt.Errorf("user not found: %v", err), http.StatusNotFound)
+			handleError(w, r, "getUserByID", notFound("User not found", err))
 			return
 		}
 
-		handleError(w, "getUserByID", fmt.Errorf("database error: %v", err), http.StatusInternalServerError)
+		handleError(w, r, "getUserByID", internalError(fmt.Errorf("database error: %v", err)))
 		return
 	}
 
 	json.NewEncoder(w).Encode(user)
-	logUserAction("getUserByID", "success", map[string]interface{}{
+	logUserAction(r.Context(), "getUserByID", "success", map[string]interface{}{
 		"user": user,
 	})
 }
 func getUserByIDProf(w http.ResponseWriter, r *http.Request) {
 	idStr := r.URL.Query().Get("id")
 	if idStr == "" {
-		http.Error(w, "ID is required", http.StatusBadRequest)
+		handleError(w, r, "getUserByIDProf", invalidParameter("Query parameter id is required"))
 		return
 	}
 
 	var id uint
 	if _, err := fmt.Sscanf(idStr, "%d", &id); err != nil {
-		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		handleError(w, r, "getUserByIDProf", invalidParameter("Query parameter id must be a positive integer"))
 		return
 	}
 
 	var user User
-	if err := Db.Where("id = ?", id).First(&user).Error; err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	if err := Db.WithContext(r.Context()).Where("id = ?", id).First(&user).Error; err != nil {
+		handleError(w, r, "getUserByIDProf", notFound("User not found", err))
 		return
 	}
 
 	json.NewEncoder(w).Encode(user)
 }
 
+type updateUserRequest struct {
+	ID       uint   `json:"id" validate:"required"`
+	Name     string `json:"name" validate:"min=3,max=50"`
+	Email    string `json:"email" validate:"email,max=100"`
+	Password string `json:"password" validate:"min=6,max=72"`
+	Role     string `json:"role" validate:"oneof=user agent admin"`
+	Locale   string `json:"locale" validate:"oneof=en ru"`
+}
+
 func updateUser(w http.ResponseWriter, r *http.Request) {
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		handleError(w, "updateUser", fmt.Errorf("invalid input data: %v", err), http.StatusBadRequest)
+	var req updateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, "updateUser", invalidJSON(err))
 		return
 	}
 
-	if user.ID == 0 {
-		handleError(w, "updateUser", fmt.Errorf("user ID is required"), http.StatusBadRequest)
-		return
-	}
+	applyUserUpdate(w, r, req)
+}
 
-	if user.Name != "" && len(user.Name) < 3 {
-		handleError(w, "updateUser", fmt.Errorf("name must be at least 3 characters long"), http.StatusBadRequest)
+// applyUserUpdate backs both /update and PUT /api/v1/users/{id}: users may
+// only edit their own profile, and only admins may change a role.
+func applyUserUpdate(w http.ResponseWriter, r *http.Request, req updateUserRequest) {
+	if err := validateRequest(req); err != nil {
+		handleError(w, r, "updateUser", err)
 		return
 	}
-
-	if user.Email != "" && !isValidEmail(user.Email) {
-		handleError(w, "updateUser", fmt.Errorf("invalid email format"), http.StatusBadRequest)
+	if !canAccessUser(r, req.ID) {
+		handleError(w, r, "updateUser", forbidden("You can only update your own profile"))
 		return
 	}
-
-	if user.Password != "" && len(user.Password) < 6 {
-		handleError(w, "updateUser", fmt.Errorf("password must be at least 6 characters"), http.StatusBadRequest)
+	if req.Role != "" && requestUserRole(r) != "admin" {
+		handleError(w, r, "updateUser", forbidden("Only admins can change roles"))
 		return
 	}
-	if user.Role != "" && (user.Role != "user" && user.Role != "admin") {
-		http.Error(w, "Invalid role", http.StatusBadRequest)
-		return
+
+	user := User{
+		ID:        req.ID,
+		Name:      req.Name,
+		Email:     req.Email,
+		Password:  req.Password,
+		Role:      req.Role,
+		Locale:    req.Locale,
+		UpdatedAt: time.Now(),
 	}
-	user.UpdatedAt = time.Now()
 
-	if err := Db.Model(&User{}).Where("id = ?", user.ID).Updates(user).Error; err != nil {
-		handleError(w, "updateUser", fmt.Errorf("error updating user: %v", err), http.StatusInternalServerError)
+	if err := Db.WithContext(r.Context()).Model(&User{}).Where("id = ?", user.ID).Updates(user).Error; err != nil {
+		handleError(w, r, "updateUser", internalError(fmt.Errorf("error updating user: %v", err)))
 		return
 	}
 
 	json.NewEncoder(w).Encode(user)
-	logUserAction("updateUser", "success", map[string]interface{}{"user": user})
+	logUserAction(r.Context(), "updateUser", "success", map[string]interface{}{"user": user})
 }
 
 func deleteUser(w http.ResponseWriter, r *http.Request) {
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		handleError(w, "deleteUser", fmt.Errorf("invalid input data: %v", err), http.StatusBadRequest)
-		return
+	var req struct {
+		ID uint `json:"id" validate:"required"`
 	}
-
-	if user.ID == 0 {
-		handleError(w, "deleteUser", fmt.Errorf("user ID is required"), http.StatusBadRequest)
+	if !decodeAndValidate(w, r, "deleteUser", &req) {
 		return
 	}
 
-	if err := Db.Delete(&User{}, user.ID).Error; err != nil {
-		handleError(w, "deleteUser", fmt.Errorf("error deleting user: %v", err), http.StatusInternalServerError)
+	if err := removeUser(r.Context(), req.ID); err != nil {
+		handleError(w, r, "deleteUser", internalError(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "User deleted successfully"})
-	logUserAction("deleteUser", "success", map[string]interface{}{"id": user.ID})
+}
+
+func removeUser(ctx context.Context, id uint) error {
+	if err := Db.WithContext(ctx).Delete(&User{}, id).Error; err != nil {
+		return fmt.Errorf("error deleting user: %v", err)
+	}
+
+	logUserAction(ctx, "deleteUser", "success", map[string]interface{}{"id": id})
+	return nil
 }
 
 func generateJWT(user User) (string, error) {
@@ -356,37 +460,42 @@ func generateJWT(user User) (string, error) {
 }
 func login(w http.ResponseWriter, r *http.Request) {
 	var loginData struct {
-		Name     string `json:"name"`
-		Password string `json:"password"`
+		Name     string `json:"name" validate:"required,max=50"`
+		Password string `json:"password" validate:"required,max=72"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&loginData); err != nil {
-		http.Error(w, "Invalid input data", http.StatusBadRequest)
+	if !decodeAndValidate(w, r, "login", &loginData) {
+		loginAttemptsTotal.WithLabelValues("invalid_request").Inc()
 		return
 	}
 
 	var user User
-	if err := Db.Where("name = ?", loginData.Name).First(&user).Error; err != nil {
-		http.Error(w, "User not found", http.StatusUnauthorized)
+	if err := Db.WithContext(r.Context()).Where("name = ?", loginData.Name).First(&user).Error; err != nil {
+		loginAttemptsTotal.WithLabelValues("unknown_user").Inc()
+		handleError(w, r, "login", newAPIError(http.StatusUnauthorized, codeInvalidCredentials, "Invalid username or password", err))
 		return
 	}
 
 	if !user.Confirmed {
-		http.Error(w, "Account not confirmed. Please check your email.", http.StatusForbidden)
+		loginAttemptsTotal.WithLabelValues("unconfirmed").Inc()
+		handleError(w, r, "login", newAPIError(http.StatusForbidden, codeAccountNotConfirmed, "Account not confirmed. Please check your email.", nil))
 		return
 	}
 
 	if user.Password != loginData.Password {
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		loginAttemptsTotal.WithLabelValues("invalid_password").Inc()
+		handleError(w, r, "login", newAPIError(http.StatusUnauthorized, codeInvalidCredentials, "Invalid username or password", nil))
 		return
 	}
 
 	token, err := generateJWT(user)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		loginAttemptsTotal.WithLabelValues("error").Inc()
+		handleError(w, r, "login", internalError(fmt.Errorf("failed to generate token: %v", err)))
 		return
 	}
 
+	loginAttemptsTotal.WithLabelValues("success").Inc()
 	response := map[string]interface{}{
 		"message": "Login successful",
 		"token":   token,
@@ -399,253 +508,125 @@ func login(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func authMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenStr := r.Header.Get("Authorization")
-		if tokenStr == "" {
-			http.Error(w, "Authorization header is required", http.StatusUnauthorized)
-			return
-		}
-		tokenStr = strings.TrimPrefix(tokenStr, "Bearer ")
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return jwtKey, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-func adminMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenStr := r.Header.Get("Authorization")
-		if tokenStr == "" {
-			http.Error(w, "Authorization header is required", http.StatusUnauthorized)
-			return
-		}
-		tokenStr = strings.TrimPrefix(tokenStr, "Bearer ")
-
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return jwtKey, nil
-		})
+type contextKey string
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
+const claimsContextKey contextKey = "claims"
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || !token.Valid {
-			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
-			return
-		}
+func parseToken(r *http.Request) (jwt.MapClaims, error) {
+	tokenStr := r.Header.Get("Authorization")
+	if tokenStr == "" {
+		return nil, newAPIError(http.StatusUnauthorized, codeAuthRequired, "Authorization header is required", nil)
+	}
+	tokenStr = strings.TrimPrefix(tokenStr, "Bearer ")
 
-		role, ok := claims["role"].(string)
-		if !ok || role != "admin" {
-			http.Error(w, "Access denied: Admins only", http.StatusForbidden)
-			return
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-
-		next.ServeHTTP(w, r)
+		return jwtKey, nil
 	})
-}
+	if err != nil || !token.Valid {
+		return nil, newAPIError(http.StatusUnauthorized, codeInvalidToken, "Invalid token", err)
+	}
 
-func generateConfirmationCode() string {
-	return strconv.Itoa(time.Now().Nanosecond())
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, newAPIError(http.StatusUnauthorized, codeInvalidToken, "Invalid token claims", nil)
+	}
+	return claims, nil
 }
 
-func sendConfirmationEmail(user User) error {
-	subject := "Подтверждение регистрации"
-	body := fmt.Sprintf("Здравствуйте, %s!\n\nПожалуйста, подтвердите вашу регистрацию, перейдя по ссылке: http://localhost:8080/confirm?code=%s", user.Name, user.ConfirmationCode)
-
-	return sendEmail(subject, body, []string{user.Email}, nil)
+func requestClaims(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(claimsContextKey).(jwt.MapClaims)
+	return claims
 }
 
-func confirmEmail(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		handleError(w, "confirmEmail", errors.New("confirmation code is required"), http.StatusBadRequest)
-		return
-	}
-
-	var user User
-	if err := Db.Where("confirmation_code = ?", code).First(&user).Error; err != nil {
-		handleError(w, "confirmEmail", fmt.Errorf("invalid confirmation code: %v", err), http.StatusNotFound)
-		return
-	}
-
-	user.Confirmed = true
-	user.ConfirmationCode = ""
-	if err := Db.Save(&user).Error; err != nil {
-		handleError(w, "confirmEmail", fmt.Errorf("error confirming email: %v", err), http.StatusInternalServerError)
-		return
+func requestUserID(r *http.Request) (uint, bool) {
+	id, ok := requestClaims(r)["id"].(float64)
+	if !ok || id <= 0 {
+		return 0, false
 	}
+	return uint(id), true
+}
 
-	json.NewEncoder(w).Encode(map[string]string{"message": "Email confirmed successfully"})
-	logUserAction("confirmEmail", "success", map[string]interface{}{"user_id": user.ID})
+func requestUserRole(r *http.Request) string {
+	role, _ := requestClaims(r)["role"].(string)
+	return role
 }
 
-func sendSupportTicket(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
+func withClaims(r *http.Request, claims jwt.MapClaims) *http.Request {
+	r = r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims))
+	if id, ok := requestUserID(r); ok {
+		setRequestUser(r.Context(), id)
 	}
+	return r
+}
 
-	name := r.FormValue("name")
-	email := r.FormValue("email")
-	message := r.FormValue("message")
-	file, fileHeader, err := r.FormFile("file")
-
-	if err != nil && err != http.ErrMissingFile {
-		http.Error(w, "Failed to read file", http.StatusInternalServerError)
-		return
-	}
-	defer func() {
-		if file != nil {
-			file.Close()
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := parseToken(r)
+		if err != nil {
+			handleError(w, r, "auth", err)
+			return
 		}
-	}()
 
-	subject := "Support Ticket from " + name
-	body := fmt.Sprintf("Name: %s\nEmail: %s\n\nMessage: %s", name, email, message)
-
-	if err := sendEmailToSupport(subject, body, file, fileHeader); err != nil {
-		http.Error(w, "Failed to send email: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Ticket submitted successfully!")
+		next.ServeHTTP(w, withClaims(r, claims))
+	})
 }
 
-func sendEmailToSupport(subject, body string, attachment io.Reader, fileHeader *multipart.FileHeader) error {
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	from := smtpUser
-
-	if smtpUser == "" || smtpPass == "" || smtpHost == "" || smtpPort == "" {
-		return fmt.Errorf("SMTP configuration is missing. Check environment variables")
-	}
-
-	to := []string{"alan4ik.selivanov@yandex.kz"}
-	var msg bytes.Buffer
-
-	boundary := "boundary-example"
-	msg.WriteString(fmt.Sprintf("From: %s\n", from))
-	msg.WriteString(fmt.Sprintf("To: %s\n", strings.Join(to, ", ")))
-	msg.WriteString(fmt.Sprintf("Subject: %s\n", subject))
-	msg.WriteString("MIME-Version: 1.0\n")
-	msg.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=%s\n", boundary))
-	msg.WriteString("\n")
-
-	msg.WriteString(fmt.Sprintf("--%s\n", boundary))
-	msg.WriteString("Content-Type: text/plain; charset=utf-8\n\n")
-	msg.WriteString(body + "\n\n")
-
-	if attachment != nil && fileHeader != nil {
-		fileContent, err := io.ReadAll(attachment)
+func requireRole(next http.Handler, message string, roles ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := parseToken(r)
 		if err != nil {
-			return fmt.Errorf("failed to read file content: %v", err)
+			handleError(w, r, "auth", err)
+			return
 		}
 
-		encoded := base64.StdEncoding.EncodeToString(fileContent)
-
-		msg.WriteString(fmt.Sprintf("--%s\n", boundary))
-		msg.WriteString(fmt.Sprintf("Content-Type: %s\n", fileHeader.Header.Get("Content-Type")))
-		msg.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=\"%s\"\n", fileHeader.Filename))
-		msg.WriteString("Content-Transfer-Encoding: base64\n\n")
-		msg.WriteString(encoded)
-		msg.WriteString("\n\n")
-	}
-
-	msg.WriteString(fmt.Sprintf("--%s--", boundary))
-
-	err := smtp.SendMail(smtpHost+":"+smtpPort, smtp.PlainAuth("", smtpUser, smtpPass, smtpHost), from, to, msg.Bytes())
-	if err != nil {
-		return fmt.Errorf("failed to send email: %v", err)
-	}
-
-	return nil
+		role, _ := claims["role"].(string)
+		for _, allowed := range roles {
+			if role == allowed {
+				next.ServeHTTP(w, withClaims(r, claims))
+				return
+			}
+		}
+		handleError(w, r, "auth", forbidden(message))
+	})
 }
 
-func sendEmail(subject, body string, to []string, cc []string) error {
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	headers := make(map[string]string)
-	headers["From"] = smtpUser
-	headers["To"] = to[0]
-	headers["Subject"] = subject
-
-	if len(cc) > 0 {
-		headers["Cc"] = cc[0]
-	}
-
-	message := ""
-	for k, v := range headers {
-		message += fmt.Sprintf("%s: %s\r\n", k, v)
-	}
-	message += "\r\n" + body
+func adminMiddleware(next http.Handler) http.Handler {
+	return requireRole(next, "Access denied: Admins only", "admin")
+}
 
-	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
+func agentMiddleware(next http.Handler) http.Handler {
+	return requireRole(next, "Access denied: Support agents only", "agent", "admin")
+}
 
-	return smtp.SendMail(
-		fmt.Sprintf("%s:%s", smtpHost, smtpPort),
-		auth,
-		smtpUser,
-		to,
-		[]byte(message),
-	)
+func generateConfirmationCode() string {
+	return strconv.Itoa(time.Now().Nanosecond())
 }
-func createProduct(w http.ResponseWriter, r *http.Request) {
-	var product struct {
-		Name            string  `json:"name"`
-		Description     string  `json:"description"`
-		Price           float64 `json:"price"`
-		Characteristics string  `json:"characteristics"`
-		Date            string  `json:"date"`
-		Image           string  `json:"image"`
-	}
 
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+func confirmEmail(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		handleError(w, r, "confirmEmail", invalidParameter("Query parameter code is required"))
 		return
 	}
 
-	parsedDate, err := time.Parse("2006-01-02", product.Date)
-	if err != nil {
-		http.Error(w, "Invalid date format. Use YYYY-MM-DD.", http.StatusBadRequest)
+	var user User
+	if err := Db.WithContext(r.Context()).Where("confirmation_code = ?", code).First(&user).Error; err != nil {
+		handleError(w, r, "confirmEmail", notFound("Confirmation code is invalid or has already been used", err))
 		return
 	}
 
-	newProduct := Product{
-		Name:            product.Name,
-		Description:     product.Description,
-		Price:           product.Price,
-		Characteristics: product.Characteristics,
-		Date:            parsedDate,
-		Image:           product.Image,
-	}
-
-	if err := Db.Create(&newProduct).Error; err != nil {
-		http.Error(w, "Failed to save product", http.StatusInternalServerError)
+	user.Confirmed = true
+	user.ConfirmationCode = ""
+	if err := Db.WithContext(r.Context()).Save(&user).Error; err != nil {
+		handleError(w, r, "confirmEmail", internalError(fmt.Errorf("error confirming email: %v", err)))
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newProduct)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email confirmed successfully"})
+	logUserAction(r.Context(), "confirmEmail", "success", map[string]interface{}{"user_id": user.ID})
 }
 
 func filterUsers(w http.ResponseWriter, r *http.Request) {
@@ -653,7 +634,7 @@ func filterUsers(w http.ResponseWriter, r *http.Request) {
 	email := r.URL.Query().Get("email")
 
 	var users []User
-	query := Db.Model(&User{})
+	query := Db.WithContext(r.Context()).Model(&User{})
 
 	if name != "" {
 		query = query.Where("name ILIKE ?", "%"+name+"%")
@@ -663,13 +644,13 @@ func filterUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := query.Find(&users).Error; err != nil {
-		handleError(w, "filterUsers", fmt.Errorf("error filtering users: %v", err), http.StatusInternalServerError)
+		handleError(w, r, "filterUsers", internalError(fmt.Errorf("error filtering users: %v", err)))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(users)
-	logUserAction("filterUsers", "success", map[string]interface{}{
+	logUserAction(r.Context(), "filterUsers", "success", map[string]interface{}{
 		"filters": map[string]string{
 			"name":  name,
 			"email": email,
@@ -679,22 +660,30 @@ func filterUsers(w http.ResponseWriter, r *http.Request) {
 }
 
 func sortUsers(w http.ResponseWriter, r *http.Request) {
-	sortField := r.URL.Query().Get("field")
-	sortOrder := r.URL.Query().Get("order")
-
-	if sortField == "" {
-		sortField = "id"
+	params := struct {
+		Field string `json:"field" validate:"oneof=id name email created_at updated_at"`
+		Order string `json:"order" validate:"oneof=asc desc"`
+	}{
+		Field: r.URL.Query().Get("field"),
+		Order: r.URL.Query().Get("order"),
+	}
+	if err := validateRequest(params); err != nil {
+		handleError(w, r, "sortUsers", err)
+		return
 	}
 
-	if sortOrder != "asc" && sortOrder != "desc" {
-		sortOrder = "asc"
+	if params.Field == "" {
+		params.Field = "id"
+	}
+	if params.Order == "" {
+		params.Order = "asc"
 	}
 
 	var users []User
-	query := Db.Order(fmt.Sprintf("%s %s", sortField, sortOrder))
+	query := Db.WithContext(r.Context()).Order(fmt.Sprintf("%s %s", params.Field, params.Order))
 
 	if err := query.Find(&users).Error; err != nil {
-		http.Error(w, fmt.Sprintf("Error sorting users: %v", err), http.StatusInternalServerError)
+		handleError(w, r, "sortUsers", internalError(fmt.Errorf("error sorting users: %v", err)))
 		return
 	}
 
@@ -710,6 +699,9 @@ func signupPage(w http.ResponseWriter, r *http.Request) {
 func mainPage(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "main_page.html")
 }
+func coursesPage(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, "courses_page.html")
+}
 func adminPanel(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "adminPanel.html")
 }
@@ -717,31 +709,79 @@ func profilePage(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "profile_page.html")
 }
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	initLogger()
+	shutdownTracing, err := initTracing(ctx)
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing: ", err)
+	}
 	InitDB()
+	registerDBMetrics()
+	if err := initMailer(); err != nil {
+		logger.Fatal("Failed to initialize mailer: ", err)
+	}
+	if err := initStorage(); err != nil {
+		logger.Fatal("Failed to initialize storage: ", err)
+	}
+	if err := initPayments(); err != nil {
+		logger.Fatal("Failed to initialize payments: ", err)
+	}
+	go runOutboxWorker(ctx, 10*time.Second)
+	go runSubscriptionWorker(ctx, time.Minute)
+	go runInvoiceWorker(ctx, 30*time.Second)
+	if dir := os.Getenv("INBOUND_MAILDIR"); dir != "" {
+		go runMaildirPoller(ctx, dir, 30*time.Second)
+	}
 	mux := http.NewServeMux()
 
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", healthz)
+	mux.HandleFunc("GET /readyz", readyz)
 	mux.HandleFunc("/confirm", confirmEmail)
 	mux.HandleFunc("/login", login)
 	mux.HandleFunc("/create", CreateUser)
-	mux.Handle("/read", adminMiddleware(http.HandlerFunc(getUsers)))
-	mux.Handle("/readByID", adminMiddleware(http.HandlerFunc(getUserByID)))
-	mux.Handle("/readByIDprof", authMiddleware(http.HandlerFunc(getUserByIDProf)))
-	mux.Handle("/update", authMiddleware(http.HandlerFunc(updateUser)))
-	mux.Handle("/delete", adminMiddleware(http.HandlerFunc(deleteUser)))
+	mux.Handle("/read", deprecated("/api/v1/users", adminMiddleware(http.HandlerFunc(getUsers))))
+	mux.Handle("/readByID", deprecated("/api/v1/users/{id}", adminMiddleware(http.HandlerFunc(getUserByID))))
+	mux.Handle("/readByIDprof", deprecated("/api/v1/users/{id}", authMiddleware(http.HandlerFunc(getUserByIDProf))))
+	mux.Handle("/update", deprecated("/api/v1/users/{id}", authMiddleware(http.HandlerFunc(updateUser))))
+	mux.Handle("/delete", deprecated("/api/v1/users/{id}", adminMiddleware(http.HandlerFunc(deleteUser))))
 	mux.Handle("/log-error", adminMiddleware(http.HandlerFunc(logClientError)))
-	mux.Handle("/send-support-ticket", authMiddleware(http.HandlerFunc(sendSupportTicket)))
+	handleResource(mux, "/send-support-ticket", route(http.MethodPost, authMiddleware(http.HandlerFunc(createTicket))))
 	mux.Handle("/filter", adminMiddleware(http.HandlerFunc(filterUsers)))
 	mux.Handle("/sort", adminMiddleware(http.HandlerFunc(sortUsers)))
-	mux.Handle("/create-product", adminMiddleware(http.HandlerFunc(createProduct)))
+	mux.Handle("/create-product", deprecated("/api/v1/products", adminMiddleware(http.HandlerFunc(createProduct))))
+	registerAPIRoutes(mux)
 	mux.HandleFunc("/static/loginPage", loginPage)
 	mux.HandleFunc("/static/signupPage", signupPage)
 	mux.HandleFunc("/adminPanel", adminPanel)
+	mux.HandleFunc("/courses", coursesPage)
 	mux.HandleFunc("/profilePage", profilePage)
 	mux.HandleFunc("/", mainPage)
 
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
+	mux.HandleFunc("GET "+mediaPath, serveMedia)
+	registerFakeCheckout(mux)
+
+	handler := requestLoggingMiddleware(metricsMiddleware(traceRouteMiddleware(rateLimiterMiddleware(mux))))
+	server := &http.Server{Addr: ":8080", Handler: tracingMiddleware(handler)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
 	logger.Info("Server started on :8080")
-	log.Fatal(http.ListenAndServe(":8080", rateLimiterMiddleware(mux)))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Failed to flush traces: ", err)
+	}
+	logger.Info("Server stopped")
 }
@@ -1,6 +1,19 @@
 package main
 
-import "testing"
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"LanguageLearningPlatform/validation"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+)
 
 func TestIsValidEmail(t *testing.T) {
 	testCases := []struct {
@@ -23,3 +36,123 @@ func TestIsValidEmail(t *testing.T) {
 		}
 	}
 }
+
+func TestHandleResourceMethodNotAllowed(t *testing.T) {
+	mux := http.NewServeMux()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handleResource(mux, "/api/v1/things/{id}",
+		route(http.MethodGet, ok),
+		route(http.MethodDelete, ok),
+	)
+
+	response := httptest.NewRecorder()
+	mux.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/api/v1/things/1", nil))
+
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Incorrect status code. Expected: %d, Got: %d", http.StatusMethodNotAllowed, response.Code)
+	}
+	if allow := response.Header().Get("Allow"); allow != "GET, HEAD, DELETE, OPTIONS" {
+		t.Errorf("Incorrect Allow header: %q", allow)
+	}
+
+	response = httptest.NewRecorder()
+	mux.ServeHTTP(response, httptest.NewRequest(http.MethodDelete, "/api/v1/things/1", nil))
+	if response.Code != http.StatusOK {
+		t.Errorf("Incorrect status code. Expected: %d, Got: %d", http.StatusOK, response.Code)
+	}
+}
+
+func TestHandleErrorWritesProblemDetails(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/users/1", nil)
+	request.Header.Set("X-Request-ID", "req-123")
+	response := httptest.NewRecorder()
+
+	handleError(response, request, "getUserByID", internalError(errors.New("pq: relation \"users\" does not exist")))
+
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("Incorrect status code. Expected: %d, Got: %d", http.StatusInternalServerError, response.Code)
+	}
+	if contentType := response.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Errorf("Incorrect content type: %q", contentType)
+	}
+	if strings.Contains(response.Body.String(), "pq:") {
+		t.Errorf("Internal error details leaked to client: %s", response.Body.String())
+	}
+
+	var problem Problem
+	if err := json.Unmarshal(response.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if problem.Code != codeInternal || problem.RequestID != "req-123" || problem.Instance != "/api/v1/users/1" {
+		t.Errorf("Unexpected problem document: %+v", problem)
+	}
+}
+
+func TestValidationFailedIncludesFieldErrors(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+
+	request := httptest.NewRequest(http.MethodPost, "/create", nil)
+	response := httptest.NewRecorder()
+
+	handleError(response, request, "createUser", validationFailed(fieldError("email", "email", "Email must be a valid email address")))
+
+	var problem Problem
+	if err := json.Unmarshal(response.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Code != http.StatusUnprocessableEntity || problem.Code != codeValidationFailed {
+		t.Errorf("Unexpected response: %d %+v", response.Code, problem)
+	}
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "email" {
+		t.Errorf("Unexpected field errors: %+v", problem.Errors)
+	}
+	if problem.RequestID == "" || response.Header().Get("X-Request-ID") != problem.RequestID {
+		t.Errorf("Request ID was not generated: %+v", problem)
+	}
+}
+
+func TestRequestValidationTags(t *testing.T) {
+	requests := []interface{}{
+		categoryRequest{}, couponRequest{}, lessonRequest{}, clientErrorReport{},
+		createUserRequest{}, updateUserRequest{}, orderRequest{}, productFilter{},
+		productRequest{}, reviewRequest{}, planRequest{},
+	}
+	for _, req := range requests {
+		if err := validation.CheckTags(req); err != nil {
+			t.Errorf("%T: %v", req, err)
+		}
+	}
+
+	var apiErr *apiError
+	err := validateRequest(struct {
+		Name string `validate:"requird"`
+	}{})
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusInternalServerError {
+		t.Errorf("Expected a malformed tag to be an internal error, got %v", err)
+	}
+}
+
+func TestLegacyUpdateUserEnforcesOwnershipAndRole(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cases := []struct {
+		name string
+		body string
+	}{
+		{"role", `{"id": 5, "role": "admin"}`},
+		{"other user", `{"id": 6, "email": "attacker@example.com"}`},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest(http.MethodPost, "/update", strings.NewReader(c.body))
+		response := httptest.NewRecorder()
+		updateUser(response, withClaims(request, jwt.MapClaims{"id": float64(5), "role": "user"}))
+		if response.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", c.name, response.Code)
+		}
+	}
+}
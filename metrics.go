@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests served, by route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by route pattern, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	rateLimitRejectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rate_limiter_rejections_total",
+		Help: "Requests rejected by the global rate limiter.",
+	})
+
+	loginAttemptsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "login_attempts_total",
+		Help: "Login attempts, by result.",
+	}, []string{"result"})
+
+	emailsSentTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "emails_sent_total",
+		Help: "Outgoing emails, by result.",
+	}, []string{"result"})
+)
+
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+func registerDBMetrics() {
+	sqlDB, err := Db.DB()
+	if err != nil {
+		logger.Error("Failed to register database metrics: ", err)
+		return
+	}
+	prometheus.MustRegister(collectors.NewDBStatsCollector(sqlDB, "postgres"))
+}
+
+func recordEmailOutcome(err error) {
+	if err != nil {
+		emailsSentTotal.WithLabelValues("failure").Inc()
+		return
+	}
+	emailsSentTotal.WithLabelValues("success").Inc()
+}
+
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		method := r.Method
+		if !knownMethods[method] {
+			method = "OTHER"
+		}
+		status := strconv.Itoa(rec.Status())
+
+		httpRequestsTotal.WithLabelValues(route, method, status).Inc()
+		httpRequestDuration.WithLabelValues(route, method, status).Observe(time.Since(start).Seconds())
+	})
+}
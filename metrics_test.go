@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddlewareLabelsByRoutePattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /widgets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := metricsMiddleware(mux)
+
+	before := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("GET /widgets/{id}", "GET", "418"))
+	for _, path := range []string{"/widgets/1", "/widgets/2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	after := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("GET /widgets/{id}", "GET", "418"))
+
+	if after-before != 2 {
+		t.Errorf("Expected 2 requests recorded under the route pattern, got %v", after-before)
+	}
+}
@@ -0,0 +1,175 @@
+// Package money represents prices as integer minor units (cents, tiyn,
+// kopecks) of an ISO 4217 currency, so arithmetic never rounds.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Currency struct {
+	Code   string
+	Digits int
+	Symbol string
+}
+
+// Currencies lists the supported ISO 4217 currencies.
+var Currencies = map[string]Currency{
+	"USD": {Code: "USD", Digits: 2, Symbol: "$"},
+	"EUR": {Code: "EUR", Digits: 2, Symbol: "€"},
+	"GBP": {Code: "GBP", Digits: 2, Symbol: "£"},
+	"KZT": {Code: "KZT", Digits: 2, Symbol: "₸"},
+	"RUB": {Code: "RUB", Digits: 2, Symbol: "₽"},
+	"JPY": {Code: "JPY", Digits: 0, Symbol: "¥"},
+}
+
+var (
+	ErrUnknownCurrency = errors.New("money: unsupported currency")
+	ErrInvalidAmount   = errors.New("money: invalid amount")
+)
+
+func Lookup(code string) (Currency, error) {
+	c, ok := Currencies[strings.ToUpper(code)]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w %q", ErrUnknownCurrency, code)
+	}
+	return c, nil
+}
+
+// Decimal is an amount as written by a client: a JSON number or string such
+// as 12.5 or "12.50". It keeps the literal text so no float conversion
+// happens on the way in.
+type Decimal string
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*d = ""
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*d = Decimal(strings.TrimSpace(s))
+		return nil
+	}
+	*d = Decimal(data)
+	return nil
+}
+
+// Parse converts a decimal string into minor units of currency. It rejects
+// more fractional digits than the currency has rather than rounding.
+func Parse(s string, currency string) (int64, error) {
+	c, err := Lookup(currency)
+	if err != nil {
+		return 0, err
+	}
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, frac, point := strings.Cut(s, ".")
+	if whole == "" || point && frac == "" || !digitsOnly(whole) || !digitsOnly(frac) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if len(frac) > c.Digits {
+		return 0, fmt.Errorf("%w: %s allows at most %d decimal places", ErrInvalidAmount, c.Code, c.Digits)
+	}
+	frac += strings.Repeat("0", c.Digits-len(frac))
+
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+	}
+	if negative {
+		units = -units
+	}
+	return units, nil
+}
+
+func digitsOnly(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String renders minor units as a plain decimal, e.g. 1250 USD -> "12.50".
+func String(amount int64, currency string) string {
+	c, err := Lookup(currency)
+	if err != nil {
+		return strconv.FormatInt(amount, 10)
+	}
+	whole, frac := splitUnits(amount, c.Digits)
+	if c.Digits == 0 {
+		return whole
+	}
+	return whole + "." + frac
+}
+
+// Format renders an amount for display in locale ("en" or "ru"), e.g.
+// "$1,234.50" or "1 234,50 $" (with no-break spaces).
+func Format(amount int64, currency, locale string) string {
+	c, err := Lookup(currency)
+	if err != nil {
+		return String(amount, currency) + " " + currency
+	}
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	whole, frac := splitUnits(amount, c.Digits)
+
+	group, point := ",", "."
+	if locale == "ru" {
+		group, point = " ", ","
+	}
+	number := groupThousands(whole, group)
+	if c.Digits > 0 {
+		number += point + frac
+	}
+	if locale == "ru" {
+		return sign + number + " " + c.Symbol
+	}
+	return sign + c.Symbol + number
+}
+
+func splitUnits(amount int64, digits int) (string, string) {
+	s := strconv.FormatInt(amount, 10)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	if digits > 0 {
+		if len(s) <= digits {
+			s = strings.Repeat("0", digits-len(s)+1) + s
+		}
+		s = s[:len(s)-digits] + "." + s[len(s)-digits:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if negative {
+		whole = "-" + whole
+	}
+	return whole, frac
+}
+
+func groupThousands(s, sep string) string {
+	if len(s) <= 3 {
+		return s
+	}
+	var b strings.Builder
+	head := len(s) % 3
+	if head > 0 {
+		b.WriteString(s[:head])
+	}
+	for i := head; i < len(s); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(s[i : i+3])
+	}
+	return b.String()
+}
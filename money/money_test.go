@@ -0,0 +1,77 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		in       string
+		currency string
+		expected int64
+	}{
+		{"12.5", "USD", 1250},
+		{"0.1", "EUR", 10},
+		{"19.99", "usd", 1999},
+		{"6000", "KZT", 600000},
+		{"1500", "JPY", 1500},
+		{"-3.05", "RUB", -305},
+	}
+	for _, c := range cases {
+		got, err := Parse(c.in, c.currency)
+		if err != nil || got != c.expected {
+			t.Errorf("Parse(%q, %s) = %d, %v; expected %d", c.in, c.currency, got, err, c.expected)
+		}
+	}
+
+	for _, in := range []string{"1.999", "abc", "", "1e3", "1.", ".5", "99999999999999999999"} {
+		if _, err := Parse(in, "USD"); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("Expected ErrInvalidAmount for %q, got %v", in, err)
+		}
+	}
+	if _, err := Parse("10.5", "JPY"); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Expected JPY fractions to be rejected, got %v", err)
+	}
+	if _, err := Parse("1", "XXX"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("Expected ErrUnknownCurrency, got %v", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		amount   int64
+		currency string
+		locale   string
+		expected string
+	}{
+		{123450, "USD", "en", "$1,234.50"},
+		{5, "EUR", "en", "€0.05"},
+		{-100, "USD", "en", "-$1.00"},
+		{123456789, "KZT", "ru", "1 234 567,89 ₸"},
+		{1500, "JPY", "en", "¥1,500"},
+		{99, "RUB", "ru", "0,99 ₽"},
+	}
+	for _, c := range cases {
+		if got := Format(c.amount, c.currency, c.locale); got != c.expected {
+			t.Errorf("Format(%d, %s, %s) = %q, expected %q", c.amount, c.currency, c.locale, got, c.expected)
+		}
+	}
+	if got := String(1250, "USD"); got != "12.50" {
+		t.Errorf("String = %q", got)
+	}
+}
+
+func TestDecimalAcceptsNumbersAndStrings(t *testing.T) {
+	var v struct {
+		A Decimal `json:"a"`
+		B Decimal `json:"b"`
+	}
+	if err := json.Unmarshal([]byte(`{"a": 19.99, "b": " 5.10 "}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.A != "19.99" || v.B != "5.10" {
+		t.Errorf("Unexpected decimals %q %q", v.A, v.B)
+	}
+}
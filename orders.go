@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"LanguageLearningPlatform/money"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	orderPending   = "pending"
+	orderPaid      = "paid"
+	orderCancelled = "cancelled"
+	orderRefunded  = "refunded"
+)
+
+// orderTransitions lists the statuses each order status may move to.
+var orderTransitions = map[string][]string{
+	orderPending: {orderPaid, orderCancelled},
+	orderPaid:    {orderRefunded},
+}
+
+type Order struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	UserID   uint   `json:"user_id" gorm:"index;not null"`
+	Status   string `json:"status" gorm:"index;not null"`
+	Currency string `json:"currency" gorm:"size:3;not null"`
+	// TotalAmount is SubtotalAmount less DiscountAmount and is what the
+	// customer pays.
+	SubtotalAmount int64  `json:"-"`
+	DiscountAmount int64  `json:"-"`
+	TotalAmount    int64  `json:"-"`
+	CouponID       *uint  `json:"coupon_id,omitempty" gorm:"index"`
+	CouponCode     string `json:"coupon_code,omitempty"`
+	// SubscriptionID is set on orders paying for a subscription period.
+	SubscriptionID *uint       `json:"subscription_id,omitempty" gorm:"index"`
+	PaidAt         *time.Time  `json:"paid_at"`
+	CancelledAt    *time.Time  `json:"cancelled_at"`
+	RefundedAt     *time.Time  `json:"refunded_at"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+	Items          []OrderItem `json:"items,omitempty"`
+	Invoice        *Invoice    `json:"invoice,omitempty"`
+}
+
+// OrderItem snapshots the product name and price at purchase time, so later
+// product edits or deletion do not change past orders.
+type OrderItem struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	OrderID     uint   `json:"order_id" gorm:"index;not null"`
+	ProductID   *uint  `json:"product_id" gorm:"index"`
+	ProductName string `json:"product_name"`
+	UnitAmount  int64  `json:"-"`
+	Quantity    int    `json:"quantity"`
+	// DiscountAmount is the coupon discount on the whole line.
+	DiscountAmount int64 `json:"-"`
+}
+
+type orderItemView struct {
+	OrderItem
+	UnitPrice priceView  `json:"unit_price"`
+	Discount  *priceView `json:"discount,omitempty"`
+	Total     priceView  `json:"total"`
+}
+
+type orderView struct {
+	Order
+	Items    []orderItemView `json:"items,omitempty"`
+	Subtotal priceView       `json:"subtotal"`
+	Discount *priceView      `json:"discount,omitempty"`
+	Total    priceView       `json:"total"`
+}
+
+func newOrderView(o Order, locale string) orderView {
+	v := orderView{
+		Order:    o,
+		Subtotal: newPriceView(o.SubtotalAmount, o.Currency, locale),
+		Total:    newPriceView(o.TotalAmount, o.Currency, locale),
+	}
+	if o.DiscountAmount > 0 {
+		discount := newPriceView(o.DiscountAmount, o.Currency, locale)
+		v.Discount = &discount
+	}
+	for _, item := range o.Items {
+		iv := orderItemView{
+			OrderItem: item,
+			UnitPrice: newPriceView(item.UnitAmount, o.Currency, locale),
+			Total:     newPriceView(item.UnitAmount*int64(item.Quantity)-item.DiscountAmount, o.Currency, locale),
+		}
+		if item.DiscountAmount > 0 {
+			discount := newPriceView(item.DiscountAmount, o.Currency, locale)
+			iv.Discount = &discount
+		}
+		v.Items = append(v.Items, iv)
+	}
+	return v
+}
+
+func canTransitionOrder(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// buildOrder prices the cart items in currency. Every item must carry its
+// Product with Prices loaded.
+func buildOrder(userID uint, items []CartItem, currency string) (Order, []FieldError) {
+	order := Order{UserID: userID, Status: orderPending, Currency: currency}
+	var fields []FieldError
+	for _, item := range items {
+		if item.Product == nil {
+			continue
+		}
+		unit, ok := item.Product.priceIn(currency)
+		if !ok {
+			fields = append(fields, fieldError(fmt.Sprintf("cart.%d", item.ProductID), "invalid",
+				fmt.Sprintf("%s is not sold in %s", item.Product.Name, currency)))
+			continue
+		}
+		productID := item.ProductID
+		order.Items = append(order.Items, OrderItem{
+			ProductID:   &productID,
+			ProductName: item.Product.Name,
+			UnitAmount:  unit,
+			Quantity:    item.Quantity,
+		})
+		order.SubtotalAmount += unit * int64(item.Quantity)
+	}
+	order.TotalAmount = order.SubtotalAmount
+	if len(order.Items) == 0 && len(fields) == 0 {
+		fields = append(fields, fieldError("cart", "required", "cart is empty"))
+	}
+	return order, fields
+}
+
+// transitionOrder moves order to status if orderTransitions allows it and
+// grants or revokes the enrollments it pays for. The update is conditional
+// on the stored status, so concurrent changes lose with 409 instead of
+// overwriting each other. Paying requires order.Items to be loaded.
+func transitionOrder(tx *gorm.DB, order *Order, status string) error {
+	if !canTransitionOrder(order.Status, status) {
+		return newAPIError(http.StatusConflict, codeConflict,
+			fmt.Sprintf("A %s order cannot become %s", order.Status, status), nil)
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"status": status}
+	switch status {
+	case orderPaid:
+		updates["paid_at"] = now
+		order.PaidAt = &now
+	case orderCancelled:
+		updates["cancelled_at"] = now
+		order.CancelledAt = &now
+		if order.CouponID != nil {
+			if err := releaseCoupon(tx, order); err != nil {
+				return internalError(fmt.Errorf("error releasing coupon: %v", err))
+			}
+		}
+	case orderRefunded:
+		updates["refunded_at"] = now
+		order.RefundedAt = &now
+	}
+	result := tx.Model(&Order{}).Where("id = ? AND status = ?", order.ID, order.Status).Updates(updates)
+	if result.Error != nil {
+		return internalError(fmt.Errorf("error updating order: %v", result.Error))
+	}
+	if result.RowsAffected == 0 {
+		return newAPIError(http.StatusConflict, codeConflict, "The order was changed concurrently; reload and retry", nil)
+	}
+	order.Status = status
+
+	var err error
+	switch status {
+	case orderPaid:
+		err = grantEnrollments(tx, order)
+		if err == nil {
+			err = issueInvoice(tx, order)
+		}
+		if err == nil && order.SubscriptionID != nil {
+			err = startSubscriptionPeriod(tx, order)
+		}
+	case orderRefunded:
+		err = revokeEnrollments(tx, order)
+		if err == nil && order.SubscriptionID != nil {
+			err = endSubscription(tx, *order.SubscriptionID)
+		}
+	}
+	if err != nil {
+		return internalError(fmt.Errorf("error updating order access: %v", err))
+	}
+	return nil
+}
+
+func setOrderStatus(ctx context.Context, order *Order, status string) error {
+	return Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return transitionOrder(tx, order, status)
+	})
+}
+
+func loadOrder(w http.ResponseWriter, r *http.Request, action string) (*Order, bool) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, action, invalidParameter(err.Error()))
+		return nil, false
+	}
+
+	var order Order
+	if err := Db.WithContext(r.Context()).Preload("Items").First(&order, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, r, action, notFound("Order not found", err))
+			return nil, false
+		}
+		handleError(w, r, action, internalError(fmt.Errorf("error retrieving order: %v", err)))
+		return nil, false
+	}
+
+	if userID, _ := requestUserID(r); requestUserRole(r) != "admin" && order.UserID != userID {
+		handleError(w, r, action, notFound("Order not found", nil))
+		return nil, false
+	}
+	return &order, true
+}
+
+func writeOrder(w http.ResponseWriter, r *http.Request, status int, order Order) {
+	_, locale := displayPrefs(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newOrderView(order, locale))
+}
+
+type orderRequest struct {
+	Currency string `json:"currency" validate:"max=3"`
+	Coupon   string `json:"coupon" validate:"max=40"`
+}
+
+// priceCart builds an order from the user's cart, locking the cart items
+// and the coupon, if any, until tx ends.
+func priceCart(tx *gorm.DB, userID uint, req orderRequest) (Order, *Coupon, error) {
+	currency := strings.ToUpper(req.Currency)
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	if _, err := money.Lookup(currency); err != nil {
+		return Order{}, nil, validationFailed(fieldError("currency", "oneof", "currency must be a supported ISO 4217 code"))
+	}
+
+	var items []CartItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).Order("created_at, id").Find(&items).Error; err != nil {
+		return Order{}, nil, internalError(fmt.Errorf("error retrieving cart: %v", err))
+	}
+	ids := make([]uint, len(items))
+	for i, item := range items {
+		ids[i] = item.ProductID
+	}
+	var products []Product
+	if len(ids) > 0 {
+		if err := tx.Preload("Prices").Find(&products, ids).Error; err != nil {
+			return Order{}, nil, internalError(fmt.Errorf("error retrieving products: %v", err))
+		}
+	}
+	byID := make(map[uint]*Product, len(products))
+	for i := range products {
+		byID[products[i].ID] = &products[i]
+	}
+	for i := range items {
+		items[i].Product = byID[items[i].ProductID]
+	}
+
+	order, fields := buildOrder(userID, items, currency)
+	if len(fields) > 0 {
+		return order, nil, validationFailed(fields...)
+	}
+	if req.Coupon == "" {
+		return order, nil, nil
+	}
+	coupon, err := redeemCoupon(tx, &order, items, req.Coupon)
+	return order, coupon, err
+}
+
+// quoteOrder prices the caller's cart like createOrder, including the
+// coupon discount, without placing the order.
+func quoteOrder(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+
+	var req orderRequest
+	if !decodeAndValidate(w, r, "quoteOrder", &req) {
+		return
+	}
+
+	var order Order
+	err := Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		order, _, err = priceCart(tx, userID, req)
+		return err
+	})
+	if err != nil {
+		handleError(w, r, "quoteOrder", err)
+		return
+	}
+	writeOrder(w, r, http.StatusOK, order)
+}
+
+// createOrder turns the caller's cart into a pending order and empties the
+// cart. An order a coupon brings to zero has nothing to charge, so it is
+// paid on the spot, granting its enrollments and invoice.
+func createOrder(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+
+	var req orderRequest
+	if !decodeAndValidate(w, r, "createOrder", &req) {
+		return
+	}
+
+	var order Order
+	err := Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var coupon *Coupon
+		var err error
+		if order, coupon, err = priceCart(tx, userID, req); err != nil {
+			return err
+		}
+		if err := tx.Create(&order).Error; err != nil {
+			return internalError(fmt.Errorf("error creating order: %v", err))
+		}
+		if coupon != nil {
+			if err := recordCouponRedemption(tx, coupon, &order); err != nil {
+				return internalError(fmt.Errorf("error redeeming coupon: %v", err))
+			}
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&CartItem{}).Error; err != nil {
+			return internalError(fmt.Errorf("error clearing cart: %v", err))
+		}
+		if order.TotalAmount == 0 {
+			return transitionOrder(tx, &order, orderPaid)
+		}
+		return nil
+	})
+	if err != nil {
+		handleError(w, r, "createOrder", err)
+		return
+	}
+
+	writeOrder(w, r, http.StatusCreated, order)
+	logUserAction(r.Context(), "createOrder", "success", map[string]interface{}{"order_id": order.ID, "total": order.TotalAmount, "currency": order.Currency, "coupon": order.CouponCode})
+}
+
+// migrateOrderSubtotals fills the subtotal of orders placed before coupons.
+func migrateOrderSubtotals(db *gorm.DB) error {
+	return db.Model(&Order{}).Where("subtotal_amount = 0 AND discount_amount = 0").
+		UpdateColumn("subtotal_amount", gorm.Expr("total_amount")).Error
+}
+
+func getMyOrders(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+
+	var orders []Order
+	if err := Db.WithContext(r.Context()).Preload("Items").Preload("Invoice").Where("user_id = ?", userID).Order("created_at DESC, id DESC").Find(&orders).Error; err != nil {
+		handleError(w, r, "getMyOrders", internalError(fmt.Errorf("error retrieving orders: %v", err)))
+		return
+	}
+
+	_, locale := displayPrefs(r)
+	views := make([]orderView, len(orders))
+	for i, o := range orders {
+		views[i] = newOrderView(o, locale)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+func getOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := loadOrder(w, r, "getOrder")
+	if !ok {
+		return
+	}
+	writeOrder(w, r, http.StatusOK, *order)
+}
+
+// cancelOrder lets a customer cancel their own order while it is pending.
+func cancelOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := loadOrder(w, r, "cancelOrder")
+	if !ok {
+		return
+	}
+	if err := setOrderStatus(r.Context(), order, orderCancelled); err != nil {
+		handleError(w, r, "cancelOrder", err)
+		return
+	}
+
+	writeOrder(w, r, http.StatusOK, *order)
+	logUserAction(r.Context(), "cancelOrder", "success", map[string]interface{}{"order_id": order.ID})
+}
+
+func getAdminOrders(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	page := 1
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+		page = p
+	}
+
+	params := struct {
+		Status string `json:"status" validate:"oneof=pending paid cancelled refunded"`
+		UserID string `json:"user_id"`
+	}{
+		Status: r.URL.Query().Get("status"),
+		UserID: r.URL.Query().Get("user_id"),
+	}
+	if err := validateRequest(params); err != nil {
+		handleError(w, r, "getAdminOrders", err)
+		return
+	}
+
+	query := Db.WithContext(r.Context()).Model(&Order{})
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+	if params.UserID != "" {
+		id, err := strconv.ParseUint(params.UserID, 10, 64)
+		if err != nil {
+			handleError(w, r, "getAdminOrders", validationFailed(fieldError("user_id", "invalid", "user_id must be a user id")))
+			return
+		}
+		query = query.Where("user_id = ?", id)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		handleError(w, r, "getAdminOrders", internalError(fmt.Errorf("error counting orders: %v", err)))
+		return
+	}
+
+	var orders []Order
+	if err := query.Preload("Items").Preload("Invoice").Order("created_at DESC, id DESC").Limit(limit).Offset((page - 1) * limit).Find(&orders).Error; err != nil {
+		handleError(w, r, "getAdminOrders", internalError(fmt.Errorf("error retrieving orders: %v", err)))
+		return
+	}
+
+	_, locale := displayPrefs(r)
+	views := make([]orderView, len(orders))
+	for i, o := range orders {
+		views[i] = newOrderView(o, locale)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":     views,
+		"page":     page,
+		"per_page": limit,
+		"total":    total,
+	})
+}
+
+// updateOrder lets an admin move an order through orderTransitions. Refunds
+// go through refundOrder instead, so the customer is actually paid back.
+func updateOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := loadOrder(w, r, "updateOrder")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Status string `json:"status" validate:"required,oneof=pending paid cancelled refunded"`
+	}
+	if !decodeAndValidate(w, r, "updateOrder", &req) {
+		return
+	}
+	if req.Status == orderRefunded {
+		handleError(w, r, "updateOrder", newAPIError(http.StatusConflict, codeConflict,
+			"Refund the payment with POST /api/v1/admin/orders/{id}/refund", nil))
+		return
+	}
+	if err := setOrderStatus(r.Context(), order, req.Status); err != nil {
+		handleError(w, r, "updateOrder", err)
+		return
+	}
+
+	writeOrder(w, r, http.StatusOK, *order)
+	logUserAction(r.Context(), "updateOrder", "success", map[string]interface{}{"order_id": order.ID, "status": order.Status})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuildOrderSnapshotsPrices(t *testing.T) {
+	course := &Product{ID: 1, Name: "Spanish A1", PriceAmount: 1999, Currency: "USD", Prices: []ProductPrice{{Currency: "EUR", Amount: 1800}}}
+	book := &Product{ID: 2, Name: "Workbook", PriceAmount: 500, Currency: "USD"}
+	items := []CartItem{{ProductID: 1, Product: course, Quantity: 2}, {ProductID: 2, Product: book, Quantity: 1}}
+
+	order, fields := buildOrder(9, items, "USD")
+	if len(fields) > 0 {
+		t.Fatalf("Unexpected errors: %+v", fields)
+	}
+	if order.UserID != 9 || order.Status != orderPending || order.TotalAmount != 4498 || len(order.Items) != 2 {
+		t.Fatalf("Unexpected order %+v", order)
+	}
+	if item := order.Items[0]; item.ProductName != "Spanish A1" || item.UnitAmount != 1999 || *item.ProductID != 1 {
+		t.Errorf("Unexpected snapshot %+v", item)
+	}
+
+	course.Name, course.PriceAmount = "Renamed", 1
+	if order.Items[0].ProductName != "Spanish A1" || order.Items[0].UnitAmount != 1999 {
+		t.Errorf("Order item changed with the product: %+v", order.Items[0])
+	}
+
+	if _, fields := buildOrder(9, items, "EUR"); len(fields) != 1 || fields[0].Field != "cart.2" {
+		t.Errorf("Expected the workbook to be rejected in EUR, got %+v", fields)
+	}
+	if _, fields := buildOrder(9, nil, "USD"); len(fields) != 1 || fields[0].Field != "cart" {
+		t.Errorf("Expected an empty cart error, got %+v", fields)
+	}
+}
+
+func TestOrderTransitions(t *testing.T) {
+	cases := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{orderPending, orderPaid, true},
+		{orderPending, orderCancelled, true},
+		{orderPaid, orderRefunded, true},
+		{orderPaid, orderCancelled, false},
+		{orderPending, orderRefunded, false},
+		{orderCancelled, orderPaid, false},
+		{orderRefunded, orderPending, false},
+	}
+	for _, c := range cases {
+		if got := canTransitionOrder(c.from, c.to); got != c.allowed {
+			t.Errorf("canTransitionOrder(%s, %s) = %v", c.from, c.to, got)
+		}
+	}
+}
+
+func TestCartAndOrderViews(t *testing.T) {
+	priced := &Product{ID: 1, Name: "Course", PriceAmount: 1250, Currency: "USD"}
+	unpriced := &Product{ID: 2, Name: "Local", PriceAmount: 100000, Currency: "KZT"}
+	cart := newCartView([]CartItem{{ProductID: 1, Product: priced, Quantity: 3}, {ProductID: 2, Product: unpriced, Quantity: 1}}, "USD", "en")
+	if cart.Total.Formatted != "$37.50" || cart.Items[0].Total == nil || cart.Items[1].Total != nil {
+		t.Errorf("Unexpected cart %+v", cart)
+	}
+
+	id := uint(1)
+	v := newOrderView(Order{Currency: "USD", TotalAmount: 3750, Items: []OrderItem{{ProductID: &id, UnitAmount: 1250, Quantity: 3}}}, "en")
+	if v.Total.Formatted != "$37.50" || v.Items[0].UnitPrice.Formatted != "$12.50" || v.Items[0].Total.Amount != 3750 {
+		t.Errorf("Unexpected order view %+v", v)
+	}
+}
+
+func TestRegisterAPIRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	registerAPIRoutes(mux)
+	if _, pattern := mux.Handler(httptest.NewRequest(http.MethodPost, "/api/v1/orders/5/cancel", nil)); pattern != "POST /api/v1/orders/{id}/cancel" {
+		t.Errorf("Unexpected pattern %q", pattern)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"LanguageLearningPlatform/mailer"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxDead    = "dead"
+
+	outboxBatchSize   = 20
+	outboxMaxAttempts = 8
+	outboxBaseBackoff = 30 * time.Second
+	outboxMaxBackoff  = time.Hour
+	outboxLease       = 5 * time.Minute
+)
+
+// OutboxMessage is an email queued in the same transaction as the change
+// that caused it and delivered later by runOutboxWorker.
+type OutboxMessage struct {
+	ID            uint           `json:"id" gorm:"primaryKey"`
+	Message       mailer.Message `json:"message" gorm:"type:jsonb;serializer:json"`
+	Status        string         `json:"status" gorm:"index:idx_outbox_due,priority:1;not null"`
+	Attempts      int            `json:"attempts"`
+	LastError     string         `json:"last_error"`
+	NextAttemptAt time.Time      `json:"next_attempt_at" gorm:"index:idx_outbox_due,priority:2"`
+	SentAt        *time.Time     `json:"sent_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+func enqueueEmail(tx *gorm.DB, msg *mailer.Message) error {
+	if msg.From == "" {
+		msg.From = mailFrom
+	}
+	entry := OutboxMessage{Message: *msg, Status: outboxPending, NextAttemptAt: time.Now()}
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to enqueue email: %v", err)
+	}
+	return nil
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxBaseBackoff
+	for i := 1; i < attempts && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxBackoff {
+		delay = outboxMaxBackoff
+	}
+	return delay
+}
+
+func runOutboxWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := processOutbox(ctx); err != nil {
+			logger.WithError(err).Error("Outbox processing failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processOutbox delivers one batch of due messages. Sending happens outside
+// any transaction: the batch is leased first and each result is recorded on
+// its own, so a slow SMTP server never holds row locks or a connection.
+func processOutbox(ctx context.Context) (int, error) {
+	due, err := claimOutbox(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for i := range due {
+		entry := &due[i]
+		sendErr := deliver(ctx, "email.outbox", &entry.Message)
+		if err := recordOutboxResult(ctx, entry, sendErr); err != nil {
+			return processed, err
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+// claimOutbox leases a batch of due messages by counting the attempt and
+// pushing next_attempt_at past outboxLease. Rows are locked with SKIP LOCKED
+// only while the lease is taken, so several instances can run the worker
+// without sending twice; a worker that dies mid-batch leaves its messages to
+// be picked up again once the lease runs out.
+func claimOutbox(ctx context.Context) ([]OutboxMessage, error) {
+	var due []OutboxMessage
+	err := Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", outboxPending, now).
+			Order("next_attempt_at").Limit(outboxBatchSize).Find(&due).Error
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(due))
+		for i := range due {
+			ids[i] = due[i].ID
+			due[i].Attempts++
+		}
+		return tx.Model(&OutboxMessage{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": now.Add(outboxLease),
+		}).Error
+	})
+	return due, err
+}
+
+// recordOutboxResult stores the outcome of one delivery. The attempts check
+// skips the write if the lease expired and another worker claimed the row.
+func recordOutboxResult(ctx context.Context, entry *OutboxMessage, sendErr error) error {
+	updates := map[string]interface{}{}
+	if sendErr != nil {
+		entry.LastError = sendErr.Error()
+		if entry.Attempts >= outboxMaxAttempts {
+			entry.Status = outboxDead
+		} else {
+			entry.NextAttemptAt = time.Now().Add(outboxBackoff(entry.Attempts))
+		}
+		updates["status"] = entry.Status
+		updates["last_error"] = entry.LastError
+		updates["next_attempt_at"] = entry.NextAttemptAt
+		logger.WithFields(logrus.Fields{
+			"outbox_id": entry.ID,
+			"attempts":  entry.Attempts,
+			"status":    entry.Status,
+		}).WithError(sendErr).Warn("Outbox delivery failed")
+	} else {
+		now := time.Now()
+		entry.Status = outboxSent
+		entry.SentAt = &now
+		entry.LastError = ""
+		updates["status"] = entry.Status
+		updates["sent_at"] = now
+		updates["last_error"] = ""
+	}
+
+	err := Db.WithContext(ctx).Model(&OutboxMessage{}).
+		Where("id = ? AND attempts = ?", entry.ID, entry.Attempts).Updates(updates).Error
+	if err != nil {
+		return fmt.Errorf("failed to record outbox message %d: %v", entry.ID, err)
+	}
+	return nil
+}
+
+func getOutbox(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	page := 1
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+		page = p
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = outboxDead
+	}
+	if status != outboxPending && status != outboxSent && status != outboxDead {
+		handleError(w, r, "getOutbox", invalidParameter("Query parameter status must be one of pending, sent, dead"))
+		return
+	}
+
+	query := Db.WithContext(r.Context()).Model(&OutboxMessage{}).Where("status = ?", status).Session(&gorm.Session{})
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		handleError(w, r, "getOutbox", internalError(fmt.Errorf("error counting outbox messages: %v", err)))
+		return
+	}
+
+	var messages []OutboxMessage
+	if err := query.Order("id DESC").Limit(limit).Offset((page - 1) * limit).Find(&messages).Error; err != nil {
+		handleError(w, r, "getOutbox", internalError(fmt.Errorf("error retrieving outbox messages: %v", err)))
+		return
+	}
+	for i := range messages {
+		for j := range messages[i].Message.Attachments {
+			messages[i].Message.Attachments[j].Data = nil
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":     messages,
+		"page":     page,
+		"per_page": limit,
+		"total":    total,
+	})
+}
+
+// retryOutboxMessage requeues a dead message. Pending messages may be
+// leased by a worker, so they are left alone.
+func retryOutboxMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "retryOutbox", invalidParameter(err.Error()))
+		return
+	}
+
+	result := Db.WithContext(r.Context()).Model(&OutboxMessage{}).
+		Where("id = ? AND status = ?", id, outboxDead).
+		Updates(map[string]interface{}{
+			"status":          outboxPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if result.Error != nil {
+		handleError(w, r, "retryOutbox", internalError(fmt.Errorf("error rescheduling outbox message: %v", result.Error)))
+		return
+	}
+
+	var entry OutboxMessage
+	if err := Db.WithContext(r.Context()).First(&entry, id).Error; err != nil {
+		handleError(w, r, "retryOutbox", notFound("Outbox message not found", err))
+		return
+	}
+	if result.RowsAffected == 0 {
+		handleError(w, r, "retryOutbox", newAPIError(http.StatusConflict, codeConflict,
+			fmt.Sprintf("Only dead messages can be retried; this one is %s", entry.Status), nil))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+	logUserAction(r.Context(), "retryOutbox", "success", map[string]interface{}{"outbox_id": entry.ID})
+}
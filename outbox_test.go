@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		8:  time.Hour,
+		20: time.Hour,
+	}
+	for attempts, expected := range cases {
+		if got := outboxBackoff(attempts); got != expected {
+			t.Errorf("outboxBackoff(%d) = %v, expected %v", attempts, got, expected)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"LanguageLearningPlatform/payments"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	paymentPending   = "pending"
+	paymentSucceeded = "succeeded"
+	paymentFailed    = "failed"
+	paymentRefunded  = "refunded"
+
+	maxWebhookBytes  = 1 << 20
+	fakeCheckoutPath = "/payments/fake"
+)
+
+var paymentProvider payments.Provider
+
+// Payment is one checkout attempt for an order. An order may have several
+// failed or abandoned attempts before one succeeds.
+type Payment struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	OrderID     uint      `json:"order_id" gorm:"index;not null"`
+	Provider    string    `json:"provider" gorm:"not null"`
+	SessionID   string    `json:"-" gorm:"index"`
+	PaymentID   string    `json:"-" gorm:"index"`
+	RefundID    string    `json:"-"`
+	Status      string    `json:"status" gorm:"index;not null"`
+	Amount      int64     `json:"amount"`
+	Currency    string    `json:"currency" gorm:"size:3"`
+	CheckoutURL string    `json:"checkout_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// PaymentEvent records every processed webhook so redeliveries are
+// acknowledged without being applied twice.
+type PaymentEvent struct {
+	ID        uint   `gorm:"primaryKey"`
+	Provider  string `gorm:"uniqueIndex:idx_payment_events_event;not null"`
+	EventID   string `gorm:"uniqueIndex:idx_payment_events_event;not null"`
+	Type      string
+	CreatedAt time.Time
+}
+
+// initPayments selects the gateway from PAYMENT_PROVIDER, which must be set:
+// "stripe", or "fake", which takes payments on a local page without
+// charging anyone and is therefore refused unless PAYMENT_FAKE_ENABLED=true
+// marks a development or test deployment.
+func initPayments() error {
+	switch provider := os.Getenv("PAYMENT_PROVIDER"); provider {
+	case "":
+		return errors.New("PAYMENT_PROVIDER is required")
+	case "stripe":
+		key, secret := os.Getenv("STRIPE_SECRET_KEY"), os.Getenv("STRIPE_WEBHOOK_SECRET")
+		if key == "" || secret == "" {
+			return errors.New("STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET are required")
+		}
+		paymentProvider = &payments.Stripe{
+			BaseURL:       os.Getenv("STRIPE_API_URL"),
+			SecretKey:     key,
+			WebhookSecret: secret,
+		}
+	case "fake":
+		if os.Getenv("PAYMENT_FAKE_ENABLED") != "true" {
+			return errors.New("the fake payment provider requires PAYMENT_FAKE_ENABLED=true")
+		}
+		secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+		if secret == "" {
+			b := make([]byte, 16)
+			rand.Read(b)
+			secret = hex.EncodeToString(b)
+		}
+		paymentProvider = &payments.Fake{BaseURL: appBaseURL + fakeCheckoutPath, Secret: secret}
+	default:
+		return fmt.Errorf("unknown PAYMENT_PROVIDER %q", provider)
+	}
+	return nil
+}
+
+// registerFakeCheckout mounts the fake gateway's payment page, which only
+// exists while the fake provider is active.
+func registerFakeCheckout(mux *http.ServeMux) {
+	if _, ok := paymentProvider.(*payments.Fake); ok {
+		mux.HandleFunc(fakeCheckoutPath+"/{session}", fakeCheckout)
+	}
+}
+
+func paymentUnavailable(err error) *apiError {
+	return newAPIError(http.StatusBadGateway, codePaymentProvider, "The payment provider could not process the request. Please try again.", err)
+}
+
+// checkoutOrder starts a payment for a pending order and returns the
+// provider's checkout URL.
+func checkoutOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := loadOrder(w, r, "checkoutOrder")
+	if !ok {
+		return
+	}
+	if order.Status != orderPending {
+		handleError(w, r, "checkoutOrder", newAPIError(http.StatusConflict, codeConflict, fmt.Sprintf("A %s order cannot be paid", order.Status), nil))
+		return
+	}
+	if order.TotalAmount == 0 {
+		handleError(w, r, "checkoutOrder", newAPIError(http.StatusConflict, codeConflict, "The order has nothing to pay", nil))
+		return
+	}
+
+	payment := Payment{
+		OrderID:  order.ID,
+		Provider: paymentProvider.Name(),
+		Status:   paymentPending,
+		Amount:   order.TotalAmount,
+		Currency: order.Currency,
+	}
+	if err := Db.WithContext(r.Context()).Create(&payment).Error; err != nil {
+		handleError(w, r, "checkoutOrder", internalError(fmt.Errorf("error creating payment: %v", err)))
+		return
+	}
+
+	var user User
+	Db.WithContext(r.Context()).Select("email").First(&user, order.UserID)
+	returnURL := fmt.Sprintf("%s/profilePage?order=%d", appBaseURL, order.ID)
+	session, err := paymentProvider.CreateCheckoutSession(r.Context(), payments.CheckoutRequest{
+		Reference:      fmt.Sprintf("order-%d", order.ID),
+		Description:    fmt.Sprintf("Order #%d", order.ID),
+		Amount:         order.TotalAmount,
+		Currency:       order.Currency,
+		Email:          user.Email,
+		SuccessURL:     returnURL + "&payment=success",
+		CancelURL:      returnURL + "&payment=cancelled",
+		IdempotencyKey: fmt.Sprintf("payment-%d", payment.ID),
+	})
+	if err != nil {
+		Db.WithContext(r.Context()).Model(&payment).Update("status", paymentFailed)
+		handleError(w, r, "checkoutOrder", paymentUnavailable(err))
+		return
+	}
+
+	payment.SessionID = session.ID
+	payment.CheckoutURL = session.URL
+	if err := Db.WithContext(r.Context()).Select("session_id", "checkout_url").Save(&payment).Error; err != nil {
+		handleError(w, r, "checkoutOrder", internalError(fmt.Errorf("error saving payment: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(payment)
+	logUserAction(r.Context(), "checkoutOrder", "success", map[string]interface{}{"order_id": order.ID, "payment_id": payment.ID})
+}
+
+// receivePaymentWebhook applies a signed provider event. Failures answer 5xx
+// so the provider redelivers; the event ledger rolls back with them.
+func receivePaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	if err != nil {
+		handleError(w, r, "receivePaymentWebhook", invalidParameter("webhook body is too large or unreadable"))
+		return
+	}
+	event, err := paymentProvider.VerifyWebhook(payload, r.Header)
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		handleError(w, r, "receivePaymentWebhook", forbidden("Invalid webhook signature"))
+		return
+	}
+	if err != nil {
+		handleError(w, r, "receivePaymentWebhook", invalidParameter(err.Error()))
+		return
+	}
+
+	duplicate, err := processPaymentEvent(r.Context(), paymentProvider.Name(), event)
+	if err != nil {
+		handleError(w, r, "receivePaymentWebhook", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"received": true, "duplicate": duplicate})
+	logUserAction(r.Context(), "receivePaymentWebhook", "success", map[string]interface{}{
+		"event_id":  event.ID,
+		"type":      event.Type,
+		"duplicate": duplicate,
+	})
+}
+
+// processPaymentEvent applies event once per provider event ID and reports
+// whether it had already been processed.
+func processPaymentEvent(ctx context.Context, provider string, event *payments.Event) (bool, error) {
+	if event.ID == "" {
+		return false, invalidParameter("webhook event has no id")
+	}
+
+	duplicate := false
+	err := Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&PaymentEvent{Provider: provider, EventID: event.ID, Type: event.Type})
+		if result.Error != nil {
+			return internalError(fmt.Errorf("error recording payment event: %v", result.Error))
+		}
+		if result.RowsAffected == 0 {
+			duplicate = true
+			return nil
+		}
+
+		if event.Type == "" {
+			return nil
+		}
+		payment, err := findEventPayment(tx, provider, event)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.WithField("event_id", event.ID).Warn("Payment event does not match any payment")
+			return nil
+		}
+		if err != nil {
+			return internalError(fmt.Errorf("error retrieving payment: %v", err))
+		}
+
+		switch event.Type {
+		case payments.EventPaymentSucceeded:
+			return applyPaymentSucceeded(tx, payment, event)
+		case payments.EventPaymentFailed:
+			if payment.Status != paymentPending {
+				return nil
+			}
+			return tx.Model(payment).Update("status", paymentFailed).Error
+		case payments.EventPaymentRefunded:
+			return applyPaymentRefunded(tx, payment, event)
+		}
+		return nil
+	})
+	return duplicate, err
+}
+
+func findEventPayment(tx *gorm.DB, provider string, event *payments.Event) (*Payment, error) {
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("provider = ?", provider)
+	switch {
+	case event.SessionID != "":
+		query = query.Where("session_id = ?", event.SessionID)
+	case event.PaymentID != "":
+		query = query.Where("payment_id = ?", event.PaymentID)
+	default:
+		return nil, gorm.ErrRecordNotFound
+	}
+	var payment Payment
+	if err := query.Order("id DESC").First(&payment).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func lockOrder(tx *gorm.DB, id uint) (*Order, error) {
+	var order Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, id).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("order_id = ?", id).Find(&order.Items).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// applyPaymentSucceeded marks the order paid unless the amount charged
+// differs from the order or the order is no longer pending; those cases are
+// logged for manual review rather than failing the webhook.
+func applyPaymentSucceeded(tx *gorm.DB, payment *Payment, event *payments.Event) error {
+	if payment.Status == paymentSucceeded || payment.Status == paymentRefunded {
+		return nil
+	}
+	payment.Status = paymentSucceeded
+	if event.PaymentID != "" {
+		payment.PaymentID = event.PaymentID
+	}
+	if err := tx.Select("status", "payment_id").Save(payment).Error; err != nil {
+		return internalError(fmt.Errorf("error updating payment: %v", err))
+	}
+
+	log := logger.WithField("order_id", payment.OrderID).WithField("payment_id", payment.ID)
+	if event.Amount != payment.Amount || event.Currency != payment.Currency {
+		log.Errorf("Payment amount %d %s does not match %d %s", event.Amount, event.Currency, payment.Amount, payment.Currency)
+		return nil
+	}
+	order, err := lockOrder(tx, payment.OrderID)
+	if err != nil {
+		return internalError(fmt.Errorf("error retrieving order: %v", err))
+	}
+	switch order.Status {
+	case orderPaid:
+		return nil
+	case orderPending:
+		return transitionOrder(tx, order, orderPaid)
+	default:
+		log.Errorf("Payment succeeded for a %s order; refund it manually", order.Status)
+		return nil
+	}
+}
+
+func applyPaymentRefunded(tx *gorm.DB, payment *Payment, event *payments.Event) error {
+	if payment.Status == paymentRefunded {
+		return nil
+	}
+	if event.Amount < payment.Amount {
+		logger.WithField("payment_id", payment.ID).Warnf("Partial refund of %d recorded by the provider", event.Amount)
+		return nil
+	}
+	if err := tx.Model(payment).Update("status", paymentRefunded).Error; err != nil {
+		return internalError(fmt.Errorf("error updating payment: %v", err))
+	}
+	order, err := lockOrder(tx, payment.OrderID)
+	if err != nil {
+		return internalError(fmt.Errorf("error retrieving order: %v", err))
+	}
+	if order.Status != orderPaid {
+		return nil
+	}
+	return transitionOrder(tx, order, orderRefunded)
+}
+
+// refundOrder refunds the successful payment of a paid order in full and
+// revokes the enrollments it granted.
+func refundOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := loadOrder(w, r, "refundOrder")
+	if !ok {
+		return
+	}
+	if order.Status != orderPaid {
+		handleError(w, r, "refundOrder", newAPIError(http.StatusConflict, codeConflict, fmt.Sprintf("A %s order cannot be refunded", order.Status), nil))
+		return
+	}
+
+	var payment Payment
+	err := Db.WithContext(r.Context()).Where("order_id = ? AND status = ?", order.ID, paymentSucceeded).Order("id DESC").First(&payment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		handleError(w, r, "refundOrder", newAPIError(http.StatusConflict, codeConflict, "The order was not paid through the payment provider; mark it refunded instead", nil))
+		return
+	}
+	if err != nil {
+		handleError(w, r, "refundOrder", internalError(fmt.Errorf("error retrieving payment: %v", err)))
+		return
+	}
+	if payment.Provider != paymentProvider.Name() {
+		handleError(w, r, "refundOrder", newAPIError(http.StatusConflict, codeConflict, fmt.Sprintf("The order was paid through %s, which is not configured", payment.Provider), nil))
+		return
+	}
+
+	refund, err := paymentProvider.Refund(r.Context(), payments.RefundRequest{
+		PaymentID:      payment.PaymentID,
+		IdempotencyKey: fmt.Sprintf("refund-payment-%d", payment.ID),
+	})
+	if err != nil {
+		handleError(w, r, "refundOrder", paymentUnavailable(err))
+		return
+	}
+
+	err = Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&payment).Where("status = ?", paymentSucceeded).Updates(map[string]interface{}{"status": paymentRefunded, "refund_id": refund.ID})
+		if result.Error != nil {
+			return internalError(fmt.Errorf("error updating payment: %v", result.Error))
+		}
+		return transitionOrder(tx, order, orderRefunded)
+	})
+	if err != nil {
+		handleError(w, r, "refundOrder", err)
+		return
+	}
+
+	writeOrder(w, r, http.StatusOK, *order)
+	logUserAction(r.Context(), "refundOrder", "success", map[string]interface{}{"order_id": order.ID, "refund_id": refund.ID})
+}
+
+var fakeCheckoutTemplate = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="UTF-8"><title>Test payment</title></head>
+<body>
+    <h1>Test payment</h1>
+    <p>{{.Description}}: {{.Amount}}</p>
+    <p>No money is charged. This page stands in for the payment provider.</p>
+    <form method="post">
+        <button name="outcome" value="pay">Pay</button>
+        <button name="outcome" value="decline">Decline</button>
+    </form>
+</body>
+</html>`))
+
+// fakeCheckout is the checkout page of the fake gateway. Paying or declining
+// signs the matching webhook and processes it as if the provider sent it.
+func fakeCheckout(w http.ResponseWriter, r *http.Request) {
+	fake, ok := paymentProvider.(*payments.Fake)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	sessionID := r.PathValue("session")
+	checkout, ok := fake.Checkout(sessionID)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		_, locale := displayPrefs(r)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fakeCheckoutTemplate.Execute(w, map[string]string{
+			"Description": checkout.Description,
+			"Amount":      newPriceView(checkout.Amount, checkout.Currency, locale).Formatted,
+		})
+		return
+	}
+
+	complete, target := fake.Complete, checkout.SuccessURL
+	if r.FormValue("outcome") != "pay" {
+		complete, target = fake.Fail, checkout.CancelURL
+	}
+	payload, header, err := complete(sessionID)
+	if err == nil {
+		var event *payments.Event
+		if event, err = fake.VerifyWebhook(payload, header); err == nil {
+			_, err = processPaymentEvent(r.Context(), fake.Name(), event)
+		}
+	}
+	if err != nil {
+		handleError(w, r, "fakeCheckout", err)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
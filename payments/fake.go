@@ -0,0 +1,152 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FakeSignatureHeader carries the Sign signature of fake webhook deliveries.
+const FakeSignatureHeader = "Fake-Signature"
+
+// Fake is an in-memory gateway. Sessions are paid or failed by calling
+// Complete or Fail, which return a signed webhook delivery for the app to
+// process, so the whole flow runs without network access.
+type Fake struct {
+	// BaseURL prefixes checkout URLs as BaseURL + "/" + session ID.
+	BaseURL string
+	Secret  string
+	Now     func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*fakeSession
+	byKey    map[string]*Session
+	refunds  []Refund
+}
+
+type fakeSession struct {
+	req       CheckoutRequest
+	paymentID string
+	refunded  int64
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+	return time.Now()
+}
+
+func (f *Fake) CreateCheckoutSession(ctx context.Context, req CheckoutRequest) (*Session, error) {
+	if req.Amount <= 0 || req.Currency == "" {
+		return nil, fmt.Errorf("payments: invalid amount %d %s", req.Amount, req.Currency)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.byKey[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		return s, nil
+	}
+	if f.sessions == nil {
+		f.sessions = make(map[string]*fakeSession)
+		f.byKey = make(map[string]*Session)
+	}
+	s := &Session{ID: randomID("fcs_")}
+	s.URL = f.BaseURL + "/" + s.ID
+	f.sessions[s.ID] = &fakeSession{req: req}
+	if req.IdempotencyKey != "" {
+		f.byKey[req.IdempotencyKey] = s
+	}
+	return s, nil
+}
+
+// Checkout returns the request a session was created with.
+func (f *Fake) Checkout(sessionID string) (CheckoutRequest, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.sessions[sessionID]
+	if !ok {
+		return CheckoutRequest{}, false
+	}
+	return s.req, true
+}
+
+// Complete pays a session and returns the signed succeeded webhook.
+func (f *Fake) Complete(sessionID string) ([]byte, http.Header, error) {
+	f.mu.Lock()
+	s, ok := f.sessions[sessionID]
+	if ok && s.paymentID == "" {
+		s.paymentID = randomID("fpi_")
+	}
+	f.mu.Unlock()
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	return f.deliver(Event{Type: EventPaymentSucceeded, SessionID: sessionID, PaymentID: s.paymentID,
+		Reference: s.req.Reference, Amount: s.req.Amount, Currency: s.req.Currency})
+}
+
+// Fail declines a session and returns the signed failed webhook.
+func (f *Fake) Fail(sessionID string) ([]byte, http.Header, error) {
+	req, ok := f.Checkout(sessionID)
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	return f.deliver(Event{Type: EventPaymentFailed, SessionID: sessionID,
+		Reference: req.Reference, Amount: req.Amount, Currency: req.Currency})
+}
+
+func (f *Fake) deliver(e Event) ([]byte, http.Header, error) {
+	e.ID = randomID("fevt_")
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, Sign(f.Secret, payload, f.now()))
+	return payload, header, nil
+}
+
+func (f *Fake) VerifyWebhook(payload []byte, header http.Header) (*Event, error) {
+	if err := verifySignature(f.Secret, payload, header.Get(FakeSignatureHeader), f.now()); err != nil {
+		return nil, err
+	}
+	var e Event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("payments: malformed event: %v", err)
+	}
+	return &e, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, req RefundRequest) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.sessions {
+		if s.paymentID == "" || s.paymentID != req.PaymentID {
+			continue
+		}
+		amount := req.Amount
+		if amount == 0 {
+			amount = s.req.Amount - s.refunded
+		}
+		if amount <= 0 || s.refunded+amount > s.req.Amount {
+			return nil, fmt.Errorf("payments: refund of %d exceeds the remaining %d", amount, s.req.Amount-s.refunded)
+		}
+		s.refunded += amount
+		refund := Refund{ID: randomID("fre_"), Amount: amount}
+		f.refunds = append(f.refunds, refund)
+		return &refund, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (f *Fake) Refunds() []Refund {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Refund(nil), f.refunds...)
+}
@@ -0,0 +1,147 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	payload := []byte(`{"id":"evt_1"}`)
+	header := Sign("whsec", payload, now)
+
+	if err := verifySignature("whsec", payload, header, now.Add(time.Minute)); err != nil {
+		t.Fatalf("Expected a valid signature, got %v", err)
+	}
+	if err := verifySignature("whsec", payload, "t=1,v1=00,"+header[len("t=1700000000,"):]+",t=1700000000", now); err != nil {
+		t.Errorf("Expected any v1 value to match, got %v", err)
+	}
+
+	cases := map[string]func() error{
+		"tampered":   func() error { return verifySignature("whsec", []byte(`{"id":"evt_2"}`), header, now) },
+		"wrong key":  func() error { return verifySignature("other", payload, header, now) },
+		"stale":      func() error { return verifySignature("whsec", payload, header, now.Add(time.Hour)) },
+		"malformed":  func() error { return verifySignature("whsec", payload, "garbage", now) },
+		"no secret":  func() error { return verifySignature("", payload, header, now) },
+		"no v1 part": func() error { return verifySignature("whsec", payload, "t=1700000000", now) },
+	}
+	for name, verify := range cases {
+		if err := verify(); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", name, err)
+		}
+	}
+}
+
+func TestFakeCheckoutFlow(t *testing.T) {
+	f := &Fake{BaseURL: "http://localhost/pay", Secret: "s3cret"}
+	req := CheckoutRequest{Reference: "order-7", Amount: 1999, Currency: "USD", IdempotencyKey: "payment-1"}
+	session, err := f.CreateCheckoutSession(context.Background(), req)
+	if err != nil {
+		t.Fatalf("CreateCheckoutSession failed: %v", err)
+	}
+	if session.URL != "http://localhost/pay/"+session.ID {
+		t.Errorf("Unexpected checkout URL %s", session.URL)
+	}
+	if again, _ := f.CreateCheckoutSession(context.Background(), req); again.ID != session.ID {
+		t.Errorf("Expected the idempotency key to return the same session")
+	}
+
+	payload, header, err := f.Complete(session.ID)
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	event, err := f.VerifyWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("VerifyWebhook failed: %v", err)
+	}
+	if event.Type != EventPaymentSucceeded || event.SessionID != session.ID || event.Reference != "order-7" || event.Amount != 1999 || event.PaymentID == "" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	if _, err := (&Fake{Secret: "other"}).VerifyWebhook(payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected another secret to reject the delivery, got %v", err)
+	}
+
+	if _, err := f.Refund(context.Background(), RefundRequest{PaymentID: event.PaymentID, Amount: 500}); err != nil {
+		t.Fatalf("Partial refund failed: %v", err)
+	}
+	if _, err := f.Refund(context.Background(), RefundRequest{PaymentID: event.PaymentID, Amount: 1500}); err == nil {
+		t.Errorf("Expected refunding more than was paid to fail")
+	}
+	if refund, err := f.Refund(context.Background(), RefundRequest{PaymentID: event.PaymentID}); err != nil || refund.Amount != 1499 {
+		t.Errorf("Expected the remainder to be refunded, got %+v, %v", refund, err)
+	}
+	if _, err := f.Refund(context.Background(), RefundRequest{PaymentID: "unknown"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStripeCreateCheckoutSession(t *testing.T) {
+	var form url.Values
+	var key, user string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/checkout/sessions" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"type":"invalid_request_error","message":"Unrecognized request URL"}}`)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		form, _ = url.ParseQuery(string(body))
+		key = r.Header.Get("Idempotency-Key")
+		user, _, _ = r.BasicAuth()
+		fmt.Fprint(w, `{"id":"cs_test_1","url":"https://checkout.stripe.com/c/pay/cs_test_1"}`)
+	}))
+	defer server.Close()
+
+	s := &Stripe{BaseURL: server.URL, SecretKey: "sk_test"}
+	session, err := s.CreateCheckoutSession(context.Background(), CheckoutRequest{
+		Reference: "order-3", Description: "Order #3", Amount: 4500, Currency: "EUR", IdempotencyKey: "payment-9",
+	})
+	if err != nil {
+		t.Fatalf("CreateCheckoutSession failed: %v", err)
+	}
+	if session.ID != "cs_test_1" || user != "sk_test" || key != "payment-9" {
+		t.Errorf("Unexpected session %+v (auth %q, key %q)", session, user, key)
+	}
+	if form.Get("line_items[0][price_data][currency]") != "eur" || form.Get("line_items[0][price_data][unit_amount]") != "4500" || form.Get("client_reference_id") != "order-3" {
+		t.Errorf("Unexpected form %v", form)
+	}
+
+	if _, err := s.Refund(context.Background(), RefundRequest{PaymentID: "pi_1"}); err == nil {
+		t.Errorf("Expected an API error to be returned")
+	}
+}
+
+func TestStripeVerifyWebhook(t *testing.T) {
+	now := time.Now()
+	s := &Stripe{WebhookSecret: "whsec_test", Now: func() time.Time { return now }}
+	deliver := func(payload string) (*Event, error) {
+		header := http.Header{}
+		header.Set(StripeSignatureHeader, Sign("whsec_test", []byte(payload), now))
+		return s.VerifyWebhook([]byte(payload), header)
+	}
+
+	e, err := deliver(`{"id":"evt_1","type":"checkout.session.completed","data":{"object":{"id":"cs_1","payment_intent":"pi_1","payment_status":"paid","client_reference_id":"order-3","amount_total":4500,"currency":"eur"}}}`)
+	if err != nil {
+		t.Fatalf("VerifyWebhook failed: %v", err)
+	}
+	if e.Type != EventPaymentSucceeded || e.SessionID != "cs_1" || e.PaymentID != "pi_1" || e.Amount != 4500 || e.Currency != "EUR" || e.Reference != "order-3" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+
+	if e, _ := deliver(`{"id":"evt_2","type":"checkout.session.completed","data":{"object":{"id":"cs_1","payment_status":"unpaid"}}}`); e.Type != "" {
+		t.Errorf("Expected an unpaid session to be ignored, got %+v", e)
+	}
+	if e, _ := deliver(`{"id":"evt_3","type":"charge.refunded","data":{"object":{"id":"ch_1","payment_intent":"pi_1","amount_refunded":4500,"currency":"eur"}}}`); e.Type != EventPaymentRefunded || e.PaymentID != "pi_1" || e.Amount != 4500 {
+		t.Errorf("Unexpected refund event %+v", e)
+	}
+	if e, _ := deliver(`{"id":"evt_4","type":"customer.created","data":{"object":{"id":"cus_1"}}}`); e.ID != "evt_4" || e.Type != "" {
+		t.Errorf("Expected unrelated events to verify with no type, got %+v", e)
+	}
+}
@@ -0,0 +1,172 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const StripeSignatureHeader = "Stripe-Signature"
+
+// Stripe uses Checkout Sessions for payment and reports the outcome through
+// signed webhooks. BaseURL may point at a compatible mock server.
+type Stripe struct {
+	BaseURL       string
+	SecretKey     string
+	WebhookSecret string
+	Client        *http.Client
+	Now           func() time.Time
+}
+
+func (s *Stripe) Name() string { return "stripe" }
+
+func (s *Stripe) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Stripe) CreateCheckoutSession(ctx context.Context, req CheckoutRequest) (*Session, error) {
+	form := url.Values{
+		"mode":                {"payment"},
+		"success_url":         {req.SuccessURL},
+		"cancel_url":          {req.CancelURL},
+		"client_reference_id": {req.Reference},
+		"metadata[reference]": {req.Reference},
+		"payment_intent_data[metadata][reference]":      {req.Reference},
+		"line_items[0][quantity]":                       {"1"},
+		"line_items[0][price_data][currency]":           {strings.ToLower(req.Currency)},
+		"line_items[0][price_data][unit_amount]":        {strconv.FormatInt(req.Amount, 10)},
+		"line_items[0][price_data][product_data][name]": {req.Description},
+	}
+	if req.Email != "" {
+		form.Set("customer_email", req.Email)
+	}
+
+	var session struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := s.post(ctx, "/v1/checkout/sessions", form, req.IdempotencyKey, &session); err != nil {
+		return nil, err
+	}
+	return &Session{ID: session.ID, URL: session.URL}, nil
+}
+
+func (s *Stripe) Refund(ctx context.Context, req RefundRequest) (*Refund, error) {
+	form := url.Values{"payment_intent": {req.PaymentID}}
+	if req.Amount > 0 {
+		form.Set("amount", strconv.FormatInt(req.Amount, 10))
+	}
+	var refund struct {
+		ID     string `json:"id"`
+		Amount int64  `json:"amount"`
+	}
+	if err := s.post(ctx, "/v1/refunds", form, req.IdempotencyKey, &refund); err != nil {
+		return nil, err
+	}
+	return &Refund{ID: refund.ID, Amount: refund.Amount}, nil
+}
+
+func (s *Stripe) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	base := s.BaseURL
+	if base == "" {
+		base = "https://api.stripe.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(base, "/")+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.SecretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(body, &apiErr)
+		return fmt.Errorf("stripe: %s %s: %s (%s)", path, resp.Status, apiErr.Error.Message, apiErr.Error.Type)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// stripeEvent covers the fields of the checkout.session and charge objects
+// that VerifyWebhook reads.
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID                string            `json:"id"`
+			PaymentIntent     string            `json:"payment_intent"`
+			PaymentStatus     string            `json:"payment_status"`
+			ClientReferenceID string            `json:"client_reference_id"`
+			Metadata          map[string]string `json:"metadata"`
+			AmountTotal       int64             `json:"amount_total"`
+			AmountRefunded    int64             `json:"amount_refunded"`
+			Currency          string            `json:"currency"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+func (s *Stripe) VerifyWebhook(payload []byte, header http.Header) (*Event, error) {
+	if err := verifySignature(s.WebhookSecret, payload, header.Get(StripeSignatureHeader), s.now()); err != nil {
+		return nil, err
+	}
+	var se stripeEvent
+	if err := json.Unmarshal(payload, &se); err != nil {
+		return nil, fmt.Errorf("stripe: malformed event: %v", err)
+	}
+
+	obj := se.Data.Object
+	e := &Event{ID: se.ID, Currency: strings.ToUpper(obj.Currency)}
+	switch se.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		// Delayed methods complete the session before the money arrives.
+		if obj.PaymentStatus != "paid" {
+			return e, nil
+		}
+		e.Type = EventPaymentSucceeded
+	case "checkout.session.async_payment_failed", "checkout.session.expired":
+		e.Type = EventPaymentFailed
+	case "charge.refunded":
+		e.Type = EventPaymentRefunded
+		e.PaymentID = obj.PaymentIntent
+		e.Amount = obj.AmountRefunded
+		e.Reference = obj.Metadata["reference"]
+		return e, nil
+	default:
+		return e, nil
+	}
+	e.SessionID = obj.ID
+	e.PaymentID = obj.PaymentIntent
+	e.Reference = obj.ClientReferenceID
+	e.Amount = obj.AmountTotal
+	return e, nil
+}
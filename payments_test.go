@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"LanguageLearningPlatform/payments"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestInitPaymentsSelectsProvider(t *testing.T) {
+	t.Setenv("PAYMENT_PROVIDER", "stripe")
+	if err := initPayments(); err == nil {
+		t.Errorf("Expected stripe without keys to be rejected")
+	}
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test")
+	t.Setenv("STRIPE_WEBHOOK_SECRET", "whsec_test")
+	if err := initPayments(); err != nil || paymentProvider.Name() != "stripe" {
+		t.Errorf("Expected the stripe provider, got %v", err)
+	}
+
+	t.Setenv("PAYMENT_PROVIDER", "")
+	if err := initPayments(); err == nil {
+		t.Errorf("Expected a missing provider to be rejected")
+	}
+
+	t.Setenv("PAYMENT_PROVIDER", "fake")
+	if err := initPayments(); err == nil {
+		t.Errorf("Expected the fake provider to need PAYMENT_FAKE_ENABLED")
+	}
+	t.Setenv("PAYMENT_FAKE_ENABLED", "true")
+	if err := initPayments(); err != nil || paymentProvider.Name() != "fake" {
+		t.Errorf("Expected the fake provider, got %v", err)
+	}
+	if fake := paymentProvider.(*payments.Fake); fake.Secret == "" || !strings.HasSuffix(fake.BaseURL, fakeCheckoutPath) {
+		t.Errorf("Unexpected fake gateway %+v", fake)
+	}
+
+	t.Setenv("PAYMENT_PROVIDER", "paypal")
+	if err := initPayments(); err == nil {
+		t.Errorf("Expected an unknown provider to be rejected")
+	}
+}
+
+func TestFakeCheckoutOnlyMountedForFakeProvider(t *testing.T) {
+	paymentProvider = &payments.Stripe{}
+	mux := http.NewServeMux()
+	registerFakeCheckout(mux)
+	if _, pattern := mux.Handler(httptest.NewRequest(http.MethodGet, fakeCheckoutPath+"/cs_1", nil)); pattern != "" {
+		t.Errorf("Expected no fake checkout route with stripe, got %q", pattern)
+	}
+
+	paymentProvider = &payments.Fake{}
+	mux = http.NewServeMux()
+	registerFakeCheckout(mux)
+	if _, pattern := mux.Handler(httptest.NewRequest(http.MethodGet, fakeCheckoutPath+"/cs_1", nil)); pattern == "" {
+		t.Errorf("Expected the fake checkout route with the fake provider")
+	}
+}
+
+func TestPaymentWebhookRejectsBadSignature(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+	paymentProvider = &payments.Fake{Secret: "s3cret"}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/payments/webhook", strings.NewReader(`{"ID":"fevt_1","Type":"payment.succeeded"}`))
+	req.Header.Set(payments.FakeSignatureHeader, "t=1,v1=deadbeef")
+	rec := httptest.NewRecorder()
+	receivePaymentWebhook(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
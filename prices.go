@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"LanguageLearningPlatform/money"
+
+	"gorm.io/gorm"
+)
+
+// defaultCurrency is the base currency of products created without one and
+// of prices converted from the old float column. Set with DEFAULT_CURRENCY.
+var defaultCurrency = "USD"
+
+// ProductPrice is an explicit price of a product in a currency other than
+// its base currency.
+type ProductPrice struct {
+	ID        uint   `json:"-" gorm:"primaryKey"`
+	ProductID uint   `json:"-" gorm:"uniqueIndex:idx_product_prices_currency"`
+	Currency  string `json:"currency" gorm:"size:3;uniqueIndex:idx_product_prices_currency"`
+	Amount    int64  `json:"amount"`
+}
+
+// priceInCurrencySQL selects a product's price in the currency bound to both
+// placeholders: its price list entry, else its base price when the base
+// currency matches, else NULL.
+const priceInCurrencySQL = `COALESCE(
+	(SELECT pp.amount FROM product_prices pp WHERE pp.product_id = products.id AND pp.currency = ?),
+	CASE WHEN products.currency = ? THEN products.price_amount END)`
+
+// priceIn returns p's price in currency: its price list entry, else its base
+// price when the base currency matches. It mirrors priceInCurrencySQL.
+func (p *Product) priceIn(currency string) (int64, bool) {
+	for _, price := range p.Prices {
+		if price.Currency == currency {
+			return price.Amount, true
+		}
+	}
+	if p.Currency == currency {
+		return p.PriceAmount, true
+	}
+	return 0, false
+}
+
+type priceView struct {
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+	Decimal   string `json:"decimal"`
+	Formatted string `json:"formatted"`
+}
+
+func newPriceView(amount int64, currency, locale string) priceView {
+	return priceView{
+		Amount:    amount,
+		Currency:  currency,
+		Decimal:   money.String(amount, currency),
+		Formatted: money.Format(amount, currency, locale),
+	}
+}
+
+// productView is the API representation of a product. Price is in the
+// requested currency when the product has one, otherwise in its base
+// currency; Prices lists every currency, base first.
+type productView struct {
+	*Product
+	Price  priceView   `json:"price"`
+	Prices []priceView `json:"prices"`
+}
+
+func newProductView(p *Product, currency, locale string) productView {
+	v := productView{
+		Product: p,
+		Price:   newPriceView(p.PriceAmount, p.Currency, locale),
+		Prices:  []priceView{newPriceView(p.PriceAmount, p.Currency, locale)},
+	}
+	extra := append([]ProductPrice(nil), p.Prices...)
+	sort.Slice(extra, func(i, j int) bool { return extra[i].Currency < extra[j].Currency })
+	for _, price := range extra {
+		pv := newPriceView(price.Amount, price.Currency, locale)
+		v.Prices = append(v.Prices, pv)
+		if price.Currency == currency {
+			v.Price = pv
+		}
+	}
+	return v
+}
+
+// displayPrefs reads the ?currency= and ?locale= query parameters, falling
+// back to Accept-Language for the locale.
+func displayPrefs(r *http.Request) (string, string) {
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if _, err := money.Lookup(currency); err != nil {
+		currency = ""
+	}
+	return currency, resolveLocale(r.URL.Query().Get("locale"), r.Header.Get("Accept-Language"))
+}
+
+func writeProduct(w http.ResponseWriter, r *http.Request, status int, p *Product) {
+	currency, locale := displayPrefs(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newProductView(p, currency, locale))
+}
+
+// parsePrices converts the request's base price and price list into minor
+// units. The base currency defaults to defaultCurrency.
+func (req productRequest) parsePrices() (int64, string, []ProductPrice, []FieldError) {
+	var fields []FieldError
+	currency := strings.ToUpper(req.Currency)
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	if _, err := money.Lookup(currency); err != nil {
+		fields = append(fields, fieldError("currency", "oneof", "currency must be a supported ISO 4217 code"))
+	}
+
+	var amount int64
+	if req.Price == "" {
+		fields = append(fields, fieldError("price", "required", "price is required"))
+	} else if len(fields) == 0 {
+		fields = append(fields, checkAmount("price", string(req.Price), currency, &amount)...)
+	}
+
+	prices := make([]ProductPrice, 0, len(req.Prices))
+	for code := range req.Prices {
+		field := "prices." + code
+		upper := strings.ToUpper(code)
+		if _, err := money.Lookup(upper); err != nil {
+			fields = append(fields, fieldError(field, "oneof", code+" is not a supported currency"))
+			continue
+		}
+		if upper == currency {
+			fields = append(fields, fieldError(field, "invalid", "the base currency is set by price"))
+			continue
+		}
+		price := ProductPrice{Currency: upper}
+		fields = append(fields, checkAmount(field, string(req.Prices[code]), upper, &price.Amount)...)
+		prices = append(prices, price)
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Currency < prices[j].Currency })
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return amount, currency, prices, fields
+}
+
+func checkAmount(field, s, currency string, dst *int64) []FieldError {
+	amount, err := money.Parse(s, currency)
+	if err != nil {
+		return []FieldError{fieldError(field, "invalid", fmt.Sprintf("%s must be a decimal amount in %s", field, currency))}
+	}
+	if amount < 0 {
+		return []FieldError{fieldError(field, "min", field+" must not be negative")}
+	}
+	*dst = amount
+	return nil
+}
+
+func loadDefaultCurrency() error {
+	code := strings.ToUpper(os.Getenv("DEFAULT_CURRENCY"))
+	if code == "" {
+		return nil
+	}
+	if _, err := money.Lookup(code); err != nil {
+		return err
+	}
+	defaultCurrency = code
+	return nil
+}
+
+// migrateProductPrices converts the legacy float price column into minor
+// units of defaultCurrency and drops it. It runs after AutoMigrate has added
+// price_amount and currency.
+func migrateProductPrices(db *gorm.DB) error {
+	c, err := money.Lookup(defaultCurrency)
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE products SET currency = ? WHERE currency IS NULL OR currency = ''", c.Code).Error; err != nil {
+			return err
+		}
+		if !tx.Migrator().HasColumn(&Product{}, "price") {
+			return nil
+		}
+		scale := int64(math.Pow10(c.Digits))
+		if err := tx.Exec("UPDATE products SET price_amount = round(price::numeric * ?) WHERE price IS NOT NULL", scale).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&Product{}, "price")
+	})
+}
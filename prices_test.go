@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"LanguageLearningPlatform/money"
+)
+
+func TestParsePrices(t *testing.T) {
+	req := productRequest{Price: "12.50", Prices: map[string]money.Decimal{"eur": "11", "JPY": "1800"}}
+	amount, currency, prices, fields := req.parsePrices()
+	if len(fields) > 0 {
+		t.Fatalf("Unexpected errors: %+v", fields)
+	}
+	if amount != 1250 || currency != defaultCurrency || len(prices) != 2 {
+		t.Fatalf("Unexpected prices %d %s %+v", amount, currency, prices)
+	}
+	if prices[0] != (ProductPrice{Currency: "EUR", Amount: 1100}) || prices[1] != (ProductPrice{Currency: "JPY", Amount: 1800}) {
+		t.Errorf("Unexpected price list %+v", prices)
+	}
+
+	bad := productRequest{Price: "1.999", Currency: "USD", Prices: map[string]money.Decimal{"usd": "1", "XXX": "1", "JPY": "-5"}}
+	_, _, _, fields = bad.parsePrices()
+	codes := fieldCodes(validationFailed(fields...))
+	for field, code := range map[string]string{"price": "invalid", "prices.usd": "invalid", "prices.XXX": "oneof", "prices.JPY": "min"} {
+		if codes[field] != code {
+			t.Errorf("Expected %s for %s, got %v", code, field, codes)
+		}
+	}
+
+	if _, _, _, fields := (productRequest{}).parsePrices(); len(fields) != 1 || fields[0].Field != "price" {
+		t.Errorf("Expected price to be required, got %+v", fields)
+	}
+}
+
+func TestNewProductViewPicksCurrency(t *testing.T) {
+	p := &Product{PriceAmount: 123450, Currency: "USD", Prices: []ProductPrice{{Currency: "RUB", Amount: 9900000}}}
+
+	v := newProductView(p, "RUB", "ru")
+	if v.Price.Currency != "RUB" || v.Price.Formatted != "99 000,00 ₽" || v.Price.Decimal != "99000.00" {
+		t.Errorf("Unexpected price %+v", v.Price)
+	}
+	if len(v.Prices) != 2 || v.Prices[0].Currency != "USD" || v.Prices[0].Formatted != "1 234,50 $" {
+		t.Errorf("Unexpected price list %+v", v.Prices)
+	}
+
+	if v := newProductView(p, "EUR", "en"); v.Price.Currency != "USD" || v.Price.Formatted != "$1,234.50" {
+		t.Errorf("Expected the base price as fallback, got %+v", v.Price)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const (
+	codeInvalidJSON          = "invalid_json"
+	codeInvalidParameter     = "invalid_parameter"
+	codeValidationFailed     = "validation_failed"
+	codeAuthRequired         = "auth_required"
+	codeInvalidToken         = "invalid_token"
+	codeInvalidCredentials   = "invalid_credentials"
+	codeAccountNotConfirmed  = "account_not_confirmed"
+	codeForbidden            = "forbidden"
+	codeNotFound             = "not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeConflict             = "conflict"
+	codeRateLimited          = "rate_limited"
+	codePayloadTooLarge      = "payload_too_large"
+	codeUnsupportedMedia     = "unsupported_media_type"
+	codeUploadRejected       = "upload_rejected"
+	codePaymentProvider      = "payment_provider_error"
+	codeSubscriptionRequired = "subscription_required"
+	codeInternal             = "internal_error"
+)
+
+var problemTitles = map[string]string{
+	codeInvalidJSON:          "Malformed request body",
+	codeInvalidParameter:     "Invalid request parameter",
+	codeValidationFailed:     "Validation failed",
+	codeAuthRequired:         "Authentication required",
+	codeInvalidToken:         "Invalid or expired token",
+	codeInvalidCredentials:   "Invalid credentials",
+	codeAccountNotConfirmed:  "Account not confirmed",
+	codeForbidden:            "Access denied",
+	codeNotFound:             "Resource not found",
+	codeMethodNotAllowed:     "Method not allowed",
+	codeConflict:             "Conflict",
+	codeRateLimited:          "Too many requests",
+	codePayloadTooLarge:      "Payload too large",
+	codeUnsupportedMedia:     "Unsupported media type",
+	codeUploadRejected:       "Upload rejected",
+	codePaymentProvider:      "Payment provider error",
+	codeSubscriptionRequired: "Subscription required",
+	codeInternal:             "Internal server error",
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Problem is an RFC 9457 problem details document extended with a stable
+// machine-readable code, field errors and the request ID.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// apiError carries what the client is allowed to see; Err is the internal
+// cause and only ever reaches the logs.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *apiError) Unwrap() error {
+	return e.Err
+}
+
+func newAPIError(status int, code, message string, err error) *apiError {
+	return &apiError{Status: status, Code: code, Message: message, Err: err}
+}
+
+func invalidJSON(err error) *apiError {
+	return newAPIError(http.StatusBadRequest, codeInvalidJSON, "Request body is not valid JSON", err)
+}
+
+func invalidParameter(message string) *apiError {
+	return newAPIError(http.StatusBadRequest, codeInvalidParameter, message, nil)
+}
+
+func validationFailed(fields ...FieldError) *apiError {
+	e := newAPIError(http.StatusUnprocessableEntity, codeValidationFailed, "One or more fields are invalid", nil)
+	e.Fields = fields
+	return e
+}
+
+func fieldError(field, code, message string) FieldError {
+	return FieldError{Field: field, Code: code, Message: message}
+}
+
+func notFound(message string, err error) *apiError {
+	return newAPIError(http.StatusNotFound, codeNotFound, message, err)
+}
+
+func forbidden(message string) *apiError {
+	return newAPIError(http.StatusForbidden, codeForbidden, message, nil)
+}
+
+func internalError(err error) *apiError {
+	return newAPIError(http.StatusInternalServerError, codeInternal, "An unexpected error occurred. Please try again later.", err)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func requestID(r *http.Request) string {
+	if info := requestInfoFrom(r.Context()); info != nil {
+		return info.ID
+	}
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	id := newRequestID()
+	r.Header.Set("X-Request-ID", id)
+	return id
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, e *apiError) {
+	title, ok := problemTitles[e.Code]
+	if !ok {
+		title = http.StatusText(e.Status)
+	}
+	problem := Problem{
+		Type:      "/problems/" + e.Code,
+		Title:     title,
+		Status:    e.Status,
+		Detail:    e.Message,
+		Instance:  r.URL.Path,
+		Code:      e.Code,
+		RequestID: requestID(r),
+		Errors:    e.Fields,
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Request-ID", problem.RequestID)
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+func asAPIError(err error) *apiError {
+	var e *apiError
+	if errors.As(err, &e) {
+		return e
+	}
+	return internalError(err)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"LanguageLearningPlatform/imaging"
+	"LanguageLearningPlatform/storage"
+)
+
+// mediaPath serves public blobs. Only keys under mediaPrefixes are exposed so
+// private uploads such as ticket attachments stay behind their own routes.
+const mediaPath = "/media/"
+
+var (
+	mediaPrefixes = []string{"products/"}
+
+	// productImageSizes are the longest-edge limits of the stored renditions.
+	// "large" also backs Product.Image.
+	productImageSizes = map[string]int{
+		"thumb":  160,
+		"small":  320,
+		"medium": 640,
+		"large":  1280,
+	}
+)
+
+func mediaURL(key string) string {
+	return mediaPath + key
+}
+
+// productMediaKeys lists the stored blobs referenced by a product.
+func productMediaKeys(p *Product) []string {
+	var keys []string
+	for _, u := range p.Thumbnails {
+		if key, ok := strings.CutPrefix(u, mediaPath); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func discardMedia(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := blobs.Store.Delete(ctx, key); err != nil {
+			requestLogger(ctx).WithError(err).WithField("key", key).Warn("Failed to delete orphaned media")
+		}
+	}
+}
+
+// storeProductImage validates and scans the upload, then stores one
+// EXIF-free rendition per productImageSizes entry. It returns the URL of
+// each rendition by size name.
+func storeProductImage(ctx context.Context, productID uint, r io.Reader) (map[string]string, error) {
+	upload, err := blobs.Inspect(ctx, r, productImagePolicy)
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	defer upload.Close()
+
+	data, err := io.ReadAll(upload.Reader())
+	if err != nil {
+		return nil, internalError(err)
+	}
+	renditions, err := imaging.Renditions(data, productImageSizes)
+	if errors.Is(err, imaging.ErrUnsupported) {
+		return nil, newAPIError(http.StatusUnprocessableEntity, codeUploadRejected, "Image could not be decoded", err)
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	base := storage.NewKey("products", fmt.Sprint(productID))
+	urls := make(map[string]string, len(renditions))
+	var stored []string
+	for name, rendition := range renditions {
+		key := base + "-" + name + rendition.Ext
+		if err := blobs.Store.Put(ctx, key, bytes.NewReader(rendition.Data), int64(len(rendition.Data)), rendition.ContentType); err != nil {
+			discardMedia(ctx, stored)
+			return nil, internalError(fmt.Errorf("failed to store product image: %v", err))
+		}
+		stored = append(stored, key)
+		urls[name] = mediaURL(key)
+	}
+	return urls, nil
+}
+
+func uploadProductImage(w http.ResponseWriter, r *http.Request) {
+	product, ok := loadProduct(w, r, "uploadProductImage")
+	if !ok {
+		return
+	}
+	cleanup, err := parseUploadForm(w, r, productImagePolicy)
+	if err != nil {
+		handleError(w, r, "uploadProductImage", err)
+		return
+	}
+	defer cleanup()
+	if r.MultipartForm == nil || len(r.MultipartForm.File["image"]) == 0 {
+		handleError(w, r, "uploadProductImage", validationFailed(fieldError("image", "required", "image is required")))
+		return
+	}
+	file, err := r.MultipartForm.File["image"][0].Open()
+	if err != nil {
+		handleError(w, r, "uploadProductImage", newAPIError(http.StatusBadRequest, codeInvalidParameter, "Image could not be read", err))
+		return
+	}
+	defer file.Close()
+
+	urls, err := storeProductImage(r.Context(), product.ID, file)
+	if err != nil {
+		handleError(w, r, "uploadProductImage", err)
+		return
+	}
+
+	previous := productMediaKeys(product)
+	product.Thumbnails = urls
+	product.Image = urls["large"]
+	if err := Db.WithContext(r.Context()).Select("image", "thumbnails").Save(product).Error; err != nil {
+		discardMedia(r.Context(), productMediaKeys(product))
+		handleError(w, r, "uploadProductImage", internalError(fmt.Errorf("failed to update product image: %v", err)))
+		return
+	}
+	discardMedia(r.Context(), previous)
+
+	writeProduct(w, r, http.StatusOK, product)
+	logUserAction(r.Context(), "uploadProductImage", "success", map[string]interface{}{"product_id": product.ID})
+}
+
+func deleteProductImage(w http.ResponseWriter, r *http.Request) {
+	product, ok := loadProduct(w, r, "deleteProductImage")
+	if !ok {
+		return
+	}
+
+	previous := productMediaKeys(product)
+	product.Thumbnails = nil
+	product.Image = ""
+	if err := Db.WithContext(r.Context()).Select("image", "thumbnails").Save(product).Error; err != nil {
+		handleError(w, r, "deleteProductImage", internalError(fmt.Errorf("failed to remove product image: %v", err)))
+		return
+	}
+	discardMedia(r.Context(), previous)
+
+	w.WriteHeader(http.StatusNoContent)
+	logUserAction(r.Context(), "deleteProductImage", "success", map[string]interface{}{"product_id": product.ID})
+}
+
+// serveMedia serves public blobs. Keys embed a random component and are never
+// rewritten, so responses can be cached indefinitely.
+func serveMedia(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, mediaPath)
+	public := false
+	for _, prefix := range mediaPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			public = true
+		}
+	}
+	if !public || path.Clean(key) != key {
+		writeProblem(w, r, notFound("File not found", nil))
+		return
+	}
+
+	etag := `"` + path.Base(key) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	rc, err := blobs.Store.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeProblem(w, r, notFound("File not found", err))
+		return
+	}
+	if err != nil {
+		handleError(w, r, "serveMedia", internalError(err))
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(key)))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, rc)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"LanguageLearningPlatform/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestStoreProductImageWritesRenditions(t *testing.T) {
+	store := &storage.Memory{}
+	blobs = &storage.Service{Store: store}
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2000, 1000)))
+
+	urls, err := storeProductImage(context.Background(), 7, &buf)
+	if err != nil {
+		t.Fatalf("storeProductImage failed: %v", err)
+	}
+	if len(urls) != len(productImageSizes) {
+		t.Fatalf("Expected %d renditions, got %v", len(productImageSizes), urls)
+	}
+	for name, u := range urls {
+		if !strings.HasPrefix(u, mediaPath+"products/") || !strings.HasSuffix(u, "-7-"+name+".jpg") {
+			t.Errorf("Unexpected URL for %s: %s", name, u)
+		}
+		if _, err := store.Open(context.Background(), strings.TrimPrefix(u, mediaPath)); err != nil {
+			t.Errorf("Rendition %s was not stored: %v", name, err)
+		}
+	}
+
+	_, err = storeProductImage(context.Background(), 7, strings.NewReader("GIF89a not allowed"))
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for a GIF, got %v", err)
+	}
+}
+
+func TestServeMediaCachesPublicKeysOnly(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+	store := &storage.Memory{}
+	blobs = &storage.Service{Store: store}
+	store.Put(context.Background(), "products/2025/01/ab-1-thumb.jpg", strings.NewReader("jpeg"), 4, "image/jpeg")
+	store.Put(context.Background(), "tickets/2025/01/ab-secret.pdf", strings.NewReader("pdf"), 3, "application/pdf")
+
+	rec := httptest.NewRecorder()
+	serveMedia(rec, httptest.NewRequest(http.MethodGet, "/media/products/2025/01/ab-1-thumb.jpg", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "jpeg" {
+		t.Fatalf("Unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "image/jpeg" || !strings.Contains(rec.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("Unexpected headers: %v", rec.Header())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/media/products/2025/01/ab-1-thumb.jpg", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	serveMedia(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", rec.Code)
+	}
+
+	for _, p := range []string{"/media/tickets/2025/01/ab-secret.pdf", "/media/products/../tickets/2025/01/ab-secret.pdf"} {
+		rec = httptest.NewRecorder()
+		serveMedia(rec, httptest.NewRequest(http.MethodGet, p, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s, got %d", p, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"LanguageLearningPlatform/money"
+	"LanguageLearningPlatform/storage"
+
+	"gorm.io/gorm"
+)
+
+const (
+	maxProductImportBytes = 10 << 20
+	maxProductImportRows  = 5000
+)
+
+// productCSVColumns is the column order of CSV exports. Imports match
+// columns by header name, in any order; prices are "EUR=92.00;GBP=80.00",
+// tags are separated by semicolons and characteristics are a JSON object.
+var productCSVColumns = []string{"sku", "name", "description", "price", "currency", "prices", "category_id", "date", "image", "slug", "tags", "characteristics"}
+
+var requiredProductCSVColumns = []string{"sku", "name", "price", "date"}
+
+// errImportRolledBack aborts the import transaction after a dry run or a
+// failed row.
+var errImportRolledBack = errors.New("product import rolled back")
+
+// productImportRow is one decoded row. errs holds problems found while
+// parsing it, with unprefixed field names.
+type productImportRow struct {
+	req  productRequest
+	errs []FieldError
+}
+
+// productImportReport summarises an import. Errors are named
+// "rows.N.field", N counting data rows from 1.
+type productImportReport struct {
+	DryRun  bool         `json:"dry_run"`
+	Total   int          `json:"total"`
+	Created int          `json:"created"`
+	Updated int          `json:"updated"`
+	Failed  int          `json:"failed"`
+	Errors  []FieldError `json:"errors"`
+}
+
+func rowField(row int, field string) string {
+	return fmt.Sprintf("rows.%d.%s", row, field)
+}
+
+func prefixRowFields(row int, fields []FieldError) []FieldError {
+	prefixed := make([]FieldError, len(fields))
+	for i, f := range fields {
+		f.Field = rowField(row, f.Field)
+		prefixed[i] = f
+	}
+	return prefixed
+}
+
+// productFormat picks the import or export format from the format query
+// parameter, falling back to the Content-Type of an import.
+func productFormat(r *http.Request) (string, error) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/json":
+			format = "json"
+		}
+	}
+	if format != "csv" && format != "json" {
+		return "", invalidParameter("format must be csv or json")
+	}
+	return format, nil
+}
+
+func decodeProductImport(r io.Reader, format string) ([]productImportRow, error) {
+	if format == "csv" {
+		return parseProductCSV(r)
+	}
+
+	var reqs []productRequest
+	if err := json.NewDecoder(r).Decode(&reqs); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, invalidJSON(err)
+	}
+	if len(reqs) > maxProductImportRows {
+		return nil, invalidParameter(fmt.Sprintf("An import may contain at most %d products", maxProductImportRows))
+	}
+	rows := make([]productImportRow, len(reqs))
+	for i, req := range reqs {
+		rows[i].req = req
+	}
+	return rows, nil
+}
+
+func parseProductCSV(r io.Reader) ([]productImportRow, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, invalidParameter("CSV file is empty")
+	}
+	if err != nil {
+		return nil, csvError(err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !containsString(productCSVColumns, name) {
+			return nil, invalidParameter(fmt.Sprintf("Unknown CSV column %q", name))
+		}
+		if _, dup := columns[name]; dup {
+			return nil, invalidParameter(fmt.Sprintf("CSV column %q appears twice", name))
+		}
+		columns[name] = i
+	}
+	for _, name := range requiredProductCSVColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, invalidParameter("CSV header must include " + strings.Join(requiredProductCSVColumns, ", "))
+		}
+	}
+
+	var rows []productImportRow
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, csvError(err)
+		}
+		if len(rows) == maxProductImportRows {
+			return nil, invalidParameter(fmt.Sprintf("An import may contain at most %d products", maxProductImportRows))
+		}
+		cell := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		rows = append(rows, productRowFromCSV(cell))
+	}
+}
+
+func csvError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	return newAPIError(http.StatusBadRequest, codeInvalidParameter, "CSV file could not be parsed", err)
+}
+
+func productRowFromCSV(cell func(string) string) productImportRow {
+	row := productImportRow{req: productRequest{
+		SKU:         cell("sku"),
+		Name:        cell("name"),
+		Description: cell("description"),
+		Price:       money.Decimal(cell("price")),
+		Currency:    cell("currency"),
+		Date:        cell("date"),
+		Image:       cell("image"),
+		Slug:        cell("slug"),
+		Tags:        splitList(cell("tags")),
+	}}
+
+	if s := cell("prices"); s != "" {
+		row.req.Prices = map[string]money.Decimal{}
+		for _, pair := range splitList(s) {
+			code, amount, ok := strings.Cut(pair, "=")
+			if !ok {
+				row.errs = append(row.errs, fieldError("prices", "invalid", "prices must be CURRENCY=amount pairs separated by semicolons"))
+				break
+			}
+			row.req.Prices[strings.TrimSpace(code)] = money.Decimal(strings.TrimSpace(amount))
+		}
+	}
+	if s := cell("category_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || id == 0 {
+			row.errs = append(row.errs, fieldError("category_id", "invalid", "category_id must be a category id"))
+		} else {
+			categoryID := uint(id)
+			row.req.CategoryID = &categoryID
+		}
+	}
+	if s := cell("characteristics"); s != "" {
+		if err := json.Unmarshal([]byte(s), &row.req.Characteristics); err != nil {
+			row.errs = append(row.errs, fieldError("characteristics", "invalid", "characteristics must be a JSON object"))
+		}
+	}
+	return row
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImportRow validates a row the way createProduct validates a
+// request, and additionally requires a SKU to upsert by.
+func checkImportRow(ctx context.Context, req productRequest) ([]FieldError, error) {
+	var fields []FieldError
+	if req.SKU == "" {
+		fields = append(fields, fieldError("sku", "required", "sku is required"))
+	}
+	err := validateRequest(req)
+	if err == nil {
+		err = checkProductRequest(ctx, req)
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && len(apiErr.Fields) > 0 {
+		return append(fields, apiErr.Fields...), nil
+	}
+	return fields, err
+}
+
+// runProductImport creates or updates a product per row, matching existing
+// products by SKU, in a single transaction. The transaction is committed
+// only when every row succeeds and dryRun is false, so the report of a dry
+// run is exactly what a real import would do. It also returns the media
+// that committed updates orphaned.
+func runProductImport(ctx context.Context, rows []productImportRow, dryRun bool) (*productImportReport, []string, error) {
+	report := &productImportReport{DryRun: dryRun, Total: len(rows), Errors: []FieldError{}}
+	var orphaned []string
+	fail := func(n int, fields []FieldError) {
+		report.Failed++
+		report.Errors = append(report.Errors, prefixRowFields(n, fields)...)
+	}
+
+	err := Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		seen := make(map[string]int)
+		for i, row := range rows {
+			n := i + 1
+			fields := row.errs
+			if len(fields) == 0 {
+				checked, err := checkImportRow(ctx, row.req)
+				if err != nil {
+					return err
+				}
+				fields = checked
+			}
+			if first, ok := seen[row.req.SKU]; ok && row.req.SKU != "" {
+				fields = append(fields, fieldError("sku", "duplicate", fmt.Sprintf("sku is already used by row %d", first)))
+			} else {
+				seen[row.req.SKU] = n
+			}
+			if len(fields) > 0 {
+				fail(n, fields)
+				continue
+			}
+
+			var product Product
+			if err := tx.Where("sku = ?", row.req.SKU).Limit(1).Find(&product).Error; err != nil {
+				return err
+			}
+			created := product.ID == 0
+			previous := productMediaKeys(&product)
+			row.req.apply(&product)
+
+			// A savepoint per row keeps the transaction usable after a
+			// unique violation, so later rows are still checked.
+			savepoint := fmt.Sprintf("import_row_%d", n)
+			if err := tx.SavePoint(savepoint).Error; err != nil {
+				return err
+			}
+			if err := saveProductTx(tx, &product, row.req); err != nil {
+				if !errors.Is(err, gorm.ErrDuplicatedKey) {
+					return err
+				}
+				if err := tx.RollbackTo(savepoint).Error; err != nil {
+					return err
+				}
+				fail(n, []FieldError{fieldError("slug", "conflict", "another product already uses this slug")})
+				continue
+			}
+			if created {
+				report.Created++
+			} else {
+				report.Updated++
+			}
+			if product.Thumbnails == nil {
+				orphaned = append(orphaned, previous...)
+			}
+		}
+		if dryRun || report.Failed > 0 {
+			return errImportRolledBack
+		}
+		return nil
+	})
+	if errors.Is(err, errImportRolledBack) {
+		return report, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return report, orphaned, nil
+}
+
+// importProducts accepts a CSV or JSON product list. With dry_run=true
+// nothing is saved and the report lists what would happen; otherwise any
+// invalid row fails the whole import.
+func importProducts(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if s := r.URL.Query().Get("dry_run"); s != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(s); err != nil {
+			handleError(w, r, "importProducts", invalidParameter("dry_run must be true or false"))
+			return
+		}
+	}
+	format, err := productFormat(r)
+	if err != nil {
+		handleError(w, r, "importProducts", err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxProductImportBytes)
+	rows, err := decodeProductImport(r.Body, format)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		err = uploadError(storage.ErrTooLarge)
+	}
+	if err != nil {
+		handleError(w, r, "importProducts", err)
+		return
+	}
+
+	report, orphaned, err := runProductImport(r.Context(), rows, dryRun)
+	if err != nil {
+		handleError(w, r, "importProducts", internalError(fmt.Errorf("failed to import products: %v", err)))
+		return
+	}
+	if !dryRun && report.Failed > 0 {
+		e := validationFailed(report.Errors...)
+		e.Message = "Import failed; no products were changed"
+		handleError(w, r, "importProducts", e)
+		return
+	}
+	discardMedia(r.Context(), orphaned)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+	if !dryRun {
+		logUserAction(r.Context(), "importProducts", "success", map[string]interface{}{"created": report.Created, "updated": report.Updated})
+	}
+}
+
+// productImportRequest is the import row describing p, so an export can be
+// edited and imported again. Products without a SKU export with an empty
+// one and must be given one before they can be re-imported.
+func productImportRequest(p *Product) productRequest {
+	req := productRequest{
+		SKU:             p.SKU,
+		Name:            p.Name,
+		Description:     p.Description,
+		Price:           money.Decimal(money.String(p.PriceAmount, p.Currency)),
+		Currency:        p.Currency,
+		CategoryID:      p.CategoryID,
+		Characteristics: p.Characteristics,
+		Date:            p.Date.Format("2006-01-02"),
+		Image:           p.Image,
+		Slug:            p.Slug,
+		Tags:            []string{},
+	}
+	if len(p.Prices) > 0 {
+		req.Prices = make(map[string]money.Decimal, len(p.Prices))
+		for _, price := range p.Prices {
+			req.Prices[price.Currency] = money.Decimal(money.String(price.Amount, price.Currency))
+		}
+	}
+	for _, tag := range p.Tags {
+		req.Tags = append(req.Tags, tag.Name)
+	}
+	return req
+}
+
+func writeProductCSV(w io.Writer, reqs []productRequest) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(productCSVColumns); err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		currencies := make([]string, 0, len(req.Prices))
+		for code := range req.Prices {
+			currencies = append(currencies, code)
+		}
+		sort.Strings(currencies)
+		prices := make([]string, len(currencies))
+		for i, code := range currencies {
+			prices[i] = code + "=" + string(req.Prices[code])
+		}
+		categoryID := ""
+		if req.CategoryID != nil {
+			categoryID = strconv.FormatUint(uint64(*req.CategoryID), 10)
+		}
+		characteristics := ""
+		if len(req.Characteristics) > 0 {
+			data, err := json.Marshal(req.Characteristics)
+			if err != nil {
+				return err
+			}
+			characteristics = string(data)
+		}
+
+		err := cw.Write([]string{req.SKU, req.Name, req.Description, string(req.Price), req.Currency, strings.Join(prices, ";"),
+			categoryID, req.Date, req.Image, req.Slug, strings.Join(req.Tags, ";"), characteristics})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportProducts downloads every product in the import format.
+func exportProducts(w http.ResponseWriter, r *http.Request) {
+	format := "csv"
+	if r.URL.Query().Get("format") != "" {
+		var err error
+		if format, err = productFormat(r); err != nil {
+			handleError(w, r, "exportProducts", err)
+			return
+		}
+	}
+
+	var products []Product
+	if err := Db.WithContext(r.Context()).Preload("Tags").Preload("Prices").Order("id").Find(&products).Error; err != nil {
+		handleError(w, r, "exportProducts", internalError(fmt.Errorf("error retrieving products: %v", err)))
+		return
+	}
+	reqs := make([]productRequest, len(products))
+	for i := range products {
+		reqs[i] = productImportRequest(&products[i])
+	}
+
+	filename := fmt.Sprintf("products-%s.%s", time.Now().Format("2006-01-02"), format)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reqs)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	if err := writeProductCSV(w, reqs); err != nil {
+		requestLogger(r.Context()).WithError(err).Error("Failed to write product export")
+	}
+}
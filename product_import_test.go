@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProductCSV(t *testing.T) {
+	input := "\ufeffSKU,Name,Price,Date,Prices,Tags,Category_ID,Characteristics\n" +
+		`ES-A1,Spanish for Beginners,100.00,2025-01-01,EUR=92.00; GBP=80,Beginner;Speaking,3,"{""level"":""A1""}"` + "\n" +
+		"EN-B2,Business English,200,2025-01-02,EUR 92,,abc,{\n"
+	rows, err := parseProductCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseProductCSV failed: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+
+	req := rows[0].req
+	if len(rows[0].errs) != 0 || req.SKU != "ES-A1" || req.Price != "100.00" || *req.CategoryID != 3 || req.Characteristics["level"] != "A1" {
+		t.Errorf("Unexpected first row %+v: %v", req, rows[0].errs)
+	}
+	if req.Prices["EUR"] != "92.00" || req.Prices["GBP"] != "80" || !reflect.DeepEqual(req.Tags, []string{"Beginner", "Speaking"}) {
+		t.Errorf("Unexpected prices %v or tags %v", req.Prices, req.Tags)
+	}
+
+	codes := fieldCodes(validationFailed(rows[1].errs...))
+	for _, field := range []string{"prices", "category_id", "characteristics"} {
+		if codes[field] != "invalid" {
+			t.Errorf("Expected %s to be invalid, got %v", field, codes)
+		}
+	}
+}
+
+func TestParseProductCSVRejectsBadHeaders(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"sku,name,price\n",
+		"sku,name,price,date,colour\n",
+		"sku,name,price,date,name\n",
+		"sku,name,price,date\n\"unterminated\n",
+	} {
+		var apiErr *apiError
+		if _, err := parseProductCSV(strings.NewReader(input)); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+			t.Errorf("Expected a 400 for %q, got %v", input, err)
+		}
+	}
+}
+
+func TestProductExportRoundTrip(t *testing.T) {
+	categoryID := uint(4)
+	product := &Product{
+		SKU: "ES-A1", Name: "Spanish, \"quoted\"", Description: "Line one\nline two",
+		PriceAmount: 10050, Currency: "USD", CategoryID: &categoryID,
+		Prices:          []ProductPrice{{Currency: "JPY", Amount: 1800}, {Currency: "EUR", Amount: 9200}},
+		Characteristics: map[string]interface{}{"level": "A1", "duration_hours": float64(20)},
+		Date:            time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Slug:            "spanish-a1",
+		Tags:            []Tag{{Name: "Beginner"}, {Name: "Speaking"}},
+	}
+	want := productImportRequest(product)
+	if want.Price != "100.50" || want.Prices["JPY"] != "1800" || want.Prices["EUR"] != "92.00" || want.Date != "2025-01-01" {
+		t.Fatalf("Unexpected export row %+v", want)
+	}
+
+	var buf bytes.Buffer
+	if err := writeProductCSV(&buf, []productRequest{want}); err != nil {
+		t.Fatalf("writeProductCSV failed: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), strings.Join(productCSVColumns, ",")+"\n") {
+		t.Errorf("Unexpected header in %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "EUR=92.00;JPY=1800") {
+		t.Errorf("Expected prices sorted by currency in %q", buf.String())
+	}
+
+	rows, err := parseProductCSV(&buf)
+	if err != nil || len(rows) != 1 || len(rows[0].errs) != 0 {
+		t.Fatalf("Reimport failed: %v %+v", err, rows)
+	}
+	if !reflect.DeepEqual(rows[0].req, want) {
+		t.Errorf("Round trip changed the row:\n got %+v\nwant %+v", rows[0].req, want)
+	}
+}
+
+func TestProductFormat(t *testing.T) {
+	cases := []struct {
+		query, contentType, want string
+	}{
+		{"?format=CSV", "application/json", "csv"},
+		{"", "text/csv; charset=utf-8", "csv"},
+		{"", "application/json", "json"},
+		{"", "text/plain", ""},
+		{"?format=xml", "", ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/products/import"+c.query, nil)
+		req.Header.Set("Content-Type", c.contentType)
+		got, err := productFormat(req)
+		if got != c.want || (c.want == "") != (err != nil) {
+			t.Errorf("%s %s: got %q, %v", c.query, c.contentType, got, err)
+		}
+	}
+}
+
+func TestCheckImportRowRequiresSKU(t *testing.T) {
+	fields, err := checkImportRow(context.Background(), productRequest{Name: "Spanish", Price: "10", Date: "2025-01-01", SKU: "bad sku"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if codes := fieldCodes(validationFailed(fields...)); codes["sku"] != "invalid" {
+		t.Errorf("Expected an invalid sku, got %v", codes)
+	}
+
+	fields, _ = checkImportRow(context.Background(), productRequest{Name: "Spanish", Price: "10"})
+	codes := fieldCodes(validationFailed(fields...))
+	if codes["sku"] != "required" || codes["date"] != "required" {
+		t.Errorf("Expected sku and date to be required, got %v", codes)
+	}
+}
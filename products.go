@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"LanguageLearningPlatform/money"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxProductsPerPage = 100
+
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+var productSortColumns = map[string]string{
+	"name":   "name",
+	"date":   "date",
+	"newest": "date DESC",
+}
+
+type productRequest struct {
+	Name            string                   `json:"name" validate:"required,max=255"`
+	Description     string                   `json:"description" validate:"max=5000"`
+	Price           money.Decimal            `json:"price"`
+	Currency        string                   `json:"currency" validate:"max=3"`
+	Prices          map[string]money.Decimal `json:"prices" validate:"max=10"`
+	CategoryID      *uint                    `json:"category_id"`
+	Characteristics map[string]interface{}   `json:"characteristics" validate:"max=50"`
+	Date            string                   `json:"date" validate:"required,datetime=2006-01-02"`
+	Image           string                   `json:"image" validate:"max=500"`
+	Slug            string                   `json:"slug" validate:"max=100"`
+	SKU             string                   `json:"sku" validate:"max=64"`
+	Tags            []string                 `json:"tags" validate:"max=20"`
+}
+
+// apply copies the request onto p. Pointing Image somewhere else drops the
+// uploaded renditions. Prices must already have passed checkProductRequest.
+func (req productRequest) apply(p *Product) {
+	if req.Image != p.Image {
+		p.Thumbnails = nil
+	}
+	p.Name = req.Name
+	p.Description = req.Description
+	p.PriceAmount, p.Currency, p.Prices, _ = req.parsePrices()
+	p.CategoryID = req.CategoryID
+	p.Characteristics = req.Characteristics
+	if p.Characteristics == nil {
+		p.Characteristics = map[string]interface{}{}
+	}
+	p.Date, _ = time.Parse("2006-01-02", req.Date)
+	p.Image = req.Image
+	if req.SKU != "" {
+		p.SKU = req.SKU
+	}
+}
+
+// productFilter holds the listing query parameters shared by the admin list
+// and the public catalog.
+type productFilter struct {
+	Page    int
+	PerPage int
+	Query   string `json:"q" validate:"max=200"`
+	// Prices are compared in Currency, which defaults to defaultCurrency.
+	Currency string
+	MinPrice *int64
+	MaxPrice *int64
+	From     string `json:"from" validate:"datetime=2006-01-02"`
+	To       string `json:"to" validate:"datetime=2006-01-02"`
+	Sort     string `json:"sort" validate:"oneof=name price date newest"`
+
+	// Category is an id or slug; products of its subcategories match too.
+	Category   string
+	Tags       []string
+	Attributes []attributeFilter
+}
+
+// attributeFilter matches characteristics[Key]. Values are compared as text
+// and OR-ed together; Min and Max apply only to numeric values.
+type attributeFilter struct {
+	Key    string
+	Values []string
+	Min    *float64
+	Max    *float64
+}
+
+func parseProductFilter(q url.Values) (productFilter, error) {
+	f := productFilter{
+		Page:    1,
+		PerPage: 10,
+		Query:   strings.TrimSpace(q.Get("q")),
+		From:    q.Get("from"),
+		To:      q.Get("to"),
+		Sort:    q.Get("sort"),
+	}
+	if p, err := strconv.Atoi(q.Get("page")); err == nil && p > 0 {
+		f.Page = p
+	}
+	if n, err := strconv.Atoi(q.Get("per_page")); err == nil && n > 0 {
+		f.PerPage = min(n, maxProductsPerPage)
+	}
+	if err := validateRequest(f); err != nil {
+		return f, err
+	}
+
+	var fields []FieldError
+	f.Currency = strings.ToUpper(q.Get("currency"))
+	if f.Currency == "" {
+		f.Currency = defaultCurrency
+	}
+	if _, err := money.Lookup(f.Currency); err != nil {
+		fields = append(fields, fieldError("currency", "oneof", "currency must be a supported ISO 4217 code"))
+	} else {
+		for _, p := range []struct {
+			name string
+			dst  **int64
+		}{{"min_price", &f.MinPrice}, {"max_price", &f.MaxPrice}} {
+			raw := q.Get(p.name)
+			if raw == "" {
+				continue
+			}
+			var v int64
+			if errs := checkAmount(p.name, raw, f.Currency, &v); len(errs) > 0 {
+				fields = append(fields, errs...)
+				continue
+			}
+			*p.dst = &v
+		}
+	}
+	if f.Category = q.Get("category"); f.Category != "" && !slugPattern.MatchString(f.Category) {
+		fields = append(fields, fieldError("category", "invalid", "category must be a category id or slug"))
+	}
+	for _, tag := range q["tag"] {
+		if !slugPattern.MatchString(tag) {
+			fields = append(fields, fieldError("tag", "invalid", "tag must be a tag slug"))
+			continue
+		}
+		f.Tags = append(f.Tags, tag)
+	}
+	fields = append(fields, parseAttributeFilters(q, &f)...)
+	if f.MinPrice != nil && f.MaxPrice != nil && *f.MinPrice > *f.MaxPrice {
+		fields = append(fields, fieldError("max_price", "min", "max_price must not be less than min_price"))
+	}
+	if f.From != "" && f.To != "" && f.From > f.To {
+		fields = append(fields, fieldError("to", "min", "to must not be before from"))
+	}
+	if len(fields) > 0 {
+		return f, validationFailed(fields...)
+	}
+	return f, nil
+}
+
+// parseAttributeFilters reads attr.<key>=value (repeatable) and
+// attr.<key>.min / attr.<key>.max parameters.
+func parseAttributeFilters(q url.Values, f *productFilter) []FieldError {
+	var fields []FieldError
+	byKey := make(map[string]*attributeFilter)
+	params := make([]string, 0, len(q))
+	for param := range q {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	for _, param := range params {
+		rest, ok := strings.CutPrefix(param, "attr.")
+		if !ok {
+			continue
+		}
+		key, bound, _ := strings.Cut(rest, ".")
+		if !attributeKeyPattern.MatchString(key) {
+			fields = append(fields, fieldError(param, "invalid", "unknown attribute filter "+param))
+			continue
+		}
+		af := byKey[key]
+		if af == nil {
+			f.Attributes = append(f.Attributes, attributeFilter{Key: key})
+			af = &f.Attributes[len(f.Attributes)-1]
+			byKey[key] = af
+		}
+
+		switch bound {
+		case "":
+			af.Values = append(af.Values, q[param]...)
+		case "min", "max":
+			v, err := strconv.ParseFloat(q.Get(param), 64)
+			if err != nil {
+				fields = append(fields, fieldError(param, "invalid", param+" must be a number"))
+				continue
+			}
+			if bound == "min" {
+				af.Min = &v
+			} else {
+				af.Max = &v
+			}
+		default:
+			fields = append(fields, fieldError(param, "invalid", "unknown attribute filter "+param))
+		}
+	}
+	return fields
+}
+
+func (f productFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.Query != "" {
+		like := "%" + f.Query + "%"
+		query = query.Where("name ILIKE ? OR description ILIKE ?", like, like)
+	}
+	if f.MinPrice != nil {
+		query = query.Where(priceInCurrencySQL+" >= ?", f.Currency, f.Currency, *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		query = query.Where(priceInCurrencySQL+" <= ?", f.Currency, f.Currency, *f.MaxPrice)
+	}
+	if f.From != "" {
+		query = query.Where("date >= ?", f.From)
+	}
+	if f.To != "" {
+		to, _ := time.Parse("2006-01-02", f.To)
+		query = query.Where("date < ?", to.AddDate(0, 0, 1))
+	}
+	if f.Category != "" {
+		column := "slug"
+		if _, err := strconv.ParseUint(f.Category, 10, 64); err == nil {
+			column = "id"
+		}
+		query = query.Where(`category_id IN (
+			WITH RECURSIVE subtree AS (
+				SELECT id FROM categories WHERE `+column+` = ?
+				UNION SELECT c.id FROM categories c JOIN subtree s ON c.parent_id = s.id
+			) SELECT id FROM subtree)`, f.Category)
+	}
+	for _, tag := range f.Tags {
+		query = query.Where(`EXISTS (SELECT 1 FROM product_tags pt JOIN tags t ON t.id = pt.tag_id
+			WHERE pt.product_id = products.id AND t.slug = ?)`, tag)
+	}
+	for _, a := range f.Attributes {
+		if len(a.Values) > 0 {
+			query = query.Where("characteristics ->> ? IN ?", a.Key, a.Values)
+		}
+		numeric := "CASE WHEN jsonb_typeof(characteristics -> ?) = 'number' THEN (characteristics ->> ?)::numeric END"
+		if a.Min != nil {
+			query = query.Where(numeric+" >= ?", a.Key, a.Key, *a.Min)
+		}
+		if a.Max != nil {
+			query = query.Where(numeric+" <= ?", a.Key, a.Key, *a.Max)
+		}
+	}
+	return query
+}
+
+// order sorts by the requested column. Sorting by price uses the price in
+// f.Currency and puts products without one last.
+func (f productFilter) order() clause.OrderBy {
+	if f.Sort == "price" {
+		return clause.OrderBy{Expression: clause.Expr{
+			SQL:                priceInCurrencySQL + " NULLS LAST, id",
+			Vars:               []interface{}{f.Currency, f.Currency},
+			WithoutParentheses: true,
+		}}
+	}
+	if col, ok := productSortColumns[f.Sort]; ok {
+		return clause.OrderBy{Expression: clause.Expr{SQL: col + ", id"}}
+	}
+	return clause.OrderBy{Expression: clause.Expr{SQL: "id"}}
+}
+
+func getProducts(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseProductFilter(r.URL.Query())
+	if err != nil {
+		handleError(w, r, "getProducts", err)
+		return
+	}
+
+	query := filter.apply(Db.WithContext(r.Context()).Model(&Product{})).Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		handleError(w, r, "getProducts", internalError(fmt.Errorf("error counting products: %v", err)))
+		return
+	}
+
+	var products []Product
+	if err := query.Preload("Tags").Preload("Prices").Order(filter.order()).Limit(filter.PerPage).Offset((filter.Page - 1) * filter.PerPage).Find(&products).Error; err != nil {
+		handleError(w, r, "getProducts", internalError(fmt.Errorf("error retrieving products: %v", err)))
+		return
+	}
+
+	_, locale := displayPrefs(r)
+	views := make([]productView, len(products))
+	for i := range products {
+		views[i] = newProductView(&products[i], filter.Currency, locale)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":     views,
+		"page":     filter.Page,
+		"per_page": filter.PerPage,
+		"total":    total,
+	})
+	logUserAction(r.Context(), "getProducts", "success", map[string]interface{}{"page": filter.Page, "count": len(products)})
+}
+
+// loadProduct finds the product named by the {id} path value, which may
+// also be its slug.
+func loadProduct(w http.ResponseWriter, r *http.Request, action string) (*Product, bool) {
+	query := Db.WithContext(r.Context()).Preload("Tags").Preload("Prices")
+	ref := r.PathValue("id")
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil && id > 0 {
+		query = query.Where("id = ?", id)
+	} else if slugPattern.MatchString(ref) {
+		query = query.Where("slug = ?", ref)
+	} else {
+		handleError(w, r, action, invalidParameter(fmt.Sprintf("invalid id %q", ref)))
+		return nil, false
+	}
+
+	var product Product
+	if err := query.First(&product).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, r, action, notFound("Product not found", err))
+			return nil, false
+		}
+		handleError(w, r, action, internalError(fmt.Errorf("error retrieving product: %v", err)))
+		return nil, false
+	}
+	return &product, true
+}
+
+func checkProductRequest(ctx context.Context, req productRequest) error {
+	if req.Slug != "" && !slugPattern.MatchString(req.Slug) {
+		return validationFailed(fieldError("slug", "invalid", "slug may only contain lowercase letters, digits and single dashes"))
+	}
+	if req.SKU != "" && !skuPattern.MatchString(req.SKU) {
+		return validationFailed(fieldError("sku", "invalid", "sku may only contain letters, digits, dots, dashes and underscores"))
+	}
+	if _, _, _, fields := req.parsePrices(); len(fields) > 0 {
+		return validationFailed(fields...)
+	}
+	return checkProductCharacteristics(ctx, req.CategoryID, req.Characteristics)
+}
+
+// saveProduct inserts or updates product, keeping its slug unique, and
+// replaces its tags and price list.
+func saveProduct(ctx context.Context, product *Product, req productRequest) error {
+	err := Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return saveProductTx(tx, product, req)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return newAPIError(http.StatusConflict, codeConflict, "A product with this slug or SKU already exists", err)
+	}
+	if err != nil {
+		return internalError(fmt.Errorf("failed to save product: %v", err))
+	}
+	return nil
+}
+
+func saveProductTx(tx *gorm.DB, product *Product, req productRequest) error {
+	tags, err := resolveTags(tx, req.Tags)
+	if err != nil {
+		return err
+	}
+	switch {
+	case req.Slug != "":
+		product.Slug = req.Slug
+	case product.Slug == "":
+		if product.Slug, err = uniqueProductSlug(tx, slugify(product.Name), product.ID); err != nil {
+			return err
+		}
+	}
+
+	// The rating is left to refreshProductRating, which may have changed it
+	// since product was loaded.
+	if err := tx.Omit("Tags", "Prices", "RatingAverage", "RatingCount", "RatingDistribution").Save(product).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("product_id = ?", product.ID).Delete(&ProductPrice{}).Error; err != nil {
+		return err
+	}
+	for i := range product.Prices {
+		product.Prices[i].ID = 0
+		product.Prices[i].ProductID = product.ID
+	}
+	if len(product.Prices) > 0 {
+		if err := tx.Create(&product.Prices).Error; err != nil {
+			return err
+		}
+	}
+	product.Tags = tags
+	return tx.Model(product).Association("Tags").Replace(tags)
+}
+
+func uniqueProductSlug(tx *gorm.DB, base string, id uint) (string, error) {
+	if base == "" {
+		base = "product"
+	}
+	for i := 1; i <= 20; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d", base, i)
+		}
+		var taken int64
+		if err := tx.Model(&Product{}).Where("slug = ? AND id <> ?", candidate, id).Count(&taken).Error; err != nil {
+			return "", err
+		}
+		if taken == 0 {
+			return candidate, nil
+		}
+	}
+	return fmt.Sprintf("%s-%d", base, time.Now().UnixNano()), nil
+}
+
+func getProduct(w http.ResponseWriter, r *http.Request) {
+	product, ok := loadProduct(w, r, "getProduct")
+	if !ok {
+		return
+	}
+
+	writeProduct(w, r, http.StatusOK, product)
+}
+
+func createProduct(w http.ResponseWriter, r *http.Request) {
+	var req productRequest
+	if !decodeAndValidate(w, r, "createProduct", &req) {
+		return
+	}
+	if err := checkProductRequest(r.Context(), req); err != nil {
+		handleError(w, r, "createProduct", err)
+		return
+	}
+
+	var product Product
+	req.apply(&product)
+	if err := saveProduct(r.Context(), &product, req); err != nil {
+		handleError(w, r, "createProduct", err)
+		return
+	}
+
+	writeProduct(w, r, http.StatusCreated, &product)
+	logUserAction(r.Context(), "createProduct", "success", map[string]interface{}{"product_id": product.ID})
+}
+
+func updateProduct(w http.ResponseWriter, r *http.Request) {
+	product, ok := loadProduct(w, r, "updateProduct")
+	if !ok {
+		return
+	}
+
+	var req productRequest
+	if !decodeAndValidate(w, r, "updateProduct", &req) {
+		return
+	}
+	if err := checkProductRequest(r.Context(), req); err != nil {
+		handleError(w, r, "updateProduct", err)
+		return
+	}
+
+	previous := productMediaKeys(product)
+	req.apply(product)
+	if err := saveProduct(r.Context(), product, req); err != nil {
+		handleError(w, r, "updateProduct", err)
+		return
+	}
+	if product.Thumbnails == nil {
+		discardMedia(r.Context(), previous)
+	}
+
+	writeProduct(w, r, http.StatusOK, product)
+	logUserAction(r.Context(), "updateProduct", "success", map[string]interface{}{"product_id": product.ID})
+}
+
+func deleteProduct(w http.ResponseWriter, r *http.Request) {
+	product, ok := loadProduct(w, r, "deleteProduct")
+	if !ok {
+		return
+	}
+
+	if err := Db.WithContext(r.Context()).Select("Tags", "Prices").Delete(product).Error; err != nil {
+		handleError(w, r, "deleteProduct", internalError(fmt.Errorf("failed to delete product: %v", err)))
+		return
+	}
+	discardMedia(r.Context(), productMediaKeys(product))
+
+	w.WriteHeader(http.StatusNoContent)
+	logUserAction(r.Context(), "deleteProduct", "success", map[string]interface{}{"product_id": product.ID})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm/clause"
+)
+
+func TestParseProductFilter(t *testing.T) {
+	f, err := parseProductFilter(url.Values{
+		"page":      {"3"},
+		"per_page":  {"500"},
+		"q":         {" spanish "},
+		"min_price": {"10"},
+		"max_price": {"99.5"},
+		"from":      {"2025-01-01"},
+		"sort":      {"price"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Page != 3 || f.PerPage != maxProductsPerPage || f.Query != "spanish" || f.MaxPrice == nil || *f.MinPrice != 1000 || *f.MaxPrice != 9950 {
+		t.Errorf("Unexpected filter: %+v", f)
+	}
+	if order := f.order().Expression.(clause.Expr); !strings.HasSuffix(order.SQL, "NULLS LAST, id") || order.Vars[0] != defaultCurrency {
+		t.Errorf("Unexpected order %+v", order)
+	}
+
+	f, _ = parseProductFilter(url.Values{})
+	if f.Page != 1 || f.PerPage != 10 || f.MinPrice != nil || f.Currency != defaultCurrency || f.order().Expression.(clause.Expr).SQL != "id" {
+		t.Errorf("Unexpected defaults: %+v", f)
+	}
+
+	f, err = parseProductFilter(url.Values{"currency": {"jpy"}, "min_price": {"1500"}})
+	if err != nil || f.Currency != "JPY" || *f.MinPrice != 1500 {
+		t.Errorf("Unexpected JPY filter %+v: %v", f, err)
+	}
+}
+
+func TestParseProductFilterRejectsInvalidRanges(t *testing.T) {
+	cases := []url.Values{
+		{"min_price": {"cheap"}},
+		{"min_price": {"-1"}},
+		{"min_price": {"1.005"}},
+		{"currency": {"XXX"}},
+		{"currency": {"JPY"}, "min_price": {"1.5"}},
+		{"min_price": {"50"}, "max_price": {"10"}},
+		{"from": {"2025-02-01"}, "to": {"2025-01-01"}},
+		{"from": {"01/02/2025"}},
+		{"sort": {"password"}},
+	}
+	for _, q := range cases {
+		_, err := parseProductFilter(q)
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.Status != 422 {
+			t.Errorf("Expected validation error for %v, got %v", q, err)
+		}
+	}
+}
+
+func TestParseProductFilterAttributes(t *testing.T) {
+	f, err := parseProductFilter(url.Values{
+		"category":        {"4"},
+		"tag":             {"grammar", "speaking"},
+		"attr.level":      {"A1", "A2"},
+		"attr.hours.min":  {"10"},
+		"attr.hours.max":  {"40"},
+		"attr.language":   {"Spanish"},
+		"unrelated_param": {"x"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Category != "4" || len(f.Tags) != 2 || len(f.Attributes) != 3 {
+		t.Fatalf("Unexpected filter: %+v", f)
+	}
+	hours, language, level := f.Attributes[0], f.Attributes[1], f.Attributes[2]
+	if hours.Key != "hours" || *hours.Min != 10 || *hours.Max != 40 || len(hours.Values) != 0 {
+		t.Errorf("Unexpected hours filter: %+v", hours)
+	}
+	if language.Key != "language" || language.Values[0] != "Spanish" {
+		t.Errorf("Unexpected language filter: %+v", language)
+	}
+	if level.Key != "level" || len(level.Values) != 2 {
+		t.Errorf("Unexpected level filter: %+v", level)
+	}
+
+	for _, q := range []url.Values{
+		{"attr.hours.min": {"ten"}},
+		{"attr.hours.avg": {"1"}},
+		{"attr.Level": {"A1"}},
+		{"category": {"Music!"}},
+		{"tag": {"two words"}},
+	} {
+		if _, err := parseProductFilter(q); err == nil {
+			t.Errorf("Expected an error for %v", q)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	reviewPending  = "pending"
+	reviewApproved = "approved"
+	reviewHidden   = "hidden"
+)
+
+var reviewSortColumns = map[string]string{
+	"":        "helpful_count DESC, created_at DESC",
+	"helpful": "helpful_count DESC, created_at DESC",
+	"newest":  "created_at DESC",
+	"highest": "rating DESC, created_at DESC",
+	"lowest":  "rating, created_at DESC",
+}
+
+// Review is a learner's rating of a product they bought or are enrolled in.
+// New and edited reviews wait for an admin to approve them; only approved
+// reviews are listed and counted in the product's rating.
+type Review struct {
+	ID        uint     `json:"id" gorm:"primaryKey"`
+	ProductID uint     `json:"product_id" gorm:"uniqueIndex:idx_reviews_product_user;not null"`
+	Product   *Product `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	UserID    uint     `json:"user_id" gorm:"uniqueIndex:idx_reviews_product_user;not null"`
+	User      *User    `json:"-"`
+	// OrderID is the paid order that makes the review a verified purchase.
+	OrderID      uint       `json:"-" gorm:"index"`
+	Rating       int        `json:"rating" gorm:"not null"`
+	Title        string     `json:"title"`
+	Body         string     `json:"body" gorm:"type:text"`
+	Status       string     `json:"status" gorm:"index;not null"`
+	HelpfulCount int        `json:"helpful_count" gorm:"not null;default:0"`
+	ModeratedBy  *uint      `json:"moderated_by,omitempty"`
+	ModeratedAt  *time.Time `json:"moderated_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// ReviewVote records that a user found a review helpful.
+type ReviewVote struct {
+	ReviewID  uint    `gorm:"primaryKey;autoIncrement:false"`
+	Review    *Review `gorm:"constraint:OnDelete:CASCADE"`
+	UserID    uint    `gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt time.Time
+}
+
+type reviewView struct {
+	*Review
+	Author string `json:"author"`
+}
+
+func newReviewView(r *Review) reviewView {
+	v := reviewView{Review: r}
+	if r.User != nil {
+		v.Author = r.User.Name
+	}
+	return v
+}
+
+type reviewRequest struct {
+	Rating int    `json:"rating" validate:"required,min=1,max=5"`
+	Title  string `json:"title" validate:"max=200"`
+	Body   string `json:"body" validate:"max=5000"`
+}
+
+// summarizeRatings turns the number of approved reviews per star rating into
+// the average, rounded to two decimals, the count and the distribution,
+// indexed from one star to five.
+func summarizeRatings(counts map[int]int) (float64, int, []int) {
+	distribution := make([]int, 5)
+	total, sum := 0, 0
+	for rating, n := range counts {
+		if rating < 1 || rating > 5 {
+			continue
+		}
+		distribution[rating-1] = n
+		total += n
+		sum += rating * n
+	}
+	if total == 0 {
+		return 0, 0, distribution
+	}
+	return math.Round(float64(sum)/float64(total)*100) / 100, total, distribution
+}
+
+// refreshProductRating recomputes the rating of product id from its
+// approved reviews. The product row stays locked until tx commits, so
+// concurrent moderation cannot store a stale aggregate.
+func refreshProductRating(tx *gorm.DB, id uint) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&Product{}, id).Error; err != nil {
+		return err
+	}
+	var rows []struct {
+		Rating int
+		Count  int
+	}
+	err := tx.Model(&Review{}).Select("rating, COUNT(*) AS count").
+		Where("product_id = ? AND status = ?", id, reviewApproved).
+		Group("rating").Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	counts := make(map[int]int, len(rows))
+	for _, row := range rows {
+		counts[row.Rating] = row.Count
+	}
+
+	var p Product
+	p.RatingAverage, p.RatingCount, p.RatingDistribution = summarizeRatings(counts)
+	return tx.Model(&Product{ID: id}).Select("RatingAverage", "RatingCount", "RatingDistribution").Updates(&p).Error
+}
+
+// purchaseOrderID returns the order through which userID bought productID:
+// the one behind an active enrollment, else any paid order containing it.
+// It returns 0 when the user has not bought the product.
+func purchaseOrderID(tx *gorm.DB, userID, productID uint) (uint, error) {
+	var enrollment Enrollment
+	err := tx.Where("user_id = ? AND product_id = ? AND revoked_at IS NULL", userID, productID).Limit(1).Find(&enrollment).Error
+	if err != nil || enrollment.OrderID != 0 {
+		return enrollment.OrderID, err
+	}
+	var orderIDs []uint
+	err = tx.Model(&OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.user_id = ? AND orders.status = ? AND order_items.product_id = ?", userID, orderPaid, productID).
+		Order("orders.id DESC").Limit(1).Pluck("orders.id", &orderIDs).Error
+	if err != nil || len(orderIDs) == 0 {
+		return 0, err
+	}
+	return orderIDs[0], nil
+}
+
+// getProductReviews lists the approved reviews of a product with its
+// rating summary.
+func getProductReviews(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "getProductReviews", invalidParameter(err.Error()))
+		return
+	}
+	limit := 10
+	page := 1
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+		page = p
+	}
+	order, ok := reviewSortColumns[r.URL.Query().Get("sort")]
+	if !ok {
+		handleError(w, r, "getProductReviews", validationFailed(fieldError("sort", "oneof", "sort must be one of helpful newest highest lowest")))
+		return
+	}
+
+	var product Product
+	if err := Db.WithContext(r.Context()).Select("id", "rating_average", "rating_count", "rating_distribution").First(&product, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, r, "getProductReviews", notFound("Product not found", err))
+		} else {
+			handleError(w, r, "getProductReviews", internalError(fmt.Errorf("error retrieving product: %v", err)))
+		}
+		return
+	}
+
+	var reviews []Review
+	err = Db.WithContext(r.Context()).Preload("User", func(tx *gorm.DB) *gorm.DB { return tx.Select("id", "name") }).
+		Where("product_id = ? AND status = ?", id, reviewApproved).
+		Order(order + ", id DESC").Limit(limit).Offset((page - 1) * limit).Find(&reviews).Error
+	if err != nil {
+		handleError(w, r, "getProductReviews", internalError(fmt.Errorf("error retrieving reviews: %v", err)))
+		return
+	}
+
+	views := make([]reviewView, len(reviews))
+	for i := range reviews {
+		views[i] = newReviewView(&reviews[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":                views,
+		"page":                page,
+		"per_page":            limit,
+		"total":               product.RatingCount,
+		"rating_average":      product.RatingAverage,
+		"rating_distribution": product.RatingDistribution,
+	})
+}
+
+// getMyReviews lists the caller's reviews in any status.
+func getMyReviews(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+
+	var reviews []Review
+	if err := Db.WithContext(r.Context()).Where("user_id = ?", userID).Order("created_at DESC").Find(&reviews).Error; err != nil {
+		handleError(w, r, "getMyReviews", internalError(fmt.Errorf("error retrieving reviews: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reviews)
+}
+
+func createReview(w http.ResponseWriter, r *http.Request) {
+	productID, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "createReview", invalidParameter(err.Error()))
+		return
+	}
+	var req reviewRequest
+	if !decodeAndValidate(w, r, "createReview", &req) {
+		return
+	}
+	userID, _ := requestUserID(r)
+
+	review := Review{ProductID: productID, UserID: userID, Rating: req.Rating, Title: req.Title, Body: req.Body, Status: reviewPending}
+	err = Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id").First(&Product{}, productID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return notFound("Product not found", err)
+			}
+			return err
+		}
+		orderID, err := purchaseOrderID(tx, userID, productID)
+		if err != nil {
+			return err
+		}
+		if orderID == 0 {
+			return forbidden("Only learners who bought this course can review it")
+		}
+		review.OrderID = orderID
+		return tx.Create(&review).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		err = newAPIError(http.StatusConflict, codeConflict, "You have already reviewed this course", err)
+	}
+	var apiErr *apiError
+	if err != nil && !errors.As(err, &apiErr) {
+		err = internalError(fmt.Errorf("error creating review: %v", err))
+	}
+	if err != nil {
+		handleError(w, r, "createReview", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(review)
+	logUserAction(r.Context(), "createReview", "success", map[string]interface{}{"review_id": review.ID, "product_id": productID})
+}
+
+func loadReview(w http.ResponseWriter, r *http.Request, action string) (*Review, bool) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, action, invalidParameter(err.Error()))
+		return nil, false
+	}
+	var review Review
+	if err := Db.WithContext(r.Context()).First(&review, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleError(w, r, action, notFound("Review not found", err))
+		} else {
+			handleError(w, r, action, internalError(fmt.Errorf("error retrieving review: %v", err)))
+		}
+		return nil, false
+	}
+	return &review, true
+}
+
+// saveReview stores review and, when the change affects what is counted,
+// refreshes the product rating in the same transaction.
+func saveReview(r *http.Request, review *Review, wasApproved bool) error {
+	return Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(review).Error; err != nil {
+			return err
+		}
+		if wasApproved || review.Status == reviewApproved {
+			return refreshProductRating(tx, review.ProductID)
+		}
+		return nil
+	})
+}
+
+// updateReview lets the author edit their review. The edit goes back to
+// moderation, so it leaves the product rating until approved again.
+func updateReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := loadReview(w, r, "updateReview")
+	if !ok {
+		return
+	}
+	if userID, _ := requestUserID(r); review.UserID != userID {
+		handleError(w, r, "updateReview", forbidden("You can only edit your own reviews"))
+		return
+	}
+	var req reviewRequest
+	if !decodeAndValidate(w, r, "updateReview", &req) {
+		return
+	}
+
+	wasApproved := review.Status == reviewApproved
+	review.Rating = req.Rating
+	review.Title = req.Title
+	review.Body = req.Body
+	review.Status = reviewPending
+	review.ModeratedBy = nil
+	review.ModeratedAt = nil
+	if err := saveReview(r, review, wasApproved); err != nil {
+		handleError(w, r, "updateReview", internalError(fmt.Errorf("error updating review: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+	logUserAction(r.Context(), "updateReview", "success", map[string]interface{}{"review_id": review.ID})
+}
+
+// deleteReview removes a review; authors may delete their own, admins any.
+func deleteReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := loadReview(w, r, "deleteReview")
+	if !ok {
+		return
+	}
+	if userID, _ := requestUserID(r); review.UserID != userID && requestUserRole(r) != "admin" {
+		handleError(w, r, "deleteReview", forbidden("You can only delete your own reviews"))
+		return
+	}
+
+	err := Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(review).Error; err != nil {
+			return err
+		}
+		if review.Status == reviewApproved {
+			return refreshProductRating(tx, review.ProductID)
+		}
+		return nil
+	})
+	if err != nil {
+		handleError(w, r, "deleteReview", internalError(fmt.Errorf("error deleting review: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logUserAction(r.Context(), "deleteReview", "success", map[string]interface{}{"review_id": review.ID})
+}
+
+// voteReview marks an approved review as helpful for the caller, or
+// withdraws the vote on DELETE. Voting twice counts once.
+func voteReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := loadReview(w, r, "voteReview")
+	if !ok {
+		return
+	}
+	if review.Status != reviewApproved {
+		handleError(w, r, "voteReview", notFound("Review not found", nil))
+		return
+	}
+	userID, _ := requestUserID(r)
+	if review.UserID == userID {
+		handleError(w, r, "voteReview", forbidden("You cannot vote for your own review"))
+		return
+	}
+
+	err := Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		vote := ReviewVote{ReviewID: review.ID, UserID: userID}
+		var result *gorm.DB
+		delta := 1
+		if r.Method == http.MethodDelete {
+			result = tx.Delete(&vote)
+			delta = -1
+		} else {
+			result = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&vote)
+		}
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Model(review).Clauses(clause.Returning{Columns: []clause.Column{{Name: "helpful_count"}}}).
+			Update("helpful_count", gorm.Expr("helpful_count + ?", delta)).Error
+	})
+	if err != nil {
+		handleError(w, r, "voteReview", internalError(fmt.Errorf("error recording vote: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"helpful_count": review.HelpfulCount})
+}
+
+// getAdminReviews lists reviews for moderation, pending ones by default.
+func getAdminReviews(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	page := 1
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+		page = p
+	}
+
+	params := struct {
+		Status    string `json:"status" validate:"oneof=pending approved hidden"`
+		ProductID string `json:"product_id"`
+	}{
+		Status:    r.URL.Query().Get("status"),
+		ProductID: r.URL.Query().Get("product_id"),
+	}
+	if err := validateRequest(params); err != nil {
+		handleError(w, r, "getAdminReviews", err)
+		return
+	}
+	if params.Status == "" {
+		params.Status = reviewPending
+	}
+
+	query := Db.WithContext(r.Context()).Model(&Review{}).Where("status = ?", params.Status)
+	if params.ProductID != "" {
+		id, err := strconv.ParseUint(params.ProductID, 10, 64)
+		if err != nil {
+			handleError(w, r, "getAdminReviews", validationFailed(fieldError("product_id", "invalid", "product_id must be a product id")))
+			return
+		}
+		query = query.Where("product_id = ?", id)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		handleError(w, r, "getAdminReviews", internalError(fmt.Errorf("error counting reviews: %v", err)))
+		return
+	}
+
+	var reviews []Review
+	err := query.Preload("User", func(tx *gorm.DB) *gorm.DB { return tx.Select("id", "name") }).
+		Order("created_at, id").Limit(limit).Offset((page - 1) * limit).Find(&reviews).Error
+	if err != nil {
+		handleError(w, r, "getAdminReviews", internalError(fmt.Errorf("error retrieving reviews: %v", err)))
+		return
+	}
+
+	views := make([]reviewView, len(reviews))
+	for i := range reviews {
+		views[i] = newReviewView(&reviews[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":     views,
+		"page":     page,
+		"per_page": limit,
+		"total":    total,
+	})
+}
+
+// moderateReview approves or hides a review.
+func moderateReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := loadReview(w, r, "moderateReview")
+	if !ok {
+		return
+	}
+	var req struct {
+		Status string `json:"status" validate:"required,oneof=approved hidden"`
+	}
+	if !decodeAndValidate(w, r, "moderateReview", &req) {
+		return
+	}
+
+	wasApproved := review.Status == reviewApproved
+	adminID, _ := requestUserID(r)
+	now := time.Now()
+	review.Status = req.Status
+	review.ModeratedBy = &adminID
+	review.ModeratedAt = &now
+	if err := saveReview(r, review, wasApproved); err != nil {
+		handleError(w, r, "moderateReview", internalError(fmt.Errorf("error moderating review: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+	logUserAction(r.Context(), "moderateReview", "success", map[string]interface{}{"review_id": review.ID, "status": review.Status})
+}
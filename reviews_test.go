@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSummarizeRatings(t *testing.T) {
+	average, count, distribution := summarizeRatings(map[int]int{5: 2, 4: 1, 1: 1})
+	if average != 3.75 || count != 4 || !reflect.DeepEqual(distribution, []int{1, 0, 0, 1, 2}) {
+		t.Errorf("Unexpected summary %v, %d, %v", average, count, distribution)
+	}
+
+	average, _, _ = summarizeRatings(map[int]int{5: 1, 4: 2})
+	if average != 4.33 {
+		t.Errorf("Expected the average rounded to 4.33, got %v", average)
+	}
+
+	average, count, distribution = summarizeRatings(nil)
+	if average != 0 || count != 0 || !reflect.DeepEqual(distribution, []int{0, 0, 0, 0, 0}) {
+		t.Errorf("Unexpected empty summary %v, %d, %v", average, count, distribution)
+	}
+}
+
+func TestReviewRequestValidation(t *testing.T) {
+	if err := validateRequest(reviewRequest{Rating: 5, Title: "Great course"}); err != nil {
+		t.Errorf("Expected a valid review, got %v", err)
+	}
+	for _, rating := range []int{0, 6, -1} {
+		if codes := fieldCodes(validateRequest(reviewRequest{Rating: rating})); codes["rating"] == "" {
+			t.Errorf("Expected rating %d to be rejected, got %v", rating, codes)
+		}
+	}
+}
+
+func TestGetProductReviewsRejectsUnknownSort(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/catalog/products/1/reviews?sort=password", nil)
+	req.SetPathValue("id", "1")
+	rr := httptest.NewRecorder()
+	getProductReviews(rr, req)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,27 @@
+async function describeError(response) {
+    const contentType = response.headers.get('Content-Type') || '';
+    const text = await response.text();
+    if (contentType.includes('json')) {
+        try {
+            const problem = JSON.parse(text);
+            let message = problem.detail || problem.title || `Request failed with status ${response.status}`;
+            if (Array.isArray(problem.errors) && problem.errors.length > 0) {
+                message += '\n' + problem.errors.map(e => `- ${e.field}: ${e.message}`).join('\n');
+            }
+            if (problem.request_id) {
+                message += `\n(Request ID: ${problem.request_id})`;
+            }
+            return message;
+        } catch (err) {
+            console.error('Failed to parse error response:', err);
+        }
+    }
+
+    return text || `Request failed with status ${response.status}`;
+}
+
+function escapeHTML(value) {
+    const div = document.createElement('div');
+    div.textContent = value ?? '';
+    return div.innerHTML;
+}
@@ -0,0 +1,24 @@
+async function addToCart(productId) {
+    const token = localStorage.getItem('token');
+    if (!token) {
+        window.location.href = '/static/loginPage';
+        return;
+    }
+
+    try {
+        const response = await fetch(`/api/v1/cart/items/${productId}`, {
+            method: 'PUT',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${token}`,
+            },
+            body: JSON.stringify({ quantity: 1 }),
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        alert('Added to your cart. Check out from your profile page.');
+    } catch (err) {
+        console.error('Error adding to cart:', err);
+        alert(`Failed to add to cart: ${err.message}`);
+    }
+}
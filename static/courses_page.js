@@ -0,0 +1,176 @@
+function currentParams() {
+    return new URLSearchParams(window.location.search);
+}
+
+function navigate(changes) {
+    const params = currentParams();
+    Object.entries(changes).forEach(([key, value]) => {
+        if (value) params.set(key, value);
+        else params.delete(key);
+    });
+    if (!('page' in changes)) params.delete('page');
+    history.pushState(null, '', `/courses?${params}`);
+    render();
+}
+
+function renderTree(nodes, selected) {
+    if (!nodes.length) return '';
+    let output = '<ul class="list-unstyled ms-2">';
+    nodes.forEach(node => {
+        const active = node.slug === selected ? 'fw-bold' : '';
+        output += `<li>
+            <a href="#" class="${active}" onclick="navigate({ category: '${node.slug}' }); return false;">${escapeHTML(node.name)}</a>
+            <span class="text-muted">(${node.product_count})</span>
+            ${renderTree(node.children, selected)}
+        </li>`;
+    });
+    return output + '</ul>';
+}
+
+async function loadNavigation(params) {
+    const [treeResponse, tagsResponse] = await Promise.all([
+        fetch('/api/v1/catalog/categories'),
+        fetch('/api/v1/catalog/tags'),
+    ]);
+    if (!treeResponse.ok) throw new Error(await describeError(treeResponse));
+    if (!tagsResponse.ok) throw new Error(await describeError(tagsResponse));
+
+    const tree = await treeResponse.json();
+    document.getElementById('categoryTree').innerHTML =
+        `<a href="#" onclick="navigate({ category: '' }); return false;">All courses</a>` + renderTree(tree, params.get('category'));
+
+    const tags = await tagsResponse.json();
+    document.getElementById('tagList').innerHTML = tags.map(tag => {
+        const active = tag.slug === params.get('tag');
+        return `<button class="btn btn-sm ${active ? 'btn-success' : 'btn-outline-success'}"
+            onclick="navigate({ tag: '${active ? '' : tag.slug}' })">${escapeHTML(tag.name)} (${tag.product_count})</button>`;
+    }).join('');
+
+    let crumbs = '<li class="breadcrumb-item"><a href="/courses">Courses</a></li>';
+    if (params.get('category')) {
+        const response = await fetch(`/api/v1/catalog/categories/${encodeURIComponent(params.get('category'))}`);
+        if (response.ok) {
+            const result = await response.json();
+            result.breadcrumbs.forEach(category => {
+                crumbs += `<li class="breadcrumb-item"><a href="#" onclick="navigate({ category: '${category.slug}' }); return false;">${escapeHTML(category.name)}</a></li>`;
+            });
+        }
+    }
+    document.getElementById('breadcrumbs').innerHTML = crumbs;
+}
+
+async function loadCourses(params) {
+    const query = new URLSearchParams({ per_page: 9 });
+    ['page', 'q', 'sort', 'category', 'tag'].forEach(key => {
+        if (params.get(key)) query.set(key, params.get(key));
+    });
+
+    const response = await fetch(`/api/v1/catalog?${query}`);
+    if (!response.ok) throw new Error(await describeError(response));
+
+    const result = await response.json();
+    let output = '';
+    result.data.forEach(product => {
+        const tags = (product.tags || []).map(tag => `<span class="badge bg-secondary me-1">${escapeHTML(tag.name)}</span>`).join('');
+        output += `<div class="col-md-4 mb-3">
+            <div class="card h-100">
+                ${product.thumbnails ? `<img src="${escapeHTML(product.thumbnails.medium)}" class="card-img-top" alt="${escapeHTML(product.name)}" loading="lazy">` : ''}
+                <div class="card-body">
+                    <h5 class="card-title">${escapeHTML(product.name)}</h5>
+                    <p class="card-text">${escapeHTML(product.description)}</p>
+                    <p class="card-text fw-bold">${escapeHTML(product.price.formatted)}</p>
+                    ${ratingLine(product)}
+                    ${tags}
+                    <button class="btn btn-success mt-2" onclick="addToCart(${product.id})">Add to cart</button>
+                    ${product.rating_count > 0 ? `<button class="btn btn-outline-success mt-2" onclick="loadReviews(${product.id})">Reviews</button>` : ''}
+                    <div id="reviews-${product.id}" class="mt-2"></div>
+                </div>
+            </div>
+        </div>`;
+    });
+    document.getElementById('courses').innerHTML = output || '<p>No courses found.</p>';
+
+    const pages = Math.max(1, Math.ceil(result.total / result.per_page));
+    let pager = '';
+    if (result.page > 1) pager += `<button class="btn btn-outline-success" onclick="navigate({ page: ${result.page - 1} })">Previous</button>`;
+    if (result.page < pages) pager += `<button class="btn btn-outline-success" onclick="navigate({ page: ${result.page + 1} })">Next</button>`;
+    document.getElementById('coursesPages').innerHTML = pager;
+}
+
+function ratingLine(product) {
+    if (!product.rating_count) return '<p class="card-text text-muted">No reviews yet</p>';
+    const stars = '★'.repeat(Math.round(product.rating_average)).padEnd(5, '☆');
+    return `<p class="card-text" title="${product.rating_distribution.map((n, i) => `${i + 1}★: ${n}`).join(', ')}">
+        ${stars} ${product.rating_average.toFixed(1)} (${product.rating_count})</p>`;
+}
+
+async function loadReviews(productId, page = 1) {
+    const output = document.getElementById(`reviews-${productId}`);
+    try {
+        const response = await fetch(`/api/v1/catalog/products/${productId}/reviews?page=${page}`);
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const result = await response.json();
+        let html = result.data.map(review => `<div class="border-top pt-2">
+            <div>${'★'.repeat(review.rating)} <strong>${escapeHTML(review.title)}</strong></div>
+            <p class="mb-1">${escapeHTML(review.body)}</p>
+            <small class="text-muted">${escapeHTML(review.author)}, ${new Date(review.created_at).toLocaleDateString()}</small>
+            <button class="btn btn-sm btn-link" onclick="markHelpful(${review.id}, this)">Helpful (${review.helpful_count})</button>
+        </div>`).join('');
+        if (result.page * result.per_page < result.total) {
+            html += `<button class="btn btn-sm btn-outline-success" onclick="loadReviews(${productId}, ${result.page + 1})">More reviews</button>`;
+        }
+        output.innerHTML = html;
+    } catch (err) {
+        console.error('Error loading reviews:', err);
+        output.innerHTML = '<p>Reviews are unavailable right now.</p>';
+    }
+}
+
+async function markHelpful(reviewId, button) {
+    const token = localStorage.getItem('token');
+    if (!token) {
+        window.location.href = '/static/loginPage';
+        return;
+    }
+
+    try {
+        const response = await fetch(`/api/v1/reviews/${reviewId}/helpful`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const result = await response.json();
+        button.textContent = `Helpful (${result.helpful_count})`;
+        button.disabled = true;
+    } catch (err) {
+        console.error('Error voting for review:', err);
+        alert(`Failed to vote: ${err.message}`);
+    }
+}
+
+async function render() {
+    const params = currentParams();
+    document.getElementById('coursesQuery').value = params.get('q') || '';
+    document.getElementById('coursesSort').value = params.get('sort') || '';
+    try {
+        await Promise.all([loadNavigation(params), loadCourses(params)]);
+    } catch (err) {
+        console.error('Error loading courses:', err);
+        document.getElementById('courses').innerHTML = '<p>Courses are unavailable right now.</p>';
+    }
+}
+
+document.getElementById('coursesSearch').addEventListener('submit', function (e) {
+    e.preventDefault();
+    navigate({
+        q: document.getElementById('coursesQuery').value.trim(),
+        sort: document.getElementById('coursesSort').value,
+    });
+});
+
+window.addEventListener('popstate', render);
+render();
@@ -23,7 +23,7 @@ document.getElementById('loginForm').addEventListener('submit', async (e) => {
                 window.location.href = '/';
             }
         } else {
-            alert('Login failed');
+            alert(`Login failed: ${await describeError(response)}`);
         }
     } catch (error) {
         console.error('Error during login:', error);
@@ -0,0 +1,43 @@
+async function loadCatalog(page = 1) {
+    const params = new URLSearchParams({ page, per_page: 6 });
+    const query = document.getElementById('catalogQuery').value.trim();
+    if (query) params.set('q', query);
+
+    try {
+        const response = await fetch(`/api/v1/catalog?${params}`);
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const result = await response.json();
+        let output = '';
+        result.data.forEach(product => {
+            output += `<div class="col-md-4 mb-3">
+                <div class="card h-100">
+                    ${product.thumbnails ? `<img src="${escapeHTML(product.thumbnails.medium)}" class="card-img-top" alt="${escapeHTML(product.name)}" loading="lazy">` : ''}
+                    <div class="card-body">
+                        <h5 class="card-title">${escapeHTML(product.name)}</h5>
+                        <p class="card-text">${escapeHTML(product.description)}</p>
+                        <p class="card-text fw-bold">${escapeHTML(product.price.formatted)}</p>
+                        <button class="btn btn-success" onclick="addToCart(${product.id})">Add to cart</button>
+                    </div>
+                </div>
+            </div>`;
+        });
+        document.getElementById('catalog').innerHTML = output || '<p>No courses found.</p>';
+
+        const pages = Math.max(1, Math.ceil(result.total / result.per_page));
+        let pager = '';
+        if (result.page > 1) pager += `<button class="btn btn-outline-success" onclick="loadCatalog(${result.page - 1})">Previous</button>`;
+        if (result.page < pages) pager += `<button class="btn btn-outline-success" onclick="loadCatalog(${result.page + 1})">Next</button>`;
+        document.getElementById('catalogPages').innerHTML = pager;
+    } catch (err) {
+        console.error('Error loading catalog:', err);
+        document.getElementById('catalog').innerHTML = '<p>Courses are unavailable right now.</p>';
+    }
+}
+
+document.getElementById('catalogSearch').addEventListener('submit', function (e) {
+    e.preventDefault();
+    loadCatalog();
+});
+
+loadCatalog();
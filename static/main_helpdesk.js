@@ -20,9 +20,10 @@ document.getElementById('supportForm').addEventListener('submit', async function
         });
 
         if (response.ok) {
-            alert('Message sent successfully!');
+            const ticket = await response.json();
+            alert(`Ticket #${ticket.id} created. You can follow it on your profile page.`);
         } else {
-            alert('Failed to send message');
+            alert(`Failed to send message: ${await describeError(response)}`);
         }
     } catch (error) {
         console.error('Error sending message:', error);
@@ -3,13 +3,12 @@ async function createUser() {
         const name = document.getElementById('name')?.value.trim();
         const email = document.getElementById('email')?.value.trim();
         const password = document.getElementById('password')?.value.trim();
-        const role = document.getElementById('role')?.value.trim().toLowerCase();
         if (!name) {
             alert('Name is required.');
             return;
         }
-        if (name.length < 1) {
-            alert('Name must be at least 1 characters long.');
+        if (name.length < 3) {
+            alert('Name must be at least 3 characters long.');
             return;
         }
         if (!email) {
@@ -31,11 +30,11 @@ async function createUser() {
         const response = await fetch('/create', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ name, email, password, role }),
+            body: JSON.stringify({ name, email, password }),
         });
 
         if (!response.ok) {
-            const error = await response.text();
+            const error = await describeError(response);
             throw new Error(`Error creating user: ${error}`);
         }
 
@@ -54,21 +53,21 @@ let currentPage = 1;
 async function getUsers(page = 1) {
     try {
         const token = localStorage.getItem('token');
-        const response = await fetch(`/read?page=${page}`,{
+        const response = await fetch(`/api/v1/users?page=${page}`,{
             headers: {
                 'Authorization': `Bearer ${token}`,
             },
         });
-        if (!response.ok) throw new Error('Failed to fetch users.');
+        if (!response.ok) throw new Error(await describeError(response));
 
         const users = await response.json();
         let output = '<table border="1"><tr><th>ID</th><th>Name</th><th>Email</th><th>Password</th><th>Created At</th><th>Updated At</th></tr>';
         users.forEach(user => {
             output += `<tr>
                 <td>${user.id}</td>
-                <td>${user.name}</td>
-                <td>${user.email}</td>
-                <td>${user.password}</td>
+                <td>${escapeHTML(user.name)}</td>
+                <td>${escapeHTML(user.email)}</td>
+                <td>${escapeHTML(user.password)}</td>
                 <td>${user.created_at}</td>
                 <td>${user.updated_at}</td>
             </tr>`;
@@ -117,18 +116,19 @@ async function updateUser() {
         return;
     }
 
-    const response = await fetch('/update', {
-        method: 'PUT',
+    const response = await fetch(`/api/v1/users/${parseInt(id)}`, {
+        method: 'PATCH',
         headers: { 
             'Content-Type': 'application/json',
             'Authorization': `Bearer ${token}`,
         },
-        body: JSON.stringify({ id: parseInt(id), name, email, password, role }),
+        body: JSON.stringify({ name, email, password, role }),
     });
-    if (response.ok){
-        const result = await response.json();
-        alert(`User updated successfully: ${JSON.stringify(result)}`);
+    if (!response.ok) {
+        throw new Error(await describeError(response));
     }
+    const result = await response.json();
+    alert(`User updated successfully: ${JSON.stringify(result)}`);
     } catch (err) {
         console.error('Error in updateUser:', err);
         await reportClientError(err.message, 'updateUser', null, null, err.stack || null);
@@ -142,22 +142,19 @@ async function deleteUser() {
         const id = prompt('Enter User ID to delete:');
         if (!id || isNaN(id) || parseInt(id) <= 0) throw new Error('Invalid User ID. Please enter a positive number.');
 
-        const response = await fetch('/delete', {
+        const response = await fetch(`/api/v1/users/${parseInt(id)}`, {
             method: 'DELETE',
-            headers: { 
-                'Content-Type': 'application/json',
+            headers: {
                 'Authorization': `Bearer ${token}`,
             },
-            body: JSON.stringify({ id: parseInt(id) }),
         });
 
         if (!response.ok) {
-            const error = await response.text();
+            const error = await describeError(response);
             throw new Error(`Error deleting user: ${error}`);
         }
 
-        const result = await response.json();
-        alert(`User deleted successfully: ${JSON.stringify(result)}`);
+        alert('User deleted successfully');
     } catch (err) {
         console.error('Error in deleteUser:', err);
         await reportClientError(err.message, 'deleteUser', null, null, err.stack || null);
@@ -171,13 +168,13 @@ async function getUserByID() {
         const token = localStorage.getItem('token');
         if (!id) throw new Error('Please enter a User ID.');
 
-        const response = await fetch(`/readByID?id=${id}`,{
+        const response = await fetch(`/api/v1/users/${encodeURIComponent(id)}`,{
             headers: {
                 'Authorization': `Bearer ${token}`,
             },
         });
         if (!response.ok) {
-            const error = await response.text();
+            const error = await describeError(response);
             throw new Error(`Error fetching user: ${error}`);
         }
 
@@ -185,10 +182,10 @@ async function getUserByID() {
         let output = `<table border="1"><tr><th>ID</th><th>Name</th><th>Email</th><th>Password</th><th>Role</th><th>Created At</th><th>Updated At</th></tr>`;
         output += `<tr>
             <td>${user.id}</td>
-            <td>${user.name}</td>
-            <td>${user.email}</td>
-            <td>${user.password}</td>
-            <td>${user.role}</td>
+            <td>${escapeHTML(user.name)}</td>
+            <td>${escapeHTML(user.email)}</td>
+            <td>${escapeHTML(user.password)}</td>
+            <td>${escapeHTML(user.role)}</td>
             <td>${user.created_at}</td>
             <td>${user.updated_at}</td>
         </tr>`;
@@ -203,26 +200,40 @@ async function getUserByID() {
 async function loadSampleData() {
     try {
         const token = localStorage.getItem('token');
+        const headers = {
+            'Content-Type': 'application/json',
+            'Authorization': `Bearer ${token}`,
+        };
+
+        let response = await fetch('/api/v1/categories', {
+            method: 'POST',
+            headers,
+            body: JSON.stringify({
+                name: 'Language Courses',
+                slug: `language-courses-${Date.now()}`,
+                attributes: [
+                    { key: 'language', label: 'Language', type: 'string', required: true },
+                    { key: 'level', label: 'Level', type: 'enum', options: ['A1', 'A2', 'B1', 'B2', 'C1', 'C2'] },
+                    { key: 'duration_hours', label: 'Duration', type: 'number', min: 1, unit: 'h' },
+                ],
+            }),
+        });
+        if (!response.ok) throw new Error(`Error creating category: ${await describeError(response)}`);
+        const category = await response.json();
+
         const sampleData = [
-            { name: "Product 1", description: "Description of Product 1", price: 100, characteristics: "Feature A", date: "2025-01-01", image: "image1.jpg" },
-            { name: "Product 2", description: "Description of Product 2", price: 200, characteristics: "Feature B", date: "2025-01-02", image: "image2.jpg" },
+            { sku: "SAMPLE-ES-A1", name: "Spanish for Beginners", description: "Start speaking Spanish from day one", price: "100.00", currency: "USD", prices: { EUR: "92.00" }, category_id: category.id, tags: ["Beginner", "Speaking"], characteristics: { language: "Spanish", level: "A1", duration_hours: 20 }, date: "2025-01-01", image: "" },
+            { sku: "SAMPLE-EN-B2", name: "Business English", description: "English for meetings and emails", price: "200.00", currency: "USD", category_id: category.id, tags: ["Business"], characteristics: { language: "English", level: "B2", duration_hours: 40 }, date: "2025-01-02", image: "" },
         ];
 
-        for (const item of sampleData) {
-            const response = await fetch('/create-product', {
-                method: 'POST',
-                headers: { 
-                    'Content-Type': 'application/json',
-                    'Authorization': `Bearer ${token}`,
-                },
-                body: JSON.stringify(item),
-            });
-
-            if (!response.ok) {
-                const error = await response.text();
-                throw new Error(`Error loading item: ${error}`);
-            }
-        }
+        // Importing by SKU makes loading the samples again update them
+        // instead of adding duplicates.
+        response = await fetch('/api/v1/admin/products/import', {
+            method: 'POST',
+            headers,
+            body: JSON.stringify(sampleData),
+        });
+        if (!response.ok) throw new Error(`Error loading items: ${await describeError(response)}`);
 
         alert("Sample data successfully loaded!");
     } catch (err) {
@@ -230,24 +241,109 @@ async function loadSampleData() {
         alert(`Failed to load data: ${err.message}`);
     }
 }
-async function fetchAndDisplayProducts() {
+async function importProducts() {
+    const file = document.getElementById('productImportFile').files[0];
+    if (!file) {
+        alert('Choose a CSV or JSON file to import');
+        return;
+    }
+    const output = document.getElementById('productImportOutput');
     try {
-        const response = await fetch('https://fakestoreapi.com/products');
-        console.log(response.status)
-        if (!response.ok) throw new Error('Failed to fetch products.');
+        const token = localStorage.getItem('token');
+        const format = file.name.toLowerCase().endsWith('.json') ? 'json' : 'csv';
+        const params = new URLSearchParams({ format });
+        if (document.getElementById('productImportDryRun').checked) params.set('dry_run', 'true');
+
+        const response = await fetch(`/api/v1/admin/products/import?${params}`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': format === 'json' ? 'application/json' : 'text/csv',
+                'Authorization': `Bearer ${token}`,
+            },
+            body: file,
+        });
+        if (!response.ok) throw new Error(await describeError(response));
 
-        const products = await response.json();
-        let output = '<table border="1"><tr><th>ID</th><th>Title</th><th>Price</th><th>Description</th><th>Category</th></tr>';
-        products.forEach(product => {
+        const report = await response.json();
+        let summary = `${report.dry_run ? 'Dry run: ' : ''}${report.total} rows, ${report.created} created, ${report.updated} updated, ${report.failed} failed`;
+        if (report.errors.length > 0) {
+            summary += '\n' + report.errors.map(e => `- ${e.field}: ${e.message}`).join('\n');
+        }
+        output.innerText = summary;
+        if (!report.dry_run) fetchAndDisplayProducts();
+    } catch (err) {
+        console.error('Error importing products:', err);
+        output.innerText = `Import failed: ${err.message}`;
+    }
+}
+async function exportProducts(format) {
+    const token = localStorage.getItem('token');
+    const response = await fetch(`/api/v1/admin/products/export?format=${format}`, {
+        headers: {
+            'Authorization': `Bearer ${token}`,
+        },
+    });
+    if (!response.ok) {
+        alert(`Failed to export products: ${await describeError(response)}`);
+        return;
+    }
+    const url = URL.createObjectURL(await response.blob());
+    const link = document.createElement('a');
+    link.href = url;
+    link.download = `products.${format}`;
+    link.click();
+    URL.revokeObjectURL(url);
+}
+async function fetchAndDisplayProducts(page = 1) {
+    try {
+        const token = localStorage.getItem('token');
+        const params = new URLSearchParams({ page });
+        const search = document.getElementById('productSearch').value.trim();
+        if (search) params.set('q', search);
+        const minPrice = document.getElementById('productMinPrice').value;
+        if (minPrice) params.set('min_price', minPrice);
+        const maxPrice = document.getElementById('productMaxPrice').value;
+        if (maxPrice) params.set('max_price', maxPrice);
+        const currency = document.getElementById('productCurrency').value;
+        if (currency) params.set('currency', currency);
+        const attrFilter = document.getElementById('productAttrFilter').value.trim();
+        if (attrFilter) {
+            const [key, value] = attrFilter.split('=');
+            params.set(`attr.${key.trim()}`, (value || '').trim());
+        }
+
+        const response = await fetch(`/api/v1/products?${params}`, {
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const result = await response.json();
+        let output = '<table border="1"><tr><th>ID</th><th>Image</th><th>Name</th><th>Price</th><th>Description</th><th>Characteristics</th><th>Date</th><th></th></tr>';
+        result.data.forEach(product => {
+            const characteristics = Object.entries(product.characteristics || {})
+                .map(([key, value]) => `${escapeHTML(key)}: ${escapeHTML(String(value))}`)
+                .join('<br>');
             output += `<tr>
                 <td>${product.id}</td>
-                <td>${product.title}</td>
-                <td>${product.price}</td>
-                <td>${product.description}</td>
-                <td>${product.category}</td>
+                <td>
+                    ${product.thumbnails ? `<img src="${escapeHTML(product.thumbnails.thumb)}" alt="" width="80">` : ''}
+                    <input type="file" accept="image/jpeg,image/png,image/webp" onchange="uploadProductImage(${product.id}, this.files[0])">
+                </td>
+                <td>${escapeHTML(product.name)}</td>
+                <td>${product.price.formatted}</td>
+                <td>${escapeHTML(product.description)}</td>
+                <td>${characteristics}</td>
+                <td>${product.date.slice(0, 10)}</td>
+                <td><button onclick="deleteProduct(${product.id})">Delete</button></td>
             </tr>`;
         });
         output += '</table>';
+        const pages = Math.max(1, Math.ceil(result.total / result.per_page));
+        output += `<p>Page ${result.page} of ${pages} (${result.total} products)</p>`;
+        if (result.page > 1) output += `<button onclick="fetchAndDisplayProducts(${result.page - 1})">Previous</button>`;
+        if (result.page < pages) output += `<button onclick="fetchAndDisplayProducts(${result.page + 1})">Next</button>`;
 
         document.getElementById('products-output').innerHTML = output;
     } catch (err) {
@@ -255,6 +351,113 @@ async function fetchAndDisplayProducts() {
         alert(`Failed to fetch products: ${err.message}`);
     }
 }
+async function getCategories() {
+    try {
+        const response = await fetch('/api/v1/categories');
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const categories = await response.json();
+        let output = '<table border="1"><tr><th>ID</th><th>Name</th><th>Slug</th><th>Parent</th><th>Attributes</th><th></th></tr>';
+        categories.forEach(category => {
+            const attributes = category.attributes.map(a => `${escapeHTML(a.key)} (${escapeHTML(a.type)}${a.required ? ', required' : ''})`).join('<br>');
+            output += `<tr>
+                <td>${category.id}</td>
+                <td>${escapeHTML(category.name)}</td>
+                <td>${escapeHTML(category.slug)}</td>
+                <td>${category.parent_id || ''}</td>
+                <td>${attributes}</td>
+                <td><button onclick="editCategory(${category.id})">Edit</button></td>
+            </tr>`;
+        });
+        output += '</table>';
+        document.getElementById('categoriesOutput').innerHTML = output;
+        window.loadedCategories = categories;
+    } catch (err) {
+        console.error('Error in getCategories:', err);
+        alert(`Failed to load categories: ${err.message}`);
+    }
+}
+function editCategory(id) {
+    const category = (window.loadedCategories || []).find(c => c.id === id);
+    if (!category) return;
+    document.getElementById('categoryID').value = category.id;
+    document.getElementById('categoryName').value = category.name;
+    document.getElementById('categorySlug').value = category.slug;
+    document.getElementById('categoryParent').value = category.parent_id || '';
+    document.getElementById('categoryAttributes').value = JSON.stringify(category.attributes, null, 2);
+}
+async function saveCategory() {
+    try {
+        const token = localStorage.getItem('token');
+        const id = document.getElementById('categoryID').value;
+        let attributes;
+        try {
+            attributes = JSON.parse(document.getElementById('categoryAttributes').value || '[]');
+        } catch (err) {
+            throw new Error(`Attributes must be a JSON array: ${err.message}`);
+        }
+
+        const response = await fetch(id ? `/api/v1/categories/${id}` : '/api/v1/categories', {
+            method: id ? 'PUT' : 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${token}`,
+            },
+            body: JSON.stringify({
+                name: document.getElementById('categoryName').value,
+                slug: document.getElementById('categorySlug').value,
+                parent_id: Number(document.getElementById('categoryParent').value) || null,
+                attributes,
+            }),
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        document.getElementById('categoryID').value = '';
+        await getCategories();
+    } catch (err) {
+        console.error('Error in saveCategory:', err);
+        alert(`Failed to save category: ${err.message}`);
+    }
+}
+async function uploadProductImage(id, file) {
+    if (!file) return;
+    try {
+        const token = localStorage.getItem('token');
+        const formData = new FormData();
+        formData.append('image', file);
+        const response = await fetch(`/api/v1/products/${id}/image`, {
+            method: 'PUT',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+            body: formData,
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        await fetchAndDisplayProducts();
+    } catch (err) {
+        console.error('Error in uploadProductImage:', err);
+        alert(`Failed to upload image: ${err.message}`);
+    }
+}
+async function deleteProduct(id) {
+    if (!confirm(`Delete product ${id}?`)) return;
+    try {
+        const token = localStorage.getItem('token');
+        const response = await fetch(`/api/v1/products/${id}`, {
+            method: 'DELETE',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        await fetchAndDisplayProducts();
+    } catch (err) {
+        console.error('Error in deleteProduct:', err);
+        alert(`Failed to delete product: ${err.message}`);
+    }
+}
 async function generateFakeUsers() {
     try {
         const fakeUsers = [];
@@ -265,7 +468,6 @@ async function generateFakeUsers() {
                 name: `User${i} Name`,
                 email: `user${i}@example.com`,
                 password: `password${i}`,
-                role: 'user', 
             };
             fakeUsers.push(user);
         }
@@ -278,7 +480,7 @@ async function generateFakeUsers() {
             });
 
             if (!response.ok) {
-                const error = await response.text();
+                const error = await describeError(response);
                 throw new Error(`Failed to create user: ${error}`);
             }
         }
@@ -305,15 +507,15 @@ async function filterUsers() {
                 'Authorization': `Bearer ${token}`,
             },
         });
-        if (!response.ok) throw new Error('Failed to fetch filtered users.');
+        if (!response.ok) throw new Error(await describeError(response));
 
         const users = await response.json();
         let output = '<table border="1"><tr><th>ID</th><th>Name</th><th>Email</th><th>Created At</th><th>Updated At</th></tr>';
         users.forEach(user => {
             output += `<tr>
                 <td>${user.id}</td>
-                <td>${user.name}</td>
-                <td>${user.email}</td>
+                <td>${escapeHTML(user.name)}</td>
+                <td>${escapeHTML(user.email)}</td>
                 <td>${user.created_at}</td>
                 <td>${user.updated_at}</td>
             </tr>`;
@@ -341,15 +543,15 @@ async function sortUsers() {
                 'Authorization': `Bearer ${token}`,
             },
         });
-        if (!response.ok) throw new Error('Failed to fetch sorted users.');
+        if (!response.ok) throw new Error(await describeError(response));
 
         const users = await response.json();
         let output = '<table border="1"><tr><th>ID</th><th>Name</th><th>Email</th><th>Created At</th><th>Updated At</th></tr>';
         users.forEach(user => {
             output += `<tr>
                 <td>${user.id}</td>
-                <td>${user.name}</td>
-                <td>${user.email}</td>
+                <td>${escapeHTML(user.name)}</td>
+                <td>${escapeHTML(user.email)}</td>
                 <td>${user.created_at}</td>
                 <td>${user.updated_at}</td>
             </tr>`;
@@ -390,7 +592,7 @@ async function reportClientError(errorMessage, source, line, column, stack) {
         });
 
         if (!response.ok) {
-            console.error('Failed to log client error:', await response.text());
+            console.error('Failed to log client error:', await describeError(response));
         }
     } catch (err) {
         console.error('Network error while reporting client error:', err);
@@ -405,3 +607,443 @@ window.addEventListener('unhandledrejection', (event) => {
     reportClientError(event.reason?.message || 'Unhandled rejection', null, null, null, event.reason?.stack || null);
 });
 
+async function getFailedEmails() {
+    try {
+        const token = localStorage.getItem('token');
+        const response = await fetch('/api/v1/admin/outbox?status=dead', {
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const result = await response.json();
+        let output = '<table border="1"><tr><th>ID</th><th>To</th><th>Subject</th><th>Attempts</th><th>Last Error</th><th>Created At</th><th></th></tr>';
+        result.data.forEach(entry => {
+            output += `<tr>
+                <td>${entry.id}</td>
+                <td>${escapeHTML(entry.message.to.join(', '))}</td>
+                <td>${escapeHTML(entry.message.subject)}</td>
+                <td>${entry.attempts}</td>
+                <td>${escapeHTML(entry.last_error)}</td>
+                <td>${entry.created_at}</td>
+                <td><button onclick="retryEmail(${entry.id})">Retry</button></td>
+            </tr>`;
+        });
+        output += '</table>';
+        document.getElementById('outboxOutput').innerHTML = output;
+    } catch (err) {
+        console.error('Error in getFailedEmails:', err);
+        alert(`Failed to load failed emails: ${err.message}`);
+    }
+}
+async function retryEmail(id) {
+    try {
+        const token = localStorage.getItem('token');
+        const response = await fetch(`/api/v1/admin/outbox/${id}/retry`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        alert('Email rescheduled for delivery.');
+        getFailedEmails();
+    } catch (err) {
+        console.error('Error in retryEmail:', err);
+        alert(`Failed to retry email: ${err.message}`);
+    }
+}
+const orderTransitions = {
+    pending: ['paid', 'cancelled'],
+};
+
+async function getOrders(page = 1) {
+    try {
+        const token = localStorage.getItem('token');
+        const params = new URLSearchParams({ page });
+        const status = document.getElementById('orderStatus').value;
+        if (status) params.set('status', status);
+        const response = await fetch(`/api/v1/admin/orders?${params}`, {
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const result = await response.json();
+        let output = '<table border="1"><tr><th>ID</th><th>User</th><th>Items</th><th>Total</th><th>Status</th><th>Created At</th><th></th></tr>';
+        result.data.forEach(order => {
+            const items = (order.items || []).map(item => `${escapeHTML(item.product_name)} × ${item.quantity}`).join('<br>');
+            let actions = (orderTransitions[order.status] || [])
+                .map(status => `<button onclick="setOrderStatus(${order.id}, '${status}')">Mark ${status}</button>`)
+                .join(' ');
+            if (order.status === 'paid') actions += ` <button onclick="refundOrder(${order.id})">Refund payment</button>`;
+            if (order.invoice) actions += ` <button onclick="downloadInvoice(${order.id})">Invoice ${escapeHTML(order.invoice.number)}</button>`;
+            output += `<tr>
+                <td>${order.id}</td>
+                <td>${order.user_id}</td>
+                <td>${items}</td>
+                <td>${order.total.formatted}${order.coupon_code ? `<br><small>${order.coupon_code}: −${order.discount.formatted}</small>` : ''}</td>
+                <td>${order.status}</td>
+                <td>${order.created_at}</td>
+                <td>${actions}</td>
+            </tr>`;
+        });
+        output += '</table>';
+        const pages = Math.max(1, Math.ceil(result.total / result.per_page));
+        output += `<p>Page ${result.page} of ${pages} (${result.total} orders)</p>`;
+        if (result.page > 1) output += `<button onclick="getOrders(${result.page - 1})">Previous</button>`;
+        if (result.page < pages) output += `<button onclick="getOrders(${result.page + 1})">Next</button>`;
+        document.getElementById('ordersOutput').innerHTML = output;
+    } catch (err) {
+        console.error('Error in getOrders:', err);
+        alert(`Failed to load orders: ${err.message}`);
+    }
+}
+function parseIDList(value) {
+    return value.split(',').map(id => Number(id.trim())).filter(id => id > 0);
+}
+async function createCoupon() {
+    const kind = document.getElementById('couponKind').value;
+    const value = document.getElementById('couponValue').value.trim();
+    const maxUses = document.getElementById('couponMaxUses').value;
+    const maxUsesUser = document.getElementById('couponMaxUsesUser').value;
+    const endsAt = document.getElementById('couponEndsAt').value;
+    const coupon = {
+        code: document.getElementById('couponCode').value,
+        kind,
+        currency: document.getElementById('couponCurrency').value,
+        max_uses: maxUses ? Number(maxUses) : null,
+        max_uses_per_user: maxUsesUser ? Number(maxUsesUser) : null,
+        ends_at: endsAt ? new Date(endsAt).toISOString() : null,
+        product_ids: parseIDList(document.getElementById('couponProducts').value),
+        category_ids: parseIDList(document.getElementById('couponCategories').value),
+        active: true,
+    };
+    if (kind === 'percent') coupon.percent_off = Number(value);
+    else coupon.amount_off = value;
+
+    try {
+        const token = localStorage.getItem('token');
+        const response = await fetch('/api/v1/admin/coupons', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${token}`,
+            },
+            body: JSON.stringify(coupon),
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+        getCoupons();
+    } catch (err) {
+        console.error('Error in createCoupon:', err);
+        alert(`Failed to create coupon: ${err.message}`);
+    }
+}
+async function getCoupons() {
+    try {
+        const token = localStorage.getItem('token');
+        const response = await fetch('/api/v1/admin/coupons', {
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const coupons = await response.json();
+        let output = '<table border="1"><tr><th>Code</th><th>Discount</th><th>Uses</th><th>Valid until</th><th>Restricted to</th><th>Active</th><th></th></tr>';
+        coupons.forEach(coupon => {
+            const discount = coupon.kind === 'percent' ? `${coupon.percent_off}%` : coupon.amount_off.formatted;
+            const limits = [coupon.max_uses ? `${coupon.uses} / ${coupon.max_uses}` : `${coupon.uses}`];
+            if (coupon.max_uses_per_user) limits.push(`${coupon.max_uses_per_user} per user`);
+            const restrictions = [];
+            if ((coupon.product_ids || []).length) restrictions.push(`products ${coupon.product_ids.join(', ')}`);
+            if ((coupon.category_ids || []).length) restrictions.push(`categories ${coupon.category_ids.join(', ')}`);
+            output += `<tr>
+                <td>${coupon.code}</td>
+                <td>${discount}</td>
+                <td>${limits.join('<br>')}</td>
+                <td>${coupon.ends_at || '—'}</td>
+                <td>${restrictions.join('<br>') || 'Everything'}</td>
+                <td>${coupon.active ? 'Yes' : 'No'}</td>
+                <td><button onclick="deleteCoupon(${coupon.id})">Delete</button></td>
+            </tr>`;
+        });
+        output += '</table>';
+        document.getElementById('couponsOutput').innerHTML = output;
+    } catch (err) {
+        console.error('Error in getCoupons:', err);
+        alert(`Failed to load coupons: ${err.message}`);
+    }
+}
+async function getReviews() {
+    try {
+        const token = localStorage.getItem('token');
+        const status = document.getElementById('reviewStatus').value;
+        const response = await fetch(`/api/v1/admin/reviews?status=${status}`, {
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const result = await response.json();
+        let output = `<p>${result.total} ${status} reviews</p>`;
+        output += '<table border="1"><tr><th>Product</th><th>Author</th><th>Rating</th><th>Review</th><th>Helpful</th><th>Date</th><th></th></tr>';
+        result.data.forEach(review => {
+            const actions = [];
+            if (review.status !== 'approved') actions.push(`<button onclick="moderateReview(${review.id}, 'approved')">Approve</button>`);
+            if (review.status !== 'hidden') actions.push(`<button onclick="moderateReview(${review.id}, 'hidden')">Hide</button>`);
+            output += `<tr>
+                <td>${review.product_id}</td>
+                <td>${escapeHTML(review.author)}</td>
+                <td>${'★'.repeat(review.rating)}</td>
+                <td><strong>${escapeHTML(review.title)}</strong><br>${escapeHTML(review.body)}</td>
+                <td>${review.helpful_count}</td>
+                <td>${new Date(review.created_at).toLocaleDateString()}</td>
+                <td>${actions.join(' ')}</td>
+            </tr>`;
+        });
+        output += '</table>';
+        document.getElementById('reviewsOutput').innerHTML = output;
+    } catch (err) {
+        console.error('Error in getReviews:', err);
+        alert(`Failed to load reviews: ${err.message}`);
+    }
+}
+async function moderateReview(id, status) {
+    try {
+        const token = localStorage.getItem('token');
+        const response = await fetch(`/api/v1/admin/reviews/${id}`, {
+            method: 'PATCH',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${token}`,
+            },
+            body: JSON.stringify({ status }),
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+        getReviews();
+    } catch (err) {
+        console.error('Error in moderateReview:', err);
+        alert(`Failed to moderate review: ${err.message}`);
+    }
+}
+async function deleteCoupon(id) {
+    if (!confirm('Delete this coupon?')) return;
+    try {
+        const token = localStorage.getItem('token');
+        const response = await fetch(`/api/v1/admin/coupons/${id}`, {
+            method: 'DELETE',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+        getCoupons();
+    } catch (err) {
+        console.error('Error in deleteCoupon:', err);
+        alert(`Failed to delete coupon: ${err.message}`);
+    }
+}
+async function downloadInvoice(orderId) {
+    const token = localStorage.getItem('token');
+    const response = await fetch(`/api/v1/orders/${orderId}/invoice`, {
+        headers: {
+            'Authorization': `Bearer ${token}`,
+        },
+    });
+    if (!response.ok) {
+        alert(`Failed to download invoice: ${await describeError(response)}`);
+        return;
+    }
+    const url = URL.createObjectURL(await response.blob());
+    const link = document.createElement('a');
+    link.href = url;
+    const disposition = response.headers.get('Content-Disposition') || '';
+    const match = disposition.match(/filename="?([^";]+)"?/);
+    link.download = match ? match[1] : `invoice-${orderId}.pdf`;
+    link.click();
+    URL.revokeObjectURL(url);
+}
+async function setOrderStatus(id, status) {
+    try {
+        const token = localStorage.getItem('token');
+        const response = await fetch(`/api/v1/admin/orders/${id}`, {
+            method: 'PATCH',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${token}`,
+            },
+            body: JSON.stringify({ status }),
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        getOrders();
+    } catch (err) {
+        console.error('Error in setOrderStatus:', err);
+        alert(`Failed to update order: ${err.message}`);
+    }
+}
+async function refundOrder(id) {
+    if (!confirm(`Refund order #${id} through the payment provider?`)) return;
+    try {
+        const token = localStorage.getItem('token');
+        const response = await fetch(`/api/v1/admin/orders/${id}/refund`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        getOrders();
+    } catch (err) {
+        console.error('Error in refundOrder:', err);
+        alert(`Failed to refund order: ${err.message}`);
+    }
+}
+let emailTemplates = [];
+async function getEmailTemplates() {
+    try {
+        const token = localStorage.getItem('token');
+        const response = await fetch('/api/v1/admin/email-templates', {
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        emailTemplates = await response.json();
+        let output = '<table border="1"><tr><th>Name</th><th>Locale</th><th>Subject</th><th>Customized</th><th></th></tr>';
+        emailTemplates.forEach((tpl, index) => {
+            output += `<tr>
+                <td>${tpl.name}</td>
+                <td>${tpl.locale}</td>
+                <td>${escapeHTML(tpl.subject)}</td>
+                <td>${tpl.overridden ? 'Yes' : 'No'}</td>
+                <td><button onclick="editEmailTemplate(${index})">Edit</button></td>
+            </tr>`;
+        });
+        output += '</table>';
+        document.getElementById('emailTemplatesOutput').innerHTML = output;
+    } catch (err) {
+        console.error('Error in getEmailTemplates:', err);
+        alert(`Failed to load email templates: ${err.message}`);
+    }
+}
+function editEmailTemplate(index) {
+    const tpl = emailTemplates[index];
+    document.getElementById('templateName').value = tpl.name;
+    document.getElementById('templateLocale').value = tpl.locale;
+    document.getElementById('templateTitle').textContent = `${tpl.name} (${tpl.locale})`;
+    document.getElementById('templateSubject').value = tpl.subject;
+    document.getElementById('templateText').value = tpl.text;
+    document.getElementById('templateHTML').value = tpl.html;
+    document.getElementById('emailTemplateEditor').style.display = 'block';
+}
+async function saveEmailTemplate() {
+    try {
+        const token = localStorage.getItem('token');
+        const name = document.getElementById('templateName').value;
+        const locale = document.getElementById('templateLocale').value;
+        const response = await fetch(`/api/v1/admin/email-templates/${name}/${locale}`, {
+            method: 'PUT',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${token}`,
+            },
+            body: JSON.stringify({
+                subject: document.getElementById('templateSubject').value,
+                text: document.getElementById('templateText').value,
+                html: document.getElementById('templateHTML').value,
+            }),
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        alert('Template saved.');
+        getEmailTemplates();
+    } catch (err) {
+        console.error('Error in saveEmailTemplate:', err);
+        alert(`Failed to save template: ${err.message}`);
+    }
+}
+async function resetEmailTemplate() {
+    try {
+        const token = localStorage.getItem('token');
+        const name = document.getElementById('templateName').value;
+        const locale = document.getElementById('templateLocale').value;
+        const response = await fetch(`/api/v1/admin/email-templates/${name}/${locale}`, {
+            method: 'DELETE',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        document.getElementById('emailTemplateEditor').style.display = 'none';
+        getEmailTemplates();
+    } catch (err) {
+        console.error('Error in resetEmailTemplate:', err);
+        alert(`Failed to reset template: ${err.message}`);
+    }
+}
+async function getTicketQueue() {
+    try {
+        const token = localStorage.getItem('token');
+        const params = new URLSearchParams();
+        const status = document.getElementById('queueStatus').value;
+        if (status) params.set('status', status);
+        if (document.getElementById('queueBreached').checked) params.set('breached', 'true');
+
+        const response = await fetch(`/api/v1/helpdesk/tickets?${params}`, {
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const result = await response.json();
+        let output = '<table border="1"><tr><th>#</th><th>Subject</th><th>Customer</th><th>Priority</th><th>Status</th><th>Assignee</th><th>Resolve By</th><th>SLA</th><th></th></tr>';
+        result.data.forEach(ticket => {
+            const breached = ticket.sla.first_response_breached || ticket.sla.resolution_breached;
+            output += `<tr>
+                <td>${ticket.id}</td>
+                <td>${escapeHTML(ticket.subject)}</td>
+                <td>${escapeHTML(ticket.email)}</td>
+                <td>${escapeHTML(ticket.priority)}</td>
+                <td>${escapeHTML(ticket.status)}</td>
+                <td>${ticket.assignee_id ?? ''}</td>
+                <td>${new Date(ticket.resolution_due_at).toLocaleString()}</td>
+                <td>${breached ? 'Breached' : 'OK'}</td>
+                <td><button onclick="resolveTicket(${ticket.id})">Resolve</button></td>
+            </tr>`;
+        });
+        output += '</table>';
+        document.getElementById('ticketQueueOutput').innerHTML = output;
+    } catch (err) {
+        console.error('Error in getTicketQueue:', err);
+        alert(`Failed to load support queue: ${err.message}`);
+    }
+}
+async function resolveTicket(id) {
+    try {
+        const token = localStorage.getItem('token');
+        const response = await fetch(`/api/v1/helpdesk/tickets/${id}`, {
+            method: 'PATCH',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${token}`,
+            },
+            body: JSON.stringify({ status: 'resolved' }),
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        getTicketQueue();
+    } catch (err) {
+        console.error('Error in resolveTicket:', err);
+        alert(`Failed to resolve ticket: ${err.message}`);
+    }
+}
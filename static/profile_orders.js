@@ -0,0 +1,303 @@
+async function ordersRequest(url, options = {}) {
+    const token = localStorage.getItem('token');
+    const response = await fetch(url, {
+        ...options,
+        headers: {
+            'Content-Type': 'application/json',
+            'Authorization': `Bearer ${token}`,
+        },
+    });
+    if (!response.ok) throw new Error(await describeError(response));
+    return response.status === 204 ? null : response.json();
+}
+
+async function loadMyCart() {
+    if (!localStorage.getItem('token')) return;
+
+    try {
+        const cart = await ordersRequest('/api/v1/cart');
+        if (cart.items.length === 0) {
+            document.getElementById('cartOutput').innerHTML = '<p>Your cart is empty.</p>';
+            return;
+        }
+        let output = '<table border="1"><tr><th>Product</th><th>Quantity</th><th>Total</th><th></th></tr>';
+        cart.items.forEach(item => {
+            output += `<tr>
+                <td>${escapeHTML(item.product.name)}</td>
+                <td><input type="number" min="1" max="99" value="${item.quantity}" onchange="setCartQuantity(${item.product_id}, this.value)"></td>
+                <td>${item.total ? escapeHTML(item.total.formatted) : `Not sold in ${cart.currency}`}</td>
+                <td><button onclick="removeFromCart(${item.product_id})">Remove</button></td>
+            </tr>`;
+        });
+        output += `</table><p><strong>Total: ${escapeHTML(cart.total.formatted)}</strong></p>
+            <input type="text" id="couponInput" placeholder="Coupon code">
+            <button onclick="applyCoupon()">Apply</button>
+            <div id="couponOutput"></div>
+            <button onclick="checkout()">Place Order</button>`;
+        document.getElementById('cartOutput').innerHTML = output;
+    } catch (err) {
+        console.error('Error loading cart:', err);
+        alert(`Failed to load cart: ${err.message}`);
+    }
+}
+
+async function setCartQuantity(productId, quantity) {
+    try {
+        await ordersRequest(`/api/v1/cart/items/${productId}`, {
+            method: 'PUT',
+            body: JSON.stringify({ quantity: Number(quantity) }),
+        });
+    } catch (err) {
+        alert(`Failed to update cart: ${err.message}`);
+    }
+    loadMyCart();
+}
+
+async function removeFromCart(productId) {
+    try {
+        await ordersRequest(`/api/v1/cart/items/${productId}`, { method: 'DELETE' });
+    } catch (err) {
+        alert(`Failed to update cart: ${err.message}`);
+    }
+    loadMyCart();
+}
+
+function couponCode() {
+    const input = document.getElementById('couponInput');
+    return input ? input.value.trim() : '';
+}
+
+async function applyCoupon() {
+    const output = document.getElementById('couponOutput');
+    try {
+        const quote = await ordersRequest('/api/v1/cart/quote', {
+            method: 'POST',
+            body: JSON.stringify({ coupon: couponCode() }),
+        });
+        output.innerHTML = quote.discount
+            ? `<p>Discount: −${escapeHTML(quote.discount.formatted)}. You pay <strong>${escapeHTML(quote.total.formatted)}</strong>.</p>`
+            : '';
+    } catch (err) {
+        output.innerHTML = `<p>${escapeHTML(err.message)}</p>`;
+    }
+}
+
+async function checkout() {
+    try {
+        const order = await ordersRequest('/api/v1/orders', {
+            method: 'POST',
+            body: JSON.stringify({ coupon: couponCode() }),
+        });
+        if (order.status === 'paid') {
+            alert(`Order #${order.id} placed and paid in full by your coupon.`);
+        } else {
+            alert(`Order #${order.id} placed. Total: ${order.total.formatted}`);
+        }
+    } catch (err) {
+        alert(`Failed to place order: ${err.message}`);
+    }
+    loadMyCart();
+    loadMyOrders();
+}
+
+async function loadMyOrders() {
+    if (!localStorage.getItem('token')) return;
+
+    try {
+        const orders = await ordersRequest('/api/v1/orders');
+        if (orders.length === 0) {
+            document.getElementById('ordersOutput').innerHTML = '<p>You have no orders yet.</p>';
+            return;
+        }
+        let output = '<table border="1"><tr><th>#</th><th>Date</th><th>Items</th><th>Total</th><th>Status</th><th></th></tr>';
+        orders.forEach(order => {
+            const items = (order.items || [])
+                .map(item => `${escapeHTML(item.product_name)} × ${item.quantity}`)
+                .join('<br>');
+            output += `<tr>
+                <td>${order.id}</td>
+                <td>${new Date(order.created_at).toLocaleString()}</td>
+                <td>${items}</td>
+                <td>${escapeHTML(order.total.formatted)}${order.discount ? `<br><small>${escapeHTML(order.coupon_code)}: −${escapeHTML(order.discount.formatted)}</small>` : ''}</td>
+                <td>${order.status}</td>
+                <td>${order.status === 'pending' ? `<button onclick="payOrder(${order.id})">Pay</button> <button onclick="cancelMyOrder(${order.id})">Cancel</button>` : ''}${order.invoice ? `<button onclick="downloadInvoice(${order.id}, '${escapeHTML(order.invoice.number)}')">Receipt</button>` : ''}</td>
+            </tr>`;
+        });
+        output += '</table>';
+        document.getElementById('ordersOutput').innerHTML = output;
+    } catch (err) {
+        console.error('Error loading orders:', err);
+        alert(`Failed to load orders: ${err.message}`);
+    }
+}
+
+async function payOrder(id) {
+    try {
+        const payment = await ordersRequest(`/api/v1/orders/${id}/checkout`, { method: 'POST' });
+        window.location.href = payment.checkout_url;
+    } catch (err) {
+        alert(`Failed to start payment: ${err.message}`);
+    }
+}
+
+async function downloadInvoice(orderId, number) {
+    const token = localStorage.getItem('token');
+    const response = await fetch(`/api/v1/orders/${orderId}/invoice`, {
+        headers: {
+            'Authorization': `Bearer ${token}`,
+        },
+    });
+    if (!response.ok) {
+        alert(`Failed to download receipt: ${await describeError(response)}`);
+        return;
+    }
+    const url = URL.createObjectURL(await response.blob());
+    const link = document.createElement('a');
+    link.href = url;
+    link.download = `${number}.pdf`;
+    link.click();
+    URL.revokeObjectURL(url);
+}
+
+async function loadMyEnrollments() {
+    if (!localStorage.getItem('token')) return;
+
+    try {
+        const enrollments = await ordersRequest('/api/v1/enrollments');
+        if (enrollments.length === 0) {
+            document.getElementById('enrollmentsOutput').innerHTML = '<p>You are not enrolled in any courses yet.</p>';
+            return;
+        }
+        let output = '<ul>';
+        enrollments.forEach(enrollment => {
+            output += `<li>${escapeHTML(enrollment.product.name)} <small>since ${new Date(enrollment.created_at).toLocaleDateString()}</small>
+                <button onclick="reviewCourse(${enrollment.product_id})">Write a review</button></li>`;
+        });
+        output += '</ul>';
+        document.getElementById('enrollmentsOutput').innerHTML = output;
+    } catch (err) {
+        console.error('Error loading enrollments:', err);
+        alert(`Failed to load courses: ${err.message}`);
+    }
+}
+
+async function reviewCourse(productId) {
+    const rating = parseInt(prompt('Rate the course from 1 to 5 stars'), 10);
+    if (!rating) return;
+    const title = prompt('Review title') || '';
+    const body = prompt('What did you think of the course?') || '';
+    try {
+        await ordersRequest(`/api/v1/catalog/products/${productId}/reviews`, {
+            method: 'POST',
+            body: JSON.stringify({ rating, title, body }),
+        });
+        alert('Thank you! Your review will appear once it is approved.');
+    } catch (err) {
+        console.error('Error creating review:', err);
+        alert(`Failed to submit review: ${err.message}`);
+    }
+}
+
+async function loadMySubscription() {
+    if (!localStorage.getItem('token')) return;
+
+    const container = document.getElementById('subscriptionOutput');
+    try {
+        const subscription = await ordersRequest('/api/v1/subscription').catch(err => {
+            if (err.message.includes('no subscription')) return null;
+            throw err;
+        });
+        if (subscription) {
+            const until = new Date(subscription.current_period_end).toLocaleDateString();
+            let output = `<p><strong>${escapeHTML(subscription.plan.name)}</strong> (${escapeHTML(subscription.plan.price.formatted)}) — ${subscription.status}</p>`;
+            if (subscription.status === 'incomplete') {
+                output += `<button onclick="payOrder(${subscription.pending_order_id})">Pay</button> `;
+            } else if (subscription.grace_until) {
+                output += `<p>Your renewal payment is due. Access continues until ${new Date(subscription.grace_until).toLocaleDateString()}.</p>
+                    <button onclick="payOrder(${subscription.pending_order_id})">Pay renewal</button> `;
+            } else if (subscription.cancel_at_period_end) {
+                output += `<p>Ends on ${until}.</p><button onclick="resumeSubscription()">Resume</button> `;
+            } else {
+                output += `<p>${subscription.status === 'trialing' ? 'Trial ends' : 'Renews'} on ${until}.</p>`;
+            }
+            if (!subscription.cancel_at_period_end) {
+                output += '<button onclick="cancelMySubscription()">Cancel subscription</button>';
+            }
+            container.innerHTML = output;
+            return;
+        }
+
+        const plans = await ordersRequest('/api/v1/plans');
+        if (plans.length === 0) {
+            container.innerHTML = '<p>No subscription plans are available.</p>';
+            return;
+        }
+        let output = '<ul>';
+        plans.forEach(plan => {
+            const trial = plan.trial_days > 0 ? ` — ${plan.trial_days}-day free trial` : '';
+            output += `<li><strong>${escapeHTML(plan.name)}</strong>: ${escapeHTML(plan.price.formatted)} every ${plan.interval_count} ${plan.interval}(s)${trial}
+                <button onclick="subscribeToPlan('${escapeHTML(plan.code)}')">Subscribe</button></li>`;
+        });
+        output += '</ul>';
+        container.innerHTML = output;
+    } catch (err) {
+        console.error('Error loading subscription:', err);
+        alert(`Failed to load subscription: ${err.message}`);
+    }
+}
+
+async function subscribeToPlan(code) {
+    try {
+        const subscription = await ordersRequest('/api/v1/subscription', {
+            method: 'POST',
+            body: JSON.stringify({ plan: code }),
+        });
+        if (subscription.status === 'incomplete') {
+            payOrder(subscription.pending_order_id);
+            return;
+        }
+    } catch (err) {
+        alert(`Failed to subscribe: ${err.message}`);
+    }
+    loadMySubscription();
+}
+
+async function cancelMySubscription() {
+    if (!confirm('Cancel your subscription? You keep access until the end of the current period.')) return;
+    try {
+        await ordersRequest('/api/v1/subscription', { method: 'DELETE' });
+    } catch (err) {
+        alert(`Failed to cancel subscription: ${err.message}`);
+    }
+    loadMySubscription();
+    loadMyOrders();
+}
+
+async function resumeSubscription() {
+    try {
+        await ordersRequest('/api/v1/subscription/resume', { method: 'POST' });
+    } catch (err) {
+        alert(`Failed to resume subscription: ${err.message}`);
+    }
+    loadMySubscription();
+}
+
+async function cancelMyOrder(id) {
+    if (!confirm(`Cancel order #${id}?`)) return;
+    try {
+        await ordersRequest(`/api/v1/orders/${id}/cancel`, { method: 'POST' });
+    } catch (err) {
+        alert(`Failed to cancel order: ${err.message}`);
+    }
+    loadMyOrders();
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    const payment = new URLSearchParams(window.location.search).get('payment');
+    if (payment === 'success') alert('Thank you! Your payment was received.');
+    if (payment === 'cancelled') alert('The payment was cancelled. You can pay for the order later.');
+    loadMyCart();
+    loadMyOrders();
+    loadMyEnrollments();
+    loadMySubscription();
+});
@@ -14,7 +14,7 @@ document.addEventListener('DOMContentLoaded', async () => {
     const passwordField = document.getElementById('password');
 
     try {
-        const response = await fetch(`/readByIDprof?id=${user.id}`, {
+        const response = await fetch(`/api/v1/users/${user.id}`, {
             headers: {
                 'Authorization': `Bearer ${token}`,
             },
@@ -31,7 +31,7 @@ document.addEventListener('DOMContentLoaded', async () => {
                 localStorage.removeItem('user');
                 window.location.href = '/';
             } else {
-                alert('Failed to load profile data.');
+                alert(`Failed to load profile data: ${await describeError(response)}`);
             }
         }
     } catch (error) {
@@ -41,15 +41,14 @@ document.addEventListener('DOMContentLoaded', async () => {
     form.addEventListener('submit', async (e) => {
         e.preventDefault();
         const updatedData = {
-            id: user.id,
             name: usernameField.value,
             email: emailField.value,
             password: passwordField.value,
         };
 
         try {
-            const response = await fetch('/update', {
-                method: 'PUT',
+            const response = await fetch(`/api/v1/users/${user.id}`, {
+                method: 'PATCH',
                 headers: {
                     'Content-Type': 'application/json',
                     'Authorization': `Bearer ${token}`,
@@ -60,7 +59,7 @@ document.addEventListener('DOMContentLoaded', async () => {
             if (response.ok) {
                 alert('Profile updated successfully!');
             } else {
-                alert('Failed to update profile.');
+                alert(`Failed to update profile: ${await describeError(response)}`);
             }
         } catch (error) {
             console.error('Error updating profile:', error);
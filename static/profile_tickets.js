@@ -0,0 +1,113 @@
+async function loadMyTickets() {
+    const token = localStorage.getItem('token');
+    if (!token) return;
+
+    try {
+        const response = await fetch('/api/v1/tickets', {
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const tickets = await response.json();
+        if (tickets.length === 0) {
+            document.getElementById('ticketsOutput').innerHTML = '<p>You have not contacted support yet.</p>';
+            return;
+        }
+        let output = '<table border="1"><tr><th>#</th><th>Subject</th><th>Status</th><th>Updated</th><th></th></tr>';
+        tickets.forEach(ticket => {
+            output += `<tr>
+                <td>${ticket.id}</td>
+                <td>${escapeHTML(ticket.subject)}</td>
+                <td>${ticket.status}</td>
+                <td>${new Date(ticket.updated_at).toLocaleString()}</td>
+                <td><button onclick="showTicket(${ticket.id})">Open</button></td>
+            </tr>`;
+        });
+        output += '</table>';
+        document.getElementById('ticketsOutput').innerHTML = output;
+    } catch (err) {
+        console.error('Error loading tickets:', err);
+        alert(`Failed to load tickets: ${err.message}`);
+    }
+}
+
+async function showTicket(id) {
+    const token = localStorage.getItem('token');
+    try {
+        const response = await fetch(`/api/v1/tickets/${id}`, {
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        const ticket = await response.json();
+        let output = `<h3>#${ticket.id} ${escapeHTML(ticket.subject)} (${ticket.status})</h3>`;
+        (ticket.messages || []).forEach(message => {
+            const author = message.author_role === 'agent' ? 'Support' : 'You';
+            output += `<div>
+                <strong>${author}</strong> <small>${new Date(message.created_at).toLocaleString()}</small>
+                <p style="white-space: pre-wrap;">${escapeHTML(message.body)}</p>`;
+            (message.attachments || []).forEach(attachment => {
+                output += `<button onclick="downloadAttachment(${ticket.id}, ${attachment.id}, '${escapeHTML(attachment.filename).replace(/'/g, '')}')">${escapeHTML(attachment.filename)}</button>`;
+            });
+            output += '</div><hr>';
+        });
+        output += `<form id="ticketReplyForm">
+            <textarea name="body" rows="4" cols="60" placeholder="Reply to support" required></textarea><br>
+            <input type="file" name="file"><br>
+            <button type="submit">Send Reply</button>
+        </form>`;
+        document.getElementById('ticketDetail').innerHTML = output;
+        document.getElementById('ticketReplyForm').addEventListener('submit', async (e) => {
+            e.preventDefault();
+            await replyToTicket(ticket.id, new FormData(e.target));
+        });
+    } catch (err) {
+        console.error('Error loading ticket:', err);
+        alert(`Failed to load ticket: ${err.message}`);
+    }
+}
+
+async function replyToTicket(id, formData) {
+    const token = localStorage.getItem('token');
+    try {
+        const response = await fetch(`/api/v1/tickets/${id}/messages`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${token}`,
+            },
+            body: formData,
+        });
+        if (!response.ok) throw new Error(await describeError(response));
+
+        showTicket(id);
+        loadMyTickets();
+    } catch (err) {
+        console.error('Error replying to ticket:', err);
+        alert(`Failed to send reply: ${err.message}`);
+    }
+}
+
+async function downloadAttachment(ticketID, attachmentID, filename) {
+    const token = localStorage.getItem('token');
+    const response = await fetch(`/api/v1/tickets/${ticketID}/attachments/${attachmentID}`, {
+        headers: {
+            'Authorization': `Bearer ${token}`,
+        },
+    });
+    if (!response.ok) {
+        alert(`Failed to download attachment: ${await describeError(response)}`);
+        return;
+    }
+    const url = URL.createObjectURL(await response.blob());
+    const link = document.createElement('a');
+    link.href = url;
+    link.download = filename;
+    link.click();
+    URL.revokeObjectURL(url);
+}
+
+document.addEventListener('DOMContentLoaded', loadMyTickets);
@@ -3,19 +3,20 @@ document.getElementById('signupForm').addEventListener('submit', async (e) => {
     const name = document.getElementById('username').value;
     const email = document.getElementById('email').value;
     const password = document.getElementById('password').value;
+    const locale = document.getElementById('locale').value;
 
     const response = await fetch('/create', {
         method: 'POST',
         headers: {
             'Content-Type': 'application/json'
         },
-        body: JSON.stringify({ name, email, password })
+        body: JSON.stringify({ name, email, password, locale })
     });
 
     if (response.ok) {
         alert('User registered successfully');
         window.location.href = '/main_page.html';
     } else {
-        alert('Signup failed');
+        alert(`Signup failed: ${await describeError(response)}`);
     }
 });
@@ -0,0 +1,212 @@
+// Package storage saves uploaded blobs behind a Store backend (local disk,
+// S3-compatible object storage or memory) after enforcing size and type
+// limits, computing a checksum and passing the content to a Scanner.
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	ErrNotFound       = errors.New("storage: object not found")
+	ErrTooLarge       = errors.New("storage: file exceeds the size limit")
+	ErrTypeNotAllowed = errors.New("storage: file type is not allowed")
+	ErrInfected       = errors.New("storage: file failed the malware scan")
+)
+
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Scanner inspects the full content of an upload before it is stored. It
+// should return an error wrapping ErrInfected to reject the file.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) error
+}
+
+type ScannerFunc func(ctx context.Context, r io.Reader) error
+
+func (f ScannerFunc) Scan(ctx context.Context, r io.Reader) error {
+	return f(ctx, r)
+}
+
+// Policy limits what Save accepts. AllowedTypes entries ending in "/" match
+// a whole family, e.g. "image/".
+type Policy struct {
+	MaxBytes     int64
+	AllowedTypes []string
+}
+
+func (p Policy) allows(contentType string) bool {
+	if len(p.AllowedTypes) == 0 {
+		return true
+	}
+	for _, t := range p.AllowedTypes {
+		if t == contentType || strings.HasSuffix(t, "/") && strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+type Object struct {
+	Key         string `json:"key"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+type Service struct {
+	Store   Store
+	Scanner Scanner
+}
+
+// Upload is content that passed Inspect, spooled to a temporary file until
+// Close is called.
+type Upload struct {
+	ContentType string
+	Size        int64
+	SHA256      string
+
+	file *os.File
+}
+
+func (u *Upload) Reader() io.Reader {
+	return io.NewSectionReader(u.file, 0, u.Size)
+}
+
+func (u *Upload) Close() error {
+	u.file.Close()
+	return os.Remove(u.file.Name())
+}
+
+// Inspect spools r to a temporary file so the content can be sniffed, hashed
+// and scanned before anything reaches the store.
+func (s *Service) Inspect(ctx context.Context, r io.Reader, p Policy) (*Upload, error) {
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	u := &Upload{file: tmp}
+	if err := s.inspect(ctx, u, r, p); err != nil {
+		u.Close()
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *Service) inspect(ctx context.Context, u *Upload, r io.Reader, p Policy) error {
+	src := r
+	if p.MaxBytes > 0 {
+		src = io.LimitReader(r, p.MaxBytes+1)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(u.file, hash), src)
+	if err != nil {
+		return err
+	}
+	if p.MaxBytes > 0 && size > p.MaxBytes {
+		return ErrTooLarge
+	}
+	u.Size = size
+	u.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	head := make([]byte, 512)
+	n, err := u.file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	u.ContentType = http.DetectContentType(head[:n])
+	if mediaType, _, found := strings.Cut(u.ContentType, ";"); found {
+		u.ContentType = mediaType
+	}
+	if !p.allows(u.ContentType) {
+		return fmt.Errorf("%w: %s", ErrTypeNotAllowed, u.ContentType)
+	}
+
+	if s.Scanner != nil {
+		return s.Scanner.Scan(ctx, u.Reader())
+	}
+	return nil
+}
+
+// Save inspects r and stores it under prefix/YYYY/MM/<random>-<name>.
+func (s *Service) Save(ctx context.Context, prefix, filename string, r io.Reader, p Policy) (*Object, error) {
+	u, err := s.Inspect(ctx, r, p)
+	if err != nil {
+		return nil, err
+	}
+	defer u.Close()
+
+	obj := &Object{
+		Filename:    SanitizeFilename(filename),
+		ContentType: u.ContentType,
+		Size:        u.Size,
+		SHA256:      u.SHA256,
+	}
+	obj.Key = NewKey(prefix, obj.Filename)
+	if err := s.Store.Put(ctx, obj.Key, u.Reader(), u.Size, u.ContentType); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// NewKey returns a fresh key of the form prefix/YYYY/MM/<random>-<name>.
+func NewKey(prefix, name string) string {
+	return path.Join(prefix, time.Now().UTC().Format("2006/01"), randomHex(8)+"-"+name)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SanitizeFilename keeps only the base name of a client-supplied filename
+// and replaces anything outside letters, digits, '.', '-' and '_'.
+func SanitizeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	cleaned := strings.Trim(b.String(), "._")
+	if runes := []rune(cleaned); len(runes) > 100 {
+		ext := path.Ext(cleaned)
+		if len([]rune(ext)) > 10 {
+			ext = ""
+		}
+		cleaned = string(runes[:100-len([]rune(ext))]) + ext
+	}
+	if cleaned == "" {
+		return "file"
+	}
+	return cleaned
+}
+
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+	return nil
+}
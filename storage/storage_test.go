@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestSaveSniffsHashesAndStores(t *testing.T) {
+	store := &Memory{}
+	svc := &Service{Store: store}
+
+	obj, err := svc.Save(context.Background(), "tickets", "../../etc/фото 1.png", bytes.NewReader(pngHeader), Policy{MaxBytes: 1024, AllowedTypes: []string{"image/"}})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	sum := sha256.Sum256(pngHeader)
+	if obj.ContentType != "image/png" || obj.Size != int64(len(pngHeader)) || obj.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected object metadata: %+v", obj)
+	}
+	if obj.Filename != "фото_1.png" || !strings.HasPrefix(obj.Key, "tickets/") || !strings.HasSuffix(obj.Key, "-фото_1.png") {
+		t.Errorf("Unexpected filename or key: %+v", obj)
+	}
+
+	rc, err := store.Open(context.Background(), obj.Key)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer rc.Close()
+	if data, _ := io.ReadAll(rc); !bytes.Equal(data, pngHeader) {
+		t.Error("Stored content does not match the upload")
+	}
+}
+
+func TestSaveEnforcesPolicy(t *testing.T) {
+	svc := &Service{Store: &Memory{}}
+	ctx := context.Background()
+
+	_, err := svc.Save(ctx, "x", "big.txt", strings.NewReader(strings.Repeat("a", 11)), Policy{MaxBytes: 10})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+
+	_, err = svc.Save(ctx, "x", "photo.png", strings.NewReader("<html><script>alert(1)</script>"), Policy{AllowedTypes: []string{"image/"}})
+	if !errors.Is(err, ErrTypeNotAllowed) {
+		t.Errorf("Expected ErrTypeNotAllowed for a disguised HTML file, got %v", err)
+	}
+}
+
+func TestSaveRunsScanner(t *testing.T) {
+	var scanned []byte
+	svc := &Service{Store: &Memory{}, Scanner: ScannerFunc(func(ctx context.Context, r io.Reader) error {
+		scanned, _ = io.ReadAll(r)
+		if bytes.Contains(scanned, []byte("EICAR")) {
+			return ErrInfected
+		}
+		return nil
+	})}
+
+	if _, err := svc.Save(context.Background(), "x", "a.txt", strings.NewReader("hello"), Policy{}); err != nil || string(scanned) != "hello" {
+		t.Errorf("Scanner did not see the full content: %q, %v", scanned, err)
+	}
+	if _, err := svc.Save(context.Background(), "x", "a.txt", strings.NewReader("EICAR-TEST"), Policy{}); !errors.Is(err, ErrInfected) {
+		t.Errorf("Expected ErrInfected, got %v", err)
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	cases := map[string]string{
+		"report.pdf":             "report.pdf",
+		`C:\Users\me\cv.docx`:    "cv.docx",
+		"../../secret":           "secret",
+		"a\r\nb\".txt":           "a__b_.txt",
+		"..":                     "file",
+		"":                       "file",
+		strings.Repeat("x", 200): strings.Repeat("x", 100),
+	}
+	for in, expected := range cases {
+		if got := SanitizeFilename(in); got != expected {
+			t.Errorf("SanitizeFilename(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+}
+
+func TestLocalRejectsTraversal(t *testing.T) {
+	store := &Local{Dir: t.TempDir()}
+	if err := store.Put(context.Background(), "../escape.txt", strings.NewReader("x"), 1, "text/plain"); err == nil {
+		t.Error("Expected traversal key to be rejected")
+	}
+	if err := store.Put(context.Background(), "a/b.txt", strings.NewReader("x"), 1, "text/plain"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := store.Open(context.Background(), "a/missing.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// fakeS3 is a minimal path-style S3 stand-in.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") || r.Header.Get("X-Amz-Date") == "" {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = data
+	case http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{objects: map[string][]byte{}})
+	defer server.Close()
+
+	store := &S3{Endpoint: server.URL, Bucket: "uploads", Region: "us-east-1", AccessKey: "key", SecretKey: "secret"}
+	ctx := context.Background()
+	key := "tickets/2024/01/ab-my file+1.txt"
+
+	if err := store.Put(ctx, key, strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	rc, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "hello" {
+		t.Errorf("Unexpected content %q", data)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+}
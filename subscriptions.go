@@ -0,0 +1,674 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"LanguageLearningPlatform/money"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// subscriptionIncomplete waits for the first payment and grants nothing.
+	subscriptionIncomplete = "incomplete"
+	subscriptionTrialing   = "trialing"
+	subscriptionActive     = "active"
+	// subscriptionPastDue has an unpaid renewal order and keeps access for
+	// subscriptionGracePeriod after the period ended.
+	subscriptionPastDue   = "past_due"
+	subscriptionCancelled = "cancelled"
+	subscriptionExpired   = "expired"
+
+	featurePremiumLessons = "premium_lessons"
+
+	// incompleteSubscriptionTTL is how long a first payment may take.
+	incompleteSubscriptionTTL = 24 * time.Hour
+)
+
+// subscriptionLiveStatuses are the statuses a user can hold one of at a time.
+var subscriptionLiveStatuses = []string{subscriptionIncomplete, subscriptionTrialing, subscriptionActive, subscriptionPastDue}
+
+// subscriptionFeatures lists the entitlement keys plans may grant.
+var subscriptionFeatures = map[string]string{
+	featurePremiumLessons: "Premium lessons",
+}
+
+var subscriptionGracePeriod = 3 * 24 * time.Hour
+
+type Plan struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	Code          string    `json:"code" gorm:"uniqueIndex;not null"`
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	PriceAmount   int64     `json:"-"`
+	Currency      string    `json:"-" gorm:"size:3"`
+	Interval      string    `json:"interval"`
+	IntervalCount int       `json:"interval_count"`
+	TrialDays     int       `json:"trial_days"`
+	Features      []string  `json:"features" gorm:"type:jsonb;serializer:json"`
+	Active        bool      `json:"active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type Subscription struct {
+	ID                 uint       `json:"id" gorm:"primaryKey"`
+	UserID             uint       `json:"user_id" gorm:"index;not null"`
+	PlanID             uint       `json:"plan_id" gorm:"index;not null"`
+	Plan               *Plan      `json:"-"`
+	Status             string     `json:"status" gorm:"index;not null"`
+	CurrentPeriodStart time.Time  `json:"current_period_start"`
+	CurrentPeriodEnd   time.Time  `json:"current_period_end" gorm:"index"`
+	TrialEnd           *time.Time `json:"trial_end"`
+	CancelAtPeriodEnd  bool       `json:"cancel_at_period_end"`
+	CancelledAt        *time.Time `json:"cancelled_at"`
+	EndedAt            *time.Time `json:"ended_at"`
+	// PendingOrderID is the initial or renewal order awaiting payment.
+	PendingOrderID *uint     `json:"pending_order_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type planView struct {
+	*Plan
+	Price priceView `json:"price"`
+}
+
+type subscriptionView struct {
+	Subscription
+	Plan     *planView `json:"plan"`
+	Entitled bool      `json:"entitled"`
+	// GraceUntil is set while a past-due subscription still grants access.
+	GraceUntil *time.Time `json:"grace_until,omitempty"`
+}
+
+func newPlanView(p *Plan, locale string) *planView {
+	return &planView{Plan: p, Price: newPriceView(p.PriceAmount, p.Currency, locale)}
+}
+
+func newSubscriptionView(s Subscription, now time.Time, locale string) subscriptionView {
+	v := subscriptionView{Subscription: s, Entitled: s.entitled(now)}
+	if s.Plan != nil {
+		v.Plan = newPlanView(s.Plan, locale)
+	}
+	if s.Status == subscriptionPastDue && v.Entitled {
+		grace := s.CurrentPeriodEnd.Add(subscriptionGracePeriod)
+		v.GraceUntil = &grace
+	}
+	return v
+}
+
+// addInterval advances t by count months or years. Month ends are clamped,
+// so a period starting on 31 January ends on the last day of February.
+func addInterval(t time.Time, interval string, count int) time.Time {
+	months := count
+	if interval == "year" {
+		months = 12 * count
+	}
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return first.AddDate(0, 0, d-1)
+}
+
+// entitled reports whether s grants its plan's features at now. Unless the
+// subscription is set to cancel, access survives the period end by the
+// grace period, covering renewal payments and a lagging worker.
+func (s Subscription) entitled(now time.Time) bool {
+	switch s.Status {
+	case subscriptionTrialing, subscriptionActive:
+		if now.Before(s.CurrentPeriodEnd) {
+			return true
+		}
+		return !s.CancelAtPeriodEnd && now.Before(s.CurrentPeriodEnd.Add(subscriptionGracePeriod))
+	case subscriptionPastDue:
+		return now.Before(s.CurrentPeriodEnd.Add(subscriptionGracePeriod))
+	}
+	return false
+}
+
+func (s Subscription) hasFeature(feature string, now time.Time) bool {
+	if s.Plan == nil || !s.entitled(now) {
+		return false
+	}
+	for _, f := range s.Plan.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func loadLiveSubscription(db *gorm.DB, userID uint) (*Subscription, error) {
+	var sub Subscription
+	err := db.Preload("Plan").Where("user_id = ? AND status IN ?", userID, subscriptionLiveStatuses).
+		Order("id DESC").First(&sub).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// hasEntitlement reports whether the user behind r may use feature. Admins
+// always may.
+func hasEntitlement(r *http.Request, feature string) (bool, error) {
+	if requestUserRole(r) == "admin" {
+		return true, nil
+	}
+	userID, ok := requestUserID(r)
+	if !ok {
+		return false, nil
+	}
+	sub, err := loadLiveSubscription(Db.WithContext(r.Context()), userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error retrieving subscription: %v", err)
+	}
+	return sub.hasFeature(feature, time.Now()), nil
+}
+
+func subscriptionRequired(feature string) *apiError {
+	return newAPIError(http.StatusPaymentRequired, codeSubscriptionRequired,
+		fmt.Sprintf("%s require an active subscription", subscriptionFeatures[feature]), nil)
+}
+
+// requireEntitlement gates next behind a subscription feature. It must be
+// wrapped in authMiddleware.
+func requireEntitlement(feature string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, err := hasEntitlement(r, feature)
+		if err != nil {
+			handleError(w, r, "entitlement", internalError(err))
+			return
+		}
+		if !ok {
+			handleError(w, r, "entitlement", subscriptionRequired(feature))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type planRequest struct {
+	Code          string        `json:"code" validate:"required,max=50"`
+	Name          string        `json:"name" validate:"required,max=100"`
+	Description   string        `json:"description" validate:"max=2000"`
+	Price         money.Decimal `json:"price"`
+	Currency      string        `json:"currency" validate:"max=3"`
+	Interval      string        `json:"interval" validate:"required,oneof=month year"`
+	IntervalCount int           `json:"interval_count" validate:"min=0,max=12"`
+	TrialDays     int           `json:"trial_days" validate:"min=0,max=90"`
+	Features      []string      `json:"features" validate:"max=20"`
+	Active        bool          `json:"active"`
+}
+
+// apply validates the fields struct tags cannot express and copies the
+// request onto p.
+func (req planRequest) apply(p *Plan) error {
+	var fields []FieldError
+	if !slugPattern.MatchString(req.Code) {
+		fields = append(fields, fieldError("code", "invalid", "code may only contain lowercase letters, digits and single dashes"))
+	}
+	currency := strings.ToUpper(req.Currency)
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	var amount int64
+	if _, err := money.Lookup(currency); err != nil {
+		fields = append(fields, fieldError("currency", "oneof", "currency must be a supported ISO 4217 code"))
+	} else if req.Price == "" {
+		fields = append(fields, fieldError("price", "required", "price is required"))
+	} else if priceErrs := checkAmount("price", string(req.Price), currency, &amount); len(priceErrs) > 0 {
+		fields = append(fields, priceErrs...)
+	} else if amount == 0 {
+		fields = append(fields, fieldError("price", "min", "price must be positive"))
+	}
+	features := make([]string, 0, len(req.Features))
+	seen := make(map[string]bool)
+	for _, f := range req.Features {
+		if _, ok := subscriptionFeatures[f]; !ok {
+			fields = append(fields, fieldError("features", "oneof", fmt.Sprintf("unknown feature %q", f)))
+			continue
+		}
+		if !seen[f] {
+			seen[f] = true
+			features = append(features, f)
+		}
+	}
+	if len(fields) > 0 {
+		return validationFailed(fields...)
+	}
+	sort.Strings(features)
+
+	p.Code = req.Code
+	p.Name = req.Name
+	p.Description = req.Description
+	p.PriceAmount = amount
+	p.Currency = currency
+	p.Interval = req.Interval
+	p.IntervalCount = max(req.IntervalCount, 1)
+	p.TrialDays = req.TrialDays
+	p.Features = features
+	p.Active = req.Active
+	return nil
+}
+
+func getPlans(w http.ResponseWriter, r *http.Request) {
+	query := Db.WithContext(r.Context()).Order("price_amount, id")
+	// Inactive plans are listed to admins only.
+	if requestUserRole(r) != "admin" || r.URL.Query().Get("all") != "true" {
+		query = query.Where("active = ?", true)
+	}
+	var plans []Plan
+	if err := query.Find(&plans).Error; err != nil {
+		handleError(w, r, "getPlans", internalError(fmt.Errorf("error retrieving plans: %v", err)))
+		return
+	}
+
+	_, locale := displayPrefs(r)
+	views := make([]*planView, len(plans))
+	for i := range plans {
+		views[i] = newPlanView(&plans[i], locale)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+func savePlan(w http.ResponseWriter, r *http.Request, action string, plan *Plan, status int) {
+	var req planRequest
+	if !decodeAndValidate(w, r, action, &req) {
+		return
+	}
+	if err := req.apply(plan); err != nil {
+		handleError(w, r, action, err)
+		return
+	}
+
+	err := Db.WithContext(r.Context()).Save(plan).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		handleError(w, r, action, newAPIError(http.StatusConflict, codeConflict, "A plan with this code already exists", err))
+		return
+	}
+	if err != nil {
+		handleError(w, r, action, internalError(fmt.Errorf("error saving plan: %v", err)))
+		return
+	}
+
+	_, locale := displayPrefs(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newPlanView(plan, locale))
+	logUserAction(r.Context(), action, "success", map[string]interface{}{"plan_id": plan.ID})
+}
+
+func createPlan(w http.ResponseWriter, r *http.Request) {
+	savePlan(w, r, "createPlan", &Plan{}, http.StatusCreated)
+}
+
+// updatePlan replaces a plan. Price changes apply from the next renewal of
+// existing subscriptions; deactivating a plan only hides it from new
+// subscribers.
+func updatePlan(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "updatePlan", invalidParameter(err.Error()))
+		return
+	}
+	var plan Plan
+	if err := Db.WithContext(r.Context()).First(&plan, id).Error; err != nil {
+		handleError(w, r, "updatePlan", notFound("Plan not found", err))
+		return
+	}
+	savePlan(w, r, "updatePlan", &plan, http.StatusOK)
+}
+
+// newSubscriptionOrder creates the pending order that pays for the next
+// period of sub.
+func newSubscriptionOrder(tx *gorm.DB, sub *Subscription, plan *Plan) (*Order, error) {
+	period := fmt.Sprintf("%d %s", plan.IntervalCount, plan.Interval)
+	if plan.IntervalCount > 1 {
+		period += "s"
+	}
+	order := Order{
+		UserID:         sub.UserID,
+		Status:         orderPending,
+		Currency:       plan.Currency,
+		SubtotalAmount: plan.PriceAmount,
+		TotalAmount:    plan.PriceAmount,
+		SubscriptionID: &sub.ID,
+		Items: []OrderItem{{
+			ProductName: fmt.Sprintf("%s subscription (%s)", plan.Name, period),
+			UnitAmount:  plan.PriceAmount,
+			Quantity:    1,
+		}},
+	}
+	if err := tx.Create(&order).Error; err != nil {
+		return nil, err
+	}
+	sub.PendingOrderID = &order.ID
+	return &order, nil
+}
+
+// startSubscriptionPeriod runs when a subscription order is paid. Early
+// renewals and payments during a trial or grace period extend from the end
+// of the current period; anything else starts a period now.
+func startSubscriptionPeriod(tx *gorm.DB, order *Order) error {
+	var sub Subscription
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Plan").First(&sub, *order.SubscriptionID).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	start := now
+	switch sub.Status {
+	case subscriptionTrialing, subscriptionActive, subscriptionPastDue:
+		if sub.CurrentPeriodEnd.After(start.Add(-subscriptionGracePeriod)) {
+			start = sub.CurrentPeriodEnd
+		}
+	case subscriptionIncomplete:
+	default:
+		logger.WithField("subscription_id", sub.ID).Errorf("Payment received for a %s subscription; refund it manually", sub.Status)
+		return nil
+	}
+
+	sub.Status = subscriptionActive
+	sub.CurrentPeriodStart = start
+	sub.CurrentPeriodEnd = addInterval(start, sub.Plan.Interval, sub.Plan.IntervalCount)
+	if sub.PendingOrderID != nil && *sub.PendingOrderID == order.ID {
+		sub.PendingOrderID = nil
+	}
+	return tx.Omit("Plan").Save(&sub).Error
+}
+
+// endSubscription stops a subscription at once, used when its payment is
+// refunded.
+func endSubscription(tx *gorm.DB, id uint) error {
+	now := time.Now()
+	return tx.Model(&Subscription{}).
+		Where("id = ? AND status IN ?", id, subscriptionLiveStatuses).
+		Updates(map[string]interface{}{"status": subscriptionCancelled, "cancelled_at": now, "ended_at": now, "current_period_end": now}).Error
+}
+
+func writeSubscription(w http.ResponseWriter, r *http.Request, status int, sub *Subscription) {
+	_, locale := displayPrefs(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newSubscriptionView(*sub, time.Now(), locale))
+}
+
+func getMySubscription(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+	sub, err := loadLiveSubscription(Db.WithContext(r.Context()), userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		handleError(w, r, "getMySubscription", notFound("You have no subscription", err))
+		return
+	}
+	if err != nil {
+		handleError(w, r, "getMySubscription", internalError(fmt.Errorf("error retrieving subscription: %v", err)))
+		return
+	}
+	writeSubscription(w, r, http.StatusOK, sub)
+}
+
+// subscribe starts a subscription to a plan. The first subscription of a
+// user whose plan has a trial starts trialing; otherwise it is incomplete
+// until the returned pending order is paid through /orders/{id}/checkout.
+func subscribe(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+
+	var req struct {
+		Plan string `json:"plan" validate:"required,max=50"`
+	}
+	if !decodeAndValidate(w, r, "subscribe", &req) {
+		return
+	}
+
+	var sub Subscription
+	err := Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var plan Plan
+		if err := tx.Where("code = ? AND active = ?", req.Plan, true).First(&plan).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return validationFailed(fieldError("plan", "invalid", "plan must be the code of an available plan"))
+			}
+			return internalError(fmt.Errorf("error retrieving plan: %v", err))
+		}
+
+		// Serialise concurrent subscribe calls of the same user.
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&User{}, userID).Error; err != nil {
+			return internalError(fmt.Errorf("error retrieving user: %v", err))
+		}
+		live, err := loadLiveSubscription(tx, userID)
+		switch {
+		case err == nil && live.Status != subscriptionIncomplete:
+			return newAPIError(http.StatusConflict, codeConflict, "You already have a subscription; cancel it first", nil)
+		case err == nil:
+			if err := abandonSubscription(tx, live, subscriptionCancelled); err != nil {
+				return err
+			}
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return internalError(fmt.Errorf("error retrieving subscription: %v", err))
+		}
+
+		var trials int64
+		if err := tx.Model(&Subscription{}).Where("user_id = ? AND trial_end IS NOT NULL", userID).Count(&trials).Error; err != nil {
+			return internalError(fmt.Errorf("error checking trials: %v", err))
+		}
+
+		now := time.Now()
+		sub = Subscription{UserID: userID, PlanID: plan.ID, Plan: &plan, Status: subscriptionIncomplete, CurrentPeriodStart: now, CurrentPeriodEnd: now}
+		if plan.TrialDays > 0 && trials == 0 {
+			trialEnd := now.AddDate(0, 0, plan.TrialDays)
+			sub.Status = subscriptionTrialing
+			sub.CurrentPeriodEnd = trialEnd
+			sub.TrialEnd = &trialEnd
+		}
+		if err := tx.Omit("Plan").Create(&sub).Error; err != nil {
+			return internalError(fmt.Errorf("error creating subscription: %v", err))
+		}
+		if sub.Status == subscriptionIncomplete {
+			if _, err := newSubscriptionOrder(tx, &sub, &plan); err != nil {
+				return internalError(fmt.Errorf("error creating subscription order: %v", err))
+			}
+			if err := tx.Model(&sub).Update("pending_order_id", sub.PendingOrderID).Error; err != nil {
+				return internalError(fmt.Errorf("error updating subscription: %v", err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		handleError(w, r, "subscribe", err)
+		return
+	}
+
+	writeSubscription(w, r, http.StatusCreated, &sub)
+	logUserAction(r.Context(), "subscribe", "success", map[string]interface{}{"subscription_id": sub.ID, "plan_id": sub.PlanID, "status": sub.Status})
+}
+
+// abandonSubscription ends sub now with status and cancels its unpaid order.
+func abandonSubscription(tx *gorm.DB, sub *Subscription, status string) error {
+	now := time.Now()
+	if sub.PendingOrderID != nil {
+		var order Order
+		if err := tx.First(&order, *sub.PendingOrderID).Error; err == nil && order.Status == orderPending {
+			if err := transitionOrder(tx, &order, orderCancelled); err != nil {
+				return err
+			}
+		}
+	}
+	sub.Status = status
+	sub.EndedAt = &now
+	sub.PendingOrderID = nil
+	if status == subscriptionCancelled && sub.CancelledAt == nil {
+		sub.CancelledAt = &now
+	}
+	if err := tx.Omit("Plan").Save(sub).Error; err != nil {
+		return internalError(fmt.Errorf("error updating subscription: %v", err))
+	}
+	return nil
+}
+
+// cancelSubscription stops renewal; access continues until the period ends.
+// Incomplete subscriptions have nothing to keep and end immediately.
+func cancelSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+
+	var sub *Subscription
+	err := Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if sub, err = loadLiveSubscription(tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: clause.CurrentTable}}), userID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return notFound("You have no subscription", err)
+			}
+			return internalError(fmt.Errorf("error retrieving subscription: %v", err))
+		}
+		if sub.Status == subscriptionIncomplete {
+			return abandonSubscription(tx, sub, subscriptionCancelled)
+		}
+		now := time.Now()
+		sub.CancelAtPeriodEnd = true
+		sub.CancelledAt = &now
+		return tx.Model(sub).Updates(map[string]interface{}{"cancel_at_period_end": true, "cancelled_at": now}).Error
+	})
+	if err != nil {
+		handleError(w, r, "cancelSubscription", err)
+		return
+	}
+
+	writeSubscription(w, r, http.StatusOK, sub)
+	logUserAction(r.Context(), "cancelSubscription", "success", map[string]interface{}{"subscription_id": sub.ID})
+}
+
+// resumeSubscription undoes a cancellation that has not taken effect yet.
+func resumeSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+	sub, err := loadLiveSubscription(Db.WithContext(r.Context()), userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		handleError(w, r, "resumeSubscription", notFound("You have no subscription", err))
+		return
+	}
+	if err != nil {
+		handleError(w, r, "resumeSubscription", internalError(fmt.Errorf("error retrieving subscription: %v", err)))
+		return
+	}
+	if !sub.CancelAtPeriodEnd {
+		handleError(w, r, "resumeSubscription", newAPIError(http.StatusConflict, codeConflict, "The subscription is not set to cancel", nil))
+		return
+	}
+
+	result := Db.WithContext(r.Context()).Model(&Subscription{}).
+		Where("id = ? AND cancel_at_period_end AND status IN ?", sub.ID, subscriptionLiveStatuses).
+		Updates(map[string]interface{}{"cancel_at_period_end": false, "cancelled_at": nil})
+	if result.Error != nil {
+		handleError(w, r, "resumeSubscription", internalError(fmt.Errorf("error updating subscription: %v", result.Error)))
+		return
+	}
+	if result.RowsAffected == 0 {
+		handleError(w, r, "resumeSubscription", newAPIError(http.StatusConflict, codeConflict, "The subscription has already ended", nil))
+		return
+	}
+	sub.CancelAtPeriodEnd = false
+	sub.CancelledAt = nil
+
+	writeSubscription(w, r, http.StatusOK, sub)
+	logUserAction(r.Context(), "resumeSubscription", "success", map[string]interface{}{"subscription_id": sub.ID})
+}
+
+// runSubscriptionWorker renews, expires and ends subscriptions whose period
+// is over.
+func runSubscriptionWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := processSubscriptions(ctx, time.Now()); err != nil {
+			logger.WithError(err).Error("Subscription processing failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processSubscriptions advances every subscription due at now, one
+// transaction each, and returns how many changed:
+//   - a period or trial that ended either finishes a cancelling
+//     subscription or creates a renewal order and becomes past due;
+//   - a past-due subscription whose grace period ran out expires;
+//   - an incomplete subscription unpaid after a day expires.
+func processSubscriptions(ctx context.Context, now time.Time) (int, error) {
+	var ids []uint
+	err := Db.WithContext(ctx).Model(&Subscription{}).Where(
+		"(status IN ? AND current_period_end <= ?) OR (status = ? AND current_period_end <= ?) OR (status = ? AND created_at <= ?)",
+		[]string{subscriptionTrialing, subscriptionActive}, now,
+		subscriptionPastDue, now.Add(-subscriptionGracePeriod),
+		subscriptionIncomplete, now.Add(-incompleteSubscriptionTTL),
+	).Order("id").Limit(100).Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for _, id := range ids {
+		err := Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var sub Subscription
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Preload("Plan").First(&sub, id).Error; err != nil {
+				return err
+			}
+			return advanceSubscription(tx, &sub, now)
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			logger.WithError(err).WithField("subscription_id", id).Error("Failed to advance subscription")
+			continue
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+func advanceSubscription(tx *gorm.DB, sub *Subscription, now time.Time) error {
+	switch sub.Status {
+	case subscriptionTrialing, subscriptionActive:
+		if now.Before(sub.CurrentPeriodEnd) {
+			return nil
+		}
+		if sub.CancelAtPeriodEnd {
+			sub.Status = subscriptionCancelled
+			sub.EndedAt = &sub.CurrentPeriodEnd
+			return tx.Omit("Plan").Save(sub).Error
+		}
+		if sub.PendingOrderID == nil {
+			if _, err := newSubscriptionOrder(tx, sub, sub.Plan); err != nil {
+				return err
+			}
+		}
+		sub.Status = subscriptionPastDue
+		return tx.Omit("Plan").Save(sub).Error
+	case subscriptionPastDue:
+		if now.Before(sub.CurrentPeriodEnd.Add(subscriptionGracePeriod)) {
+			return nil
+		}
+		return abandonSubscription(tx, sub, subscriptionExpired)
+	case subscriptionIncomplete:
+		if now.Before(sub.CreatedAt.Add(incompleteSubscriptionTTL)) {
+			return nil
+		}
+		return abandonSubscription(tx, sub, subscriptionExpired)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+)
+
+func TestAddIntervalClampsMonthEnds(t *testing.T) {
+	cases := []struct {
+		start    time.Time
+		interval string
+		count    int
+		want     time.Time
+	}{
+		{time.Date(2025, 1, 31, 10, 0, 0, 0, time.UTC), "month", 1, time.Date(2025, 2, 28, 10, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC), "month", 1, time.Date(2024, 2, 29, 10, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC), "month", 3, time.Date(2025, 6, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), "year", 1, time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 11, 30, 0, 0, 0, 0, time.UTC), "month", 2, time.Date(2026, 1, 30, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		if got := addInterval(c.start, c.interval, c.count); !got.Equal(c.want) {
+			t.Errorf("addInterval(%s, %s, %d) = %s, want %s", c.start, c.interval, c.count, got, c.want)
+		}
+	}
+}
+
+func TestSubscriptionEntitlement(t *testing.T) {
+	end := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	plan := &Plan{Features: []string{featurePremiumLessons}}
+	sub := func(status string, cancelling bool) Subscription {
+		return Subscription{Status: status, Plan: plan, CurrentPeriodEnd: end, CancelAtPeriodEnd: cancelling}
+	}
+	during, inGrace, afterGrace := end.Add(-time.Hour), end.Add(time.Hour), end.Add(subscriptionGracePeriod+time.Hour)
+
+	cases := []struct {
+		name string
+		sub  Subscription
+		at   time.Time
+		want bool
+	}{
+		{"active", sub(subscriptionActive, false), during, true},
+		{"trialing", sub(subscriptionTrialing, false), during, true},
+		{"renewal pending", sub(subscriptionActive, false), inGrace, true},
+		{"cancelling ends at period end", sub(subscriptionActive, true), inGrace, false},
+		{"cancelling until period end", sub(subscriptionActive, true), during, true},
+		{"past due in grace", sub(subscriptionPastDue, false), inGrace, true},
+		{"past due after grace", sub(subscriptionPastDue, false), afterGrace, false},
+		{"incomplete", sub(subscriptionIncomplete, false), during, false},
+		{"expired", sub(subscriptionExpired, false), during, false},
+	}
+	for _, c := range cases {
+		if got := c.sub.hasFeature(featurePremiumLessons, c.at); got != c.want {
+			t.Errorf("%s: hasFeature = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	if (Subscription{Status: subscriptionActive, Plan: &Plan{}, CurrentPeriodEnd: end}).hasFeature(featurePremiumLessons, during) {
+		t.Errorf("Expected a plan without the feature not to grant it")
+	}
+}
+
+func TestPlanRequestValidation(t *testing.T) {
+	var plan Plan
+	req := planRequest{Code: "pro-monthly", Name: "Pro", Price: "9.99", Currency: "eur", Interval: "month",
+		Features: []string{featurePremiumLessons, featurePremiumLessons}}
+	if err := req.apply(&plan); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if plan.PriceAmount != 999 || plan.Currency != "EUR" || plan.IntervalCount != 1 || len(plan.Features) != 1 {
+		t.Errorf("Unexpected plan %+v", plan)
+	}
+
+	bad := planRequest{Code: "Pro Plan", Name: "Pro", Price: "0", Currency: "XXX", Interval: "month", Features: []string{"teleport"}}
+	codes := fieldCodes(bad.apply(&plan))
+	for _, field := range []string{"code", "currency", "features"} {
+		if codes[field] == "" {
+			t.Errorf("Expected an error for %s, got %v", field, codes)
+		}
+	}
+	if codes := fieldCodes(planRequest{Code: "free", Name: "Free", Price: "0", Interval: "month"}.apply(&plan)); codes["price"] != "min" {
+		t.Errorf("Expected a free plan to be rejected, got %v", codes)
+	}
+}
+
+func TestRequireEntitlementLetsAdminsThrough(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+
+	called := false
+	handler := requireEntitlement(featurePremiumLessons, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/premium/lessons", nil))
+	if rec.Code != http.StatusPaymentRequired || called {
+		t.Errorf("Expected 402 without a user, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, withClaims(httptest.NewRequest(http.MethodGet, "/api/v1/premium/lessons", nil), jwt.MapClaims{"id": float64(1), "role": "admin"}))
+	if rec.Code != http.StatusOK || !called {
+		t.Errorf("Expected an admin to pass, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Tag struct {
+	ID   uint   `json:"id" gorm:"primaryKey"`
+	Name string `json:"name"`
+	Slug string `json:"slug" gorm:"uniqueIndex"`
+}
+
+// resolveTags finds or creates a tag for each name. Names that slugify to
+// the same value collapse into one tag.
+func resolveTags(tx *gorm.DB, names []string) ([]Tag, error) {
+	tags := make([]Tag, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.Join(strings.Fields(name), " ")
+		slug := slugify(name)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		tags = append(tags, Tag{Name: name, Slug: slug})
+	}
+	if len(tags) == 0 {
+		return tags, nil
+	}
+
+	if err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "slug"}}, DoNothing: true}).Create(&tags).Error; err != nil {
+		return nil, err
+	}
+	slugs := make([]string, len(tags))
+	for i, t := range tags {
+		slugs[i] = t.Slug
+	}
+	var stored []Tag
+	if err := tx.Where("slug IN ?", slugs).Order("name").Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+type tagCount struct {
+	Tag
+	ProductCount int64 `json:"product_count"`
+}
+
+// getCatalogTags lists tags that are attached to at least one product.
+func getCatalogTags(w http.ResponseWriter, r *http.Request) {
+	var tags []tagCount
+	err := Db.WithContext(r.Context()).Model(&Tag{}).
+		Select("tags.*, count(product_tags.product_id) AS product_count").
+		Joins("JOIN product_tags ON product_tags.tag_id = tags.id").
+		Group("tags.id").
+		Order("product_count DESC, tags.name").
+		Find(&tags).Error
+	if err != nil {
+		handleError(w, r, "getCatalogTags", internalError(fmt.Errorf("error retrieving tags: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
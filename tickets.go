@@ -0,0 +1,588 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"LanguageLearningPlatform/mailer"
+	"LanguageLearningPlatform/storage"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ticketOpen     = "open"
+	ticketPending  = "pending"
+	ticketResolved = "resolved"
+
+	authorCustomer = "customer"
+	authorAgent    = "agent"
+)
+
+// ticketSLATargets maps a priority to its first-response and resolution
+// targets, measured from ticket creation.
+var ticketSLATargets = map[string][2]time.Duration{
+	"urgent": {time.Hour, 8 * time.Hour},
+	"high":   {4 * time.Hour, 24 * time.Hour},
+	"normal": {8 * time.Hour, 72 * time.Hour},
+	"low":    {24 * time.Hour, 120 * time.Hour},
+}
+
+type Ticket struct {
+	ID                 uint            `json:"id" gorm:"primaryKey"`
+	UserID             uint            `json:"user_id" gorm:"index;not null"`
+	Name               string          `json:"name"`
+	Email              string          `json:"email"`
+	Subject            string          `json:"subject"`
+	Status             string          `json:"status" gorm:"index;not null"`
+	Priority           string          `json:"priority" gorm:"index;not null"`
+	AssigneeID         *uint           `json:"assignee_id" gorm:"index"`
+	ReplyToken         string          `json:"-" gorm:"index:idx_tickets_reply_token,unique,where:reply_token <> ''"`
+	FirstResponseDueAt time.Time       `json:"first_response_due_at"`
+	ResolutionDueAt    time.Time       `json:"resolution_due_at"`
+	FirstRespondedAt   *time.Time      `json:"first_responded_at"`
+	ResolvedAt         *time.Time      `json:"resolved_at"`
+	CreatedAt          time.Time       `json:"created_at"`
+	UpdatedAt          time.Time       `json:"updated_at"`
+	Messages           []TicketMessage `json:"messages,omitempty"`
+}
+
+type TicketMessage struct {
+	ID             uint   `json:"id" gorm:"primaryKey"`
+	TicketID       uint   `json:"ticket_id" gorm:"index;index:idx_ticket_messages_email,unique,priority:1;not null"`
+	AuthorID       *uint  `json:"author_id"`
+	AuthorRole     string `json:"author_role"`
+	Body           string `json:"body" gorm:"type:text"`
+	Internal       bool   `json:"internal"`
+	EmailMessageID string `json:"-" gorm:"index:idx_ticket_messages_email,unique,priority:2,where:email_message_id <> ''"`
+	// SenderEmail and NeedsReview are set for emailed replies whose From
+	// address is neither the ticket's email nor a staff member's. Agents
+	// see them with the sender; the customer does not.
+	SenderEmail string             `json:"sender_email,omitempty"`
+	NeedsReview bool               `json:"needs_review"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Attachments []TicketAttachment `json:"attachments,omitempty"`
+}
+
+type TicketAttachment struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	TicketMessageID uint      `json:"ticket_message_id" gorm:"index;not null"`
+	Filename        string    `json:"filename"`
+	ContentType     string    `json:"content_type"`
+	Size            int64     `json:"size"`
+	StorageKey      string    `json:"-"`
+	SHA256          string    `json:"sha256"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type ticketSLA struct {
+	FirstResponseBreached bool `json:"first_response_breached"`
+	ResolutionBreached    bool `json:"resolution_breached"`
+}
+
+type ticketResponse struct {
+	Ticket
+	SLA ticketSLA `json:"sla"`
+}
+
+func (t *Ticket) applySLA() {
+	targets := ticketSLATargets[t.Priority]
+	t.FirstResponseDueAt = t.CreatedAt.Add(targets[0])
+	t.ResolutionDueAt = t.CreatedAt.Add(targets[1])
+}
+
+func (t Ticket) sla(now time.Time) ticketSLA {
+	return ticketSLA{
+		FirstResponseBreached: t.FirstRespondedAt == nil && now.After(t.FirstResponseDueAt) ||
+			t.FirstRespondedAt != nil && t.FirstRespondedAt.After(t.FirstResponseDueAt),
+		ResolutionBreached: t.ResolvedAt == nil && now.After(t.ResolutionDueAt) ||
+			t.ResolvedAt != nil && t.ResolvedAt.After(t.ResolutionDueAt),
+	}
+}
+
+func newTicketResponse(t Ticket) ticketResponse {
+	return ticketResponse{Ticket: t, SLA: t.sla(time.Now())}
+}
+
+func isStaff(r *http.Request) bool {
+	role := requestUserRole(r)
+	return role == "agent" || role == "admin"
+}
+
+// ticketAttachmentFromForm stores the optional "file" field of a ticket
+// form. parseUploadForm must have been called first.
+func ticketAttachmentFromForm(r *http.Request) ([]TicketAttachment, error) {
+	obj, err := formUpload(r.Context(), r, "file", "tickets", ticketAttachmentPolicy)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	return []TicketAttachment{newTicketAttachment(obj)}, nil
+}
+
+// discardTicketAttachments removes stored blobs whose database rows were
+// never committed.
+func discardTicketAttachments(ctx context.Context, attachments []TicketAttachment) {
+	for _, a := range attachments {
+		if err := blobs.Store.Delete(ctx, a.StorageKey); err != nil {
+			requestLogger(ctx).WithError(err).WithField("key", a.StorageKey).Warn("Failed to delete orphaned attachment")
+		}
+	}
+}
+
+func newTicketAttachment(obj *storage.Object) TicketAttachment {
+	return TicketAttachment{
+		Filename:    obj.Filename,
+		ContentType: obj.ContentType,
+		Size:        obj.Size,
+		StorageKey:  obj.Key,
+		SHA256:      obj.SHA256,
+	}
+}
+
+// loadTicket fetches a ticket the caller may see: its owner or any agent.
+// Internal notes are dropped for customers.
+func loadTicket(w http.ResponseWriter, r *http.Request, action string) (*Ticket, bool) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, action, invalidParameter(err.Error()))
+		return nil, false
+	}
+
+	staff := isStaff(r)
+	var ticket Ticket
+	err = Db.WithContext(r.Context()).
+		Preload("Messages", func(db *gorm.DB) *gorm.DB {
+			if !staff {
+				db = db.Where("internal = ? AND needs_review = ?", false, false)
+			}
+			return db.Order("created_at")
+		}).
+		Preload("Messages.Attachments").
+		First(&ticket, id).Error
+	if err != nil {
+		handleError(w, r, action, notFound("Ticket not found", err))
+		return nil, false
+	}
+
+	if userID, _ := requestUserID(r); !staff && ticket.UserID != userID {
+		handleError(w, r, action, notFound("Ticket not found", nil))
+		return nil, false
+	}
+	return &ticket, true
+}
+
+func createTicket(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requestUserID(r)
+	if !ok {
+		handleError(w, r, "createTicket", newAPIError(http.StatusUnauthorized, codeInvalidToken, "Invalid token claims", nil))
+		return
+	}
+
+	cleanup, err := parseUploadForm(w, r, ticketAttachmentPolicy)
+	if err != nil {
+		handleError(w, r, "createTicket", err)
+		return
+	}
+	defer cleanup()
+
+	form := struct {
+		Name     string `form:"name" validate:"required,max=100"`
+		Email    string `form:"email" validate:"required,email,max=100"`
+		Subject  string `form:"subject" validate:"max=200"`
+		Message  string `form:"message" validate:"required,max=5000"`
+		Priority string `form:"priority" validate:"oneof=low normal high urgent"`
+	}{
+		Name:     r.FormValue("name"),
+		Email:    r.FormValue("email"),
+		Subject:  strings.TrimSpace(r.FormValue("subject")),
+		Message:  r.FormValue("message"),
+		Priority: r.FormValue("priority"),
+	}
+	if err := validateRequest(form); err != nil {
+		handleError(w, r, "createTicket", err)
+		return
+	}
+
+	attachments, err := ticketAttachmentFromForm(r)
+	if err != nil {
+		handleError(w, r, "createTicket", err)
+		return
+	}
+
+	if form.Subject == "" {
+		form.Subject = ticketSubjectFromMessage(form.Message)
+	}
+	if form.Priority == "" {
+		form.Priority = "normal"
+	}
+
+	ticket := Ticket{
+		UserID:     userID,
+		Name:       form.Name,
+		Email:      form.Email,
+		Subject:    form.Subject,
+		Status:     ticketOpen,
+		Priority:   form.Priority,
+		ReplyToken: newReplyToken(),
+		CreatedAt:  time.Now(),
+		Messages: []TicketMessage{{
+			AuthorID:    &userID,
+			AuthorRole:  authorCustomer,
+			Body:        form.Message,
+			Attachments: attachments,
+		}},
+	}
+	ticket.applySLA()
+
+	err = Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&ticket).Error; err != nil {
+			return err
+		}
+		msg, err := renderEmail(r.Context(), "support_ticket", defaultLocale, map[string]interface{}{
+			"TicketID": ticket.ID,
+			"Name":     ticket.Name,
+			"Email":    ticket.Email,
+			"Subject":  ticket.Subject,
+			"Message":  form.Message,
+			"Priority": ticket.Priority,
+		})
+		if err != nil {
+			return err
+		}
+		msg.To = []string{supportEmail}
+		msg.ReplyTo = ticket.Email
+		return enqueueEmail(tx, msg)
+	})
+	if err != nil {
+		discardTicketAttachments(r.Context(), attachments)
+		handleError(w, r, "createTicket", internalError(fmt.Errorf("error creating ticket: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newTicketResponse(ticket))
+	logUserAction(r.Context(), "createTicket", "success", map[string]interface{}{"ticket_id": ticket.ID})
+}
+
+func ticketSubjectFromMessage(message string) string {
+	subject := strings.Join(strings.Fields(message), " ")
+	if runes := []rune(subject); len(runes) > 80 {
+		subject = string(runes[:80]) + "…"
+	}
+	return subject
+}
+
+func getMyTickets(w http.ResponseWriter, r *http.Request) {
+	userID, _ := requestUserID(r)
+
+	var tickets []Ticket
+	if err := Db.WithContext(r.Context()).Where("user_id = ?", userID).Order("updated_at DESC").Find(&tickets).Error; err != nil {
+		handleError(w, r, "getMyTickets", internalError(fmt.Errorf("error retrieving tickets: %v", err)))
+		return
+	}
+
+	views := make([]ticketResponse, len(tickets))
+	for i, t := range tickets {
+		views[i] = newTicketResponse(t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+func getTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := loadTicket(w, r, "getTicket")
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newTicketResponse(*ticket))
+}
+
+// addTicketMessage appends a reply. Customer replies reopen the ticket;
+// public agent replies mark it pending and are emailed to the customer.
+func addTicketMessage(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := loadTicket(w, r, "addTicketMessage")
+	if !ok {
+		return
+	}
+
+	cleanup, err := parseUploadForm(w, r, ticketAttachmentPolicy)
+	if err != nil {
+		handleError(w, r, "addTicketMessage", err)
+		return
+	}
+	defer cleanup()
+
+	staff := isStaff(r)
+	form := struct {
+		Body string `form:"body" validate:"required,max=5000"`
+	}{Body: r.FormValue("body")}
+	if err := validateRequest(form); err != nil {
+		handleError(w, r, "addTicketMessage", err)
+		return
+	}
+
+	attachments, err := ticketAttachmentFromForm(r)
+	if err != nil {
+		handleError(w, r, "addTicketMessage", err)
+		return
+	}
+
+	userID, _ := requestUserID(r)
+	message := TicketMessage{
+		TicketID:    ticket.ID,
+		AuthorID:    &userID,
+		AuthorRole:  authorCustomer,
+		Body:        form.Body,
+		Attachments: attachments,
+	}
+	now := time.Now()
+	updates := map[string]interface{}{"updated_at": now}
+	if staff {
+		message.AuthorRole = authorAgent
+		message.Internal = r.FormValue("internal") == "true"
+		if !message.Internal {
+			updates["status"] = ticketPending
+			if ticket.FirstRespondedAt == nil {
+				updates["first_responded_at"] = now
+			}
+		}
+	} else {
+		updates["status"] = ticketOpen
+		updates["resolved_at"] = nil
+	}
+
+	err = Db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(ticket).Updates(updates).Error; err != nil {
+			return err
+		}
+		if !staff || message.Internal {
+			return nil
+		}
+		msg, err := ticketReplyEmail(tx, r, *ticket, message)
+		if err != nil {
+			return err
+		}
+		return enqueueEmail(tx, msg)
+	})
+	if err != nil {
+		discardTicketAttachments(r.Context(), attachments)
+		handleError(w, r, "addTicketMessage", internalError(fmt.Errorf("error adding ticket message: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+	logUserAction(r.Context(), "addTicketMessage", "success", map[string]interface{}{"ticket_id": ticket.ID, "message_id": message.ID})
+}
+
+// ticketReplyEmail renders the reply notification inside tx, which also
+// stores the ticket's reply token the first time one is needed.
+func ticketReplyEmail(tx *gorm.DB, r *http.Request, ticket Ticket, message TicketMessage) (*mailer.Message, error) {
+	locale := defaultLocale
+	var customer User
+	if err := tx.Select("locale").First(&customer, ticket.UserID).Error; err == nil {
+		locale = customer.Locale
+	}
+
+	msg, err := renderEmail(r.Context(), "ticket_reply", locale, map[string]interface{}{
+		"TicketID":   ticket.ID,
+		"Name":       ticket.Name,
+		"Subject":    ticket.Subject,
+		"Message":    message.Body,
+		"TicketsURL": appBaseURL + "/profilePage",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ticket.ReplyToken == "" {
+		ticket.ReplyToken = newReplyToken()
+		if err := tx.Model(&ticket).Update("reply_token", ticket.ReplyToken).Error; err != nil {
+			return nil, err
+		}
+	}
+	msg.To = []string{ticket.Email}
+	msg.ReplyTo = replyAddress(ticket.ReplyToken)
+	msg.MessageID = replyMessageID(ticket.ReplyToken)
+	return msg, nil
+}
+
+func getTicketAttachment(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := loadTicket(w, r, "getTicketAttachment")
+	if !ok {
+		return
+	}
+	attachmentID, err := strconv.ParseUint(r.PathValue("attachmentID"), 10, 64)
+	if err != nil {
+		handleError(w, r, "getTicketAttachment", invalidParameter(fmt.Sprintf("invalid attachment id %q", r.PathValue("attachmentID"))))
+		return
+	}
+
+	for _, m := range ticket.Messages {
+		for _, a := range m.Attachments {
+			if uint64(a.ID) != attachmentID {
+				continue
+			}
+			serveBlob(w, r, "getTicketAttachment", a.StorageKey, a.Filename, a.ContentType)
+			return
+		}
+	}
+	handleError(w, r, "getTicketAttachment", notFound("Attachment not found", nil))
+}
+
+func getTicketQueue(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	page := 1
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+		page = p
+	}
+
+	params := struct {
+		Status   string `json:"status" validate:"oneof=open pending resolved"`
+		Priority string `json:"priority" validate:"oneof=low normal high urgent"`
+		Assignee string `json:"assignee"`
+		Breached string `json:"breached" validate:"oneof=true false"`
+		Query    string `json:"q" validate:"max=200"`
+	}{
+		Status:   r.URL.Query().Get("status"),
+		Priority: r.URL.Query().Get("priority"),
+		Assignee: r.URL.Query().Get("assignee"),
+		Breached: r.URL.Query().Get("breached"),
+		Query:    r.URL.Query().Get("q"),
+	}
+	if err := validateRequest(params); err != nil {
+		handleError(w, r, "getTicketQueue", err)
+		return
+	}
+
+	query := Db.WithContext(r.Context()).Model(&Ticket{})
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	} else {
+		query = query.Where("status <> ?", ticketResolved)
+	}
+	if params.Priority != "" {
+		query = query.Where("priority = ?", params.Priority)
+	}
+	switch params.Assignee {
+	case "":
+	case "none":
+		query = query.Where("assignee_id IS NULL")
+	case "me":
+		userID, _ := requestUserID(r)
+		query = query.Where("assignee_id = ?", userID)
+	default:
+		id, err := strconv.ParseUint(params.Assignee, 10, 64)
+		if err != nil {
+			handleError(w, r, "getTicketQueue", validationFailed(fieldError("assignee", "invalid", "assignee must be a user id, me or none")))
+			return
+		}
+		query = query.Where("assignee_id = ?", id)
+	}
+	if params.Breached == "true" {
+		now := time.Now()
+		query = query.Where("(first_responded_at IS NULL AND first_response_due_at < ?) OR (resolved_at IS NULL AND resolution_due_at < ?)", now, now)
+	}
+	if params.Query != "" {
+		query = query.Where("subject ILIKE ?", "%"+params.Query+"%")
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		handleError(w, r, "getTicketQueue", internalError(fmt.Errorf("error counting tickets: %v", err)))
+		return
+	}
+
+	var tickets []Ticket
+	if err := query.Order("resolution_due_at").Limit(limit).Offset((page - 1) * limit).Find(&tickets).Error; err != nil {
+		handleError(w, r, "getTicketQueue", internalError(fmt.Errorf("error retrieving tickets: %v", err)))
+		return
+	}
+
+	views := make([]ticketResponse, len(tickets))
+	for i, t := range tickets {
+		views[i] = newTicketResponse(t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":     views,
+		"page":     page,
+		"per_page": limit,
+		"total":    total,
+	})
+}
+
+func updateTicket(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		handleError(w, r, "updateTicket", invalidParameter(err.Error()))
+		return
+	}
+
+	var req struct {
+		Status     string `json:"status" validate:"oneof=open pending resolved"`
+		Priority   string `json:"priority" validate:"oneof=low normal high urgent"`
+		AssigneeID *uint  `json:"assignee_id"`
+	}
+	if !decodeAndValidate(w, r, "updateTicket", &req) {
+		return
+	}
+
+	var ticket Ticket
+	if err := Db.WithContext(r.Context()).First(&ticket, id).Error; err != nil {
+		handleError(w, r, "updateTicket", notFound("Ticket not found", err))
+		return
+	}
+
+	if req.AssigneeID != nil {
+		if *req.AssigneeID == 0 {
+			ticket.AssigneeID = nil
+		} else {
+			var assignee User
+			err := Db.WithContext(r.Context()).Where("id = ? AND role IN ?", *req.AssigneeID, []string{"agent", "admin"}).First(&assignee).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				handleError(w, r, "updateTicket", validationFailed(fieldError("assignee_id", "invalid", "assignee_id must refer to an agent")))
+				return
+			}
+			if err != nil {
+				handleError(w, r, "updateTicket", internalError(err))
+				return
+			}
+			ticket.AssigneeID = req.AssigneeID
+		}
+	}
+	if req.Priority != "" && req.Priority != ticket.Priority {
+		ticket.Priority = req.Priority
+		ticket.applySLA()
+	}
+	if req.Status != "" && req.Status != ticket.Status {
+		ticket.Status = req.Status
+		if req.Status == ticketResolved {
+			now := time.Now()
+			ticket.ResolvedAt = &now
+		} else {
+			ticket.ResolvedAt = nil
+		}
+	}
+
+	if err := Db.WithContext(r.Context()).Save(&ticket).Error; err != nil {
+		handleError(w, r, "updateTicket", internalError(fmt.Errorf("error updating ticket: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newTicketResponse(ticket))
+	logUserAction(r.Context(), "updateTicket", "success", map[string]interface{}{"ticket_id": ticket.ID, "status": ticket.Status})
+}
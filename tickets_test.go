@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTicketSLA(t *testing.T) {
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	ticket := Ticket{Priority: "high", CreatedAt: created}
+	ticket.applySLA()
+
+	if !ticket.FirstResponseDueAt.Equal(created.Add(4*time.Hour)) || !ticket.ResolutionDueAt.Equal(created.Add(24*time.Hour)) {
+		t.Fatalf("Unexpected due times: %v, %v", ticket.FirstResponseDueAt, ticket.ResolutionDueAt)
+	}
+
+	if sla := ticket.sla(created.Add(time.Hour)); sla.FirstResponseBreached || sla.ResolutionBreached {
+		t.Errorf("Expected no breach after one hour, got %+v", sla)
+	}
+	if sla := ticket.sla(created.Add(5 * time.Hour)); !sla.FirstResponseBreached || sla.ResolutionBreached {
+		t.Errorf("Expected only first response breach after five hours, got %+v", sla)
+	}
+
+	responded := created.Add(2 * time.Hour)
+	ticket.FirstRespondedAt = &responded
+	if sla := ticket.sla(created.Add(5 * time.Hour)); sla.FirstResponseBreached {
+		t.Errorf("Timely first response reported as breached: %+v", sla)
+	}
+}
+
+func TestTicketSubjectFromMessage(t *testing.T) {
+	if got := ticketSubjectFromMessage("  How do I\nreset   my password? "); got != "How do I reset my password?" {
+		t.Errorf("Unexpected subject %q", got)
+	}
+	long := ticketSubjectFromMessage(strings.Repeat("я", 100))
+	if len([]rune(long)) != 81 || !strings.HasSuffix(long, "…") {
+		t.Errorf("Expected truncated subject, got %q", long)
+	}
+}
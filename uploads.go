@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+
+	"LanguageLearningPlatform/storage"
+)
+
+// multipartOverhead is the allowance for form fields and part headers on
+// top of a policy's file size limit.
+const multipartOverhead = 1 << 20
+
+var (
+	blobs = &storage.Service{Store: &storage.Memory{}}
+
+	ticketAttachmentPolicy = storage.Policy{
+		MaxBytes:     10 << 20,
+		AllowedTypes: []string{"image/", "text/plain", "application/pdf", "application/zip"},
+	}
+	productImagePolicy = storage.Policy{
+		MaxBytes:     10 << 20,
+		AllowedTypes: []string{"image/jpeg", "image/png", "image/webp"},
+	}
+)
+
+// initStorage selects the blob backend from STORAGE_BACKEND: "local"
+// (default, under STORAGE_DIR), "s3" or "memory". CLAMAV_ADDR enables
+// malware scanning through clamd.
+func initStorage() error {
+	switch backend := getenvDefault("STORAGE_BACKEND", "local"); backend {
+	case "local":
+		blobs.Store = &storage.Local{Dir: getenvDefault("STORAGE_DIR", "uploads")}
+	case "s3":
+		blobs.Store = &storage.S3{
+			Endpoint:  getenvDefault("S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    getenvDefault("S3_REGION", "us-east-1"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Client:    &http.Client{Timeout: time.Minute},
+		}
+	case "memory":
+		blobs.Store = &storage.Memory{}
+	default:
+		return fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+
+	if addr := os.Getenv("CLAMAV_ADDR"); addr != "" {
+		blobs.Scanner = &storage.ClamAV{Addr: addr}
+	}
+	return nil
+}
+
+// parseUploadForm caps the request body before parsing it as a multipart
+// (or urlencoded) form. Parts over 8 MiB spill to temporary files, which
+// net/http only removes for the original request, not the copies handlers
+// get from withClaims, so callers must defer the returned cleanup.
+func parseUploadForm(w http.ResponseWriter, r *http.Request, policy storage.Policy) (func(), error) {
+	r.Body = http.MaxBytesReader(w, r.Body, policy.MaxBytes+multipartOverhead)
+	err := r.ParseMultipartForm(8 << 20)
+	if errors.Is(err, http.ErrNotMultipart) {
+		err = r.ParseForm()
+	}
+	cleanup := func() {
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		cleanup()
+		return nil, uploadError(storage.ErrTooLarge)
+	}
+	if err != nil {
+		cleanup()
+		return nil, newAPIError(http.StatusBadRequest, codeInvalidParameter, "Form could not be parsed", err)
+	}
+	return cleanup, nil
+}
+
+// formUpload stores the optional file in field. It returns nil when the
+// field is absent.
+func formUpload(ctx context.Context, r *http.Request, field, prefix string, policy storage.Policy) (*storage.Object, error) {
+	if r.MultipartForm == nil || len(r.MultipartForm.File[field]) == 0 {
+		return nil, nil
+	}
+	header := r.MultipartForm.File[field][0]
+	file, err := header.Open()
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, codeInvalidParameter, "Attachment could not be read", err)
+	}
+	defer file.Close()
+	return saveBlob(ctx, prefix, header.Filename, file, policy)
+}
+
+func saveBlob(ctx context.Context, prefix, filename string, r io.Reader, policy storage.Policy) (*storage.Object, error) {
+	obj, err := blobs.Save(ctx, prefix, filename, r, policy)
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	return obj, nil
+}
+
+func uploadError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrTooLarge):
+		return newAPIError(http.StatusRequestEntityTooLarge, codePayloadTooLarge, "File exceeds the size limit", err)
+	case errors.Is(err, storage.ErrTypeNotAllowed):
+		return newAPIError(http.StatusUnsupportedMediaType, codeUnsupportedMedia, "File type is not allowed", err)
+	case errors.Is(err, storage.ErrInfected):
+		return newAPIError(http.StatusUnprocessableEntity, codeUploadRejected, "File was rejected by the malware scan", err)
+	default:
+		return internalError(fmt.Errorf("failed to store upload: %v", err))
+	}
+}
+
+// serveBlob streams a stored object as a download. The sniffed content type
+// is sent with nosniff so browsers never render uploads inline.
+func serveBlob(w http.ResponseWriter, r *http.Request, action, key, filename, contentType string) {
+	rc, err := blobs.Store.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		handleError(w, r, action, notFound("File not found", err))
+		return
+	}
+	if err != nil {
+		handleError(w, r, action, internalError(err))
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, rc)
+}
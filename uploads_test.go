@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"LanguageLearningPlatform/storage"
+)
+
+func multipartRequest(t *testing.T, filename string, data []byte) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("message", "hello")
+	part, _ := mw.CreateFormFile("file", filename)
+	part.Write(data)
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestParseUploadFormRejectsOversizedBody(t *testing.T) {
+	policy := storage.Policy{MaxBytes: 10}
+	r := multipartRequest(t, "big.txt", bytes.Repeat([]byte("a"), 2*multipartOverhead))
+
+	_, err := parseUploadForm(httptest.NewRecorder(), r, policy)
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 api error, got %v", err)
+	}
+}
+
+func TestFormUploadStoresSniffedFile(t *testing.T) {
+	blobs = &storage.Service{Store: &storage.Memory{}}
+	r := multipartRequest(t, "../notes.txt", []byte("plain text notes"))
+	if _, err := parseUploadForm(httptest.NewRecorder(), r, ticketAttachmentPolicy); err != nil {
+		t.Fatalf("parseUploadForm failed: %v", err)
+	}
+
+	obj, err := formUpload(r.Context(), r, "file", "tickets", ticketAttachmentPolicy)
+	if err != nil {
+		t.Fatalf("formUpload failed: %v", err)
+	}
+	if obj.Filename != "notes.txt" || obj.ContentType != "text/plain" || obj.Size != 16 {
+		t.Errorf("Unexpected stored object: %+v", obj)
+	}
+
+	imageOnly := storage.Policy{MaxBytes: 1 << 20, AllowedTypes: []string{"image/"}}
+	r = multipartRequest(t, "photo.png", []byte("<html><body>not an image</body></html>"))
+	parseUploadForm(httptest.NewRecorder(), r, imageOnly)
+	_, err = formUpload(r.Context(), r, "file", "tickets", imageOnly)
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for disguised upload, got %v", err)
+	}
+}
+
+func TestParseUploadFormCleanupRemovesSpilledParts(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	policy := storage.Policy{MaxBytes: 10 << 20}
+	r := multipartRequest(t, "big.txt", bytes.Repeat([]byte("a"), 9<<20))
+
+	cleanup, err := parseUploadForm(httptest.NewRecorder(), r, policy)
+	if err != nil {
+		t.Fatalf("parseUploadForm failed: %v", err)
+	}
+	if spilled, _ := os.ReadDir(dir); len(spilled) == 0 {
+		t.Fatalf("Expected the large part to spill to a temporary file")
+	}
+	cleanup()
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Errorf("Expected cleanup to remove temporary files, found %d", len(left))
+	}
+}
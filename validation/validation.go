@@ -0,0 +1,243 @@
+// Package validation checks request structs against rules declared in
+// `validate` struct tags and reports every failing field at once.
+//
+// Supported rules: required, min, max, email, oneof, datetime. Rules other
+// than required are skipped for zero values, so optional fields only need
+// to be valid when they are present.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var emailRegexp = regexp.MustCompile(`(?i)^[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}$`)
+
+type FieldError struct {
+	Field   string
+	Rule    string
+	Param   string
+	Message string
+}
+
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func IsEmail(s string) bool {
+	return emailRegexp.MatchString(s)
+}
+
+// Struct validates v, which must be a struct or a pointer to one. It
+// returns nil when every field passes, Errors listing the failing fields,
+// or another error when v or its tags are malformed. Pointer fields are
+// validated through their value; a nil pointer counts as empty.
+func Struct(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("validation: expected struct, got %s", rv.Kind())
+	}
+	if err := checkType(rv.Type()); err != nil {
+		return err
+	}
+
+	var errs Errors
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag := sf.Tag.Get("validate")
+		if tag == "" || tag == "-" || !sf.IsExported() {
+			continue
+		}
+
+		name := fieldName(sf)
+		value := rv.Field(i)
+		present := !isZero(value)
+		if value.Kind() == reflect.Pointer {
+			present = !value.IsNil()
+			value = value.Elem()
+		}
+		for _, rule := range strings.Split(tag, ",") {
+			rule, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+			if rule == "required" {
+				if !present {
+					errs = append(errs, FieldError{Field: name, Rule: rule, Message: name + " is required"})
+					break
+				}
+				continue
+			}
+			if !present {
+				continue
+			}
+			if msg, ok := check(rule, param, value); !ok {
+				errs = append(errs, FieldError{Field: name, Rule: rule, Param: param, Message: name + " " + msg})
+				break
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// CheckTags reports the first malformed validate tag in v, which must be a
+// struct or a pointer to one, so tests can catch typos before a request
+// reaches them.
+func CheckTags(v interface{}) error {
+	rt := reflect.TypeOf(v)
+	if rt != nil && rt.Kind() == reflect.Pointer {
+		rt = rt.Elem()
+	}
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return fmt.Errorf("validation: expected struct, got %v", rt)
+	}
+	return checkType(rt)
+}
+
+// checkedTypes caches the result of checkType, so tags are parsed once per
+// type rather than on every request.
+var checkedTypes sync.Map
+
+func checkType(rt reflect.Type) error {
+	if cached, ok := checkedTypes.Load(rt); ok {
+		err, _ := cached.(error)
+		return err
+	}
+
+	var err error
+	for i := 0; i < rt.NumField() && err == nil; i++ {
+		sf := rt.Field(i)
+		tag := sf.Tag.Get("validate")
+		if tag == "" || tag == "-" || !sf.IsExported() {
+			continue
+		}
+		kind := sf.Type.Kind()
+		if kind == reflect.Pointer {
+			kind = sf.Type.Elem().Kind()
+		}
+		for _, rule := range strings.Split(tag, ",") {
+			rule, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+			if err = checkRule(rule, param, kind); err != nil {
+				err = fmt.Errorf("validation: %s.%s: %v", rt.Name(), sf.Name, err)
+				break
+			}
+		}
+	}
+	checkedTypes.Store(rt, err)
+	return err
+}
+
+func checkRule(rule, param string, kind reflect.Kind) error {
+	switch rule {
+	case "required":
+		return nil
+	case "min", "max":
+		if _, err := strconv.ParseFloat(param, 64); err != nil {
+			return fmt.Errorf("invalid %s limit %q", rule, param)
+		}
+		if !measurable(kind) {
+			return fmt.Errorf("%s is not supported for %s", rule, kind)
+		}
+		return nil
+	case "email", "datetime":
+		if kind != reflect.String {
+			return fmt.Errorf("%s is not supported for %s", rule, kind)
+		}
+		if rule == "datetime" && param == "" {
+			return errors.New("datetime needs a layout")
+		}
+		return nil
+	case "oneof":
+		if len(strings.Fields(param)) == 0 {
+			return errors.New("oneof needs at least one option")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown rule %q", rule)
+}
+
+func measurable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Slice, reflect.Map,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func fieldName(sf reflect.StructField) string {
+	if name, _, _ := strings.Cut(sf.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	if name := sf.Tag.Get("form"); name != "" {
+		return name
+	}
+	return sf.Name
+}
+
+func isZero(v reflect.Value) bool {
+	if v.Kind() == reflect.String {
+		return strings.TrimSpace(v.String()) == ""
+	}
+	return v.IsZero()
+}
+
+// check applies one rule other than required to a present value. Struct
+// runs checkType first, so the rule, its parameter and the value's kind are
+// known to be valid here.
+func check(rule, param string, v reflect.Value) (string, bool) {
+	switch rule {
+	case "min":
+		return compare(v, param, func(n, limit float64) bool { return n >= limit }, "at least")
+	case "max":
+		return compare(v, param, func(n, limit float64) bool { return n <= limit }, "at most")
+	case "email":
+		return "must be a valid email address", IsEmail(v.String())
+	case "oneof":
+		options := strings.Fields(param)
+		s := fmt.Sprint(v.Interface())
+		for _, option := range options {
+			if s == option {
+				return "", true
+			}
+		}
+		return "must be one of: " + strings.Join(options, ", "), false
+	case "datetime":
+		_, err := time.Parse(param, v.String())
+		return "must use the " + param + " format", err == nil
+	}
+	return "", true
+}
+
+func compare(v reflect.Value, param string, ok func(n, limit float64) bool, word string) (string, bool) {
+	limit, _ := strconv.ParseFloat(param, 64)
+	switch v.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("must be %s %s characters long", word, param), ok(float64(utf8.RuneCountInString(v.String())), limit)
+	case reflect.Slice, reflect.Map:
+		return fmt.Sprintf("must contain %s %s items", word, param), ok(float64(v.Len()), limit)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("must be %s %s", word, param), ok(float64(v.Int()), limit)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("must be %s %s", word, param), ok(float64(v.Uint()), limit)
+	case reflect.Float32, reflect.Float64:
+		return fmt.Sprintf("must be %s %s", word, param), ok(v.Float(), limit)
+	}
+	return "", true
+}
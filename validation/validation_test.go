@@ -0,0 +1,132 @@
+package validation
+
+import (
+	"errors"
+	"testing"
+)
+
+func fieldErrors(t *testing.T, err error) Errors {
+	t.Helper()
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected field errors, got %v", err)
+	}
+	return errs
+}
+
+type signup struct {
+	Name     string  `json:"name" validate:"required,min=3,max=10"`
+	Email    string  `json:"email" validate:"required,email"`
+	Role     string  `json:"role" validate:"oneof=user admin"`
+	Price    float64 `json:"price" validate:"min=0"`
+	Date     string  `json:"date" validate:"datetime=2006-01-02"`
+	Nickname string  `form:"nickname" validate:"max=5"`
+}
+
+func TestStructCollectsAllFieldErrors(t *testing.T) {
+	errs := fieldErrors(t, Struct(signup{
+		Name:     "Al",
+		Email:    "not-an-email",
+		Role:     "root",
+		Price:    -1,
+		Date:     "01/02/2025",
+		Nickname: "toolong",
+	}))
+
+	expected := map[string]string{
+		"name":     "min",
+		"email":    "email",
+		"role":     "oneof",
+		"price":    "min",
+		"date":     "datetime",
+		"nickname": "max",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(errs), errs)
+	}
+	for _, fe := range errs {
+		if expected[fe.Field] != fe.Rule {
+			t.Errorf("Unexpected error for %s: rule %s (%s)", fe.Field, fe.Rule, fe.Message)
+		}
+	}
+}
+
+func TestStructSkipsEmptyOptionalFields(t *testing.T) {
+	if errs := Struct(&signup{Name: "Alan", Email: "alan@example.com"}); errs != nil {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+}
+
+func TestStructRequired(t *testing.T) {
+	errs := fieldErrors(t, Struct(signup{Name: "   "}))
+	if len(errs) != 2 || errs[0].Rule != "required" || errs[1].Rule != "required" {
+		t.Errorf("Expected two required errors, got %v", errs)
+	}
+	if errs[0].Message != "name is required" {
+		t.Errorf("Unexpected message: %q", errs[0].Message)
+	}
+}
+
+func TestMinCountsRunes(t *testing.T) {
+	if errs := Struct(signup{Name: "Алан", Email: "alan@example.com"}); errs != nil {
+		t.Errorf("Expected Cyrillic name to pass length check, got %v", errs)
+	}
+}
+
+func TestStructDereferencesPointers(t *testing.T) {
+	type filter struct {
+		Page  *int    `json:"page" validate:"required,min=1"`
+		Sort  *string `json:"sort" validate:"oneof=new top"`
+		Email *string `json:"email" validate:"email"`
+	}
+	zero, page, sort := 0, 2, "old"
+	errs := fieldErrors(t, Struct(filter{Page: &zero, Sort: &sort}))
+	if len(errs) != 2 || errs[0].Field != "page" || errs[0].Rule != "min" || errs[1].Rule != "oneof" {
+		t.Errorf("Expected min and oneof errors, got %v", errs)
+	}
+
+	errs = fieldErrors(t, Struct(filter{}))
+	if len(errs) != 1 || errs[0].Rule != "required" {
+		t.Errorf("Expected a nil pointer to fail required only, got %v", errs)
+	}
+
+	if err := Struct(filter{Page: &page}); err != nil {
+		t.Errorf("Expected no errors, got %v", err)
+	}
+}
+
+func TestMalformedTagsReturnErrors(t *testing.T) {
+	cases := []interface{}{
+		struct {
+			Name string `validate:"requird"`
+		}{},
+		struct {
+			Age int `validate:"min=ten"`
+		}{},
+		struct {
+			Active bool `validate:"max=1"`
+		}{},
+		struct {
+			Count *int `validate:"email"`
+		}{},
+		struct {
+			Date string `validate:"datetime"`
+		}{},
+	}
+	for _, c := range cases {
+		if err := CheckTags(c); err == nil {
+			t.Errorf("Expected CheckTags to reject %T", c)
+		}
+		var errs Errors
+		if err := Struct(c); err == nil || errors.As(err, &errs) {
+			t.Errorf("Expected Struct to report the bad tag in %T, got %v", c, err)
+		}
+	}
+
+	if err := CheckTags(&signup{}); err != nil {
+		t.Errorf("Expected valid tags, got %v", err)
+	}
+	if err := Struct("not a struct"); err == nil {
+		t.Errorf("Expected an error for a non-struct")
+	}
+}